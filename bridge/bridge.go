@@ -0,0 +1,276 @@
+// Package bridge 把客户端或服务端嵌入到其他Go程序中运行
+//
+// 每个Bridge是一个独立的实例，去重、会话、链路与统计互不影响，同一进程内可以运行多个。
+// 客户端不设置本地监听地址、服务端不设置转发地址时，应用通过PacketConn、Listener在进程内收发，
+// 不再经过本地UDP端口：
+//
+//	b, err := bridge.NewClient(client.Config{
+//		Listen: "127.0.0.1:8000",
+//		Links:  []client.LinkConfig{{Local: "eth0", Remote: "203.0.113.1:9000"}, {Local: "wwan0", Remote: "203.0.113.1:9001"}},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	go b.Run(ctx)
+//	...
+//	b.Close()
+package bridge
+
+import (
+	"UDPRainbowBridge/client"
+	"UDPRainbowBridge/core"
+	"UDPRainbowBridge/server"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 没有指定时使用的最大包体与模式，与命令行的默认值一致
+const (
+	default_mtu  = 1492
+	default_mode = "mode1"
+)
+
+// 停止时等待发送队列发完的最长时间
+const shutdown_timeout = 1 * time.Second
+
+// 支持的模式
+var modes = []string{"mode1", "mode2"}
+
+// Bridge 一个嵌入运行的客户端或服务端实例
+type Bridge struct {
+	// 客户端或服务端配置，只有一项不为nil
+	client_cfg *client.Config
+	server_cfg *server.Config
+
+	// 运行中的实例，Run之前为nil
+	client atomic.Pointer[client.Client]
+	server atomic.Pointer[server.Server]
+
+	// 进程内连接（客户端）与监听（服务端），配置了本地监听地址、转发地址时为nil
+	conn     *packet_conn
+	listener *Listener
+
+	// 启动与停止锁
+	mutex sync.Mutex
+
+	// 是否已经调用过Run（mutex保护）
+	started bool
+
+	// Close时关闭，通知Run返回
+	closed     chan struct{}
+	close_once sync.Once
+}
+
+// NewClient 按配置创建客户端实例，检查配置但不创建套接字，Run时启动
+// MTU为0时使用1492，Mode为空时使用mode1；统计日志、保活、链路检查等间隔为0时不启用
+// Listen与Deliver都为空时通过PacketConn在进程内收发
+func NewClient(cfg client.Config) (*Bridge, error) {
+	if cfg.MTU == 0 {
+		cfg.MTU = default_mtu
+	}
+	if len(cfg.Mode) == 0 {
+		cfg.Mode = default_mode
+	}
+
+	if len(cfg.Links) == 0 && len(cfg.Server) == 0 && len(cfg.Discover) == 0 {
+		return nil, errors.New("需要至少一条链路、服务端地址或自动发现的网卡")
+	}
+	if err := check_common(cfg.MTU, cfg.Mode); err != nil {
+		return nil, err
+	}
+	if len(cfg.DownMode) > 0 && !slices.Contains(modes, cfg.DownMode) {
+		return nil, fmt.Errorf("不支持的下行模式: %s", cfg.DownMode)
+	}
+
+	b := &Bridge{client_cfg: &cfg, closed: make(chan struct{})}
+	if len(cfg.Listen) == 0 && cfg.Deliver == nil {
+		b.conn = new_packet_conn(Addr("client"), Addr("server"), b.client_send)
+		b.client_cfg.Deliver = b.conn.push
+	}
+	return b, nil
+}
+
+// NewServer 按配置创建服务端实例，检查配置但不创建套接字，Run时启动
+// MTU为0时使用1492，Mode为空时使用mode1；统计日志、链路超时等间隔为0时不启用
+// Forward与Deliver都为空时通过Listener在进程内收发
+func NewServer(cfg server.Config) (*Bridge, error) {
+	if cfg.MTU == 0 {
+		cfg.MTU = default_mtu
+	}
+	if len(cfg.Mode) == 0 {
+		cfg.Mode = default_mode
+	}
+
+	if len(cfg.Listen) == 0 {
+		return nil, errors.New("需要至少一个监听地址")
+	}
+	if err := check_common(cfg.MTU, cfg.Mode); err != nil {
+		return nil, err
+	}
+
+	b := &Bridge{server_cfg: &cfg, closed: make(chan struct{})}
+	if len(cfg.Forward) == 0 && cfg.Deliver == nil {
+		b.listener = new_listener(b.server_send)
+		b.server_cfg.Deliver = b.listener.deliver
+	}
+	return b, nil
+}
+
+// 检查两端共用的配置
+func check_common(mtu int, mode string) error {
+	if mtu < core.MinMTU || mtu > 65507 {
+		return fmt.Errorf("最大包体 %d 超出范围（%d-65507）", mtu, core.MinMTU)
+	}
+	if !slices.Contains(modes, mode) {
+		return fmt.Errorf("不支持的模式: %s", mode)
+	}
+	return nil
+}
+
+// Run 启动实例并阻塞，直到ctx取消或调用Close，之后停止实例（关闭全部套接字、等待所有线程退出）并返回nil
+// 启动失败（例如地址无法绑定）时返回错误；每个Bridge只能Run一次
+func (b *Bridge) Run(ctx context.Context) error {
+	b.mutex.Lock()
+	if b.started {
+		b.mutex.Unlock()
+		return errors.New("实例已经运行过")
+	}
+	b.started = true
+
+	select {
+	case <-b.closed:
+		// Run之前已经Close
+		b.mutex.Unlock()
+		return nil
+	default:
+	}
+
+	if b.client_cfg != nil {
+		c, err := client.Start("", *b.client_cfg)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+		b.client.Store(c)
+	} else {
+		s, err := server.Start("", *b.server_cfg)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+		b.server.Store(s)
+	}
+	b.mutex.Unlock()
+
+	// 全部链路超时时结束进程内监听的当前会话
+	watching := make(chan struct{})
+	if b.listener != nil {
+		go b.listener.watch(b.server.Load(), watching)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-b.closed:
+	}
+	close(watching)
+	b.stop()
+	return nil
+}
+
+// Close 平滑停止实例：等待发送队列发完（最多1秒）并通知对端会话关闭，所有线程退出后返回
+// 可以重复调用，也可以在Run之前调用
+func (b *Bridge) Close() {
+	b.close_once.Do(func() {
+		close(b.closed)
+	})
+	b.stop()
+}
+
+// 停止运行中的实例
+func (b *Bridge) stop() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if c := b.client.Load(); c != nil {
+		c.Shutdown(shutdown_timeout)
+	}
+	if s := b.server.Load(); s != nil {
+		s.Shutdown(shutdown_timeout)
+	}
+
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	if b.listener != nil {
+		b.listener.Close()
+	}
+}
+
+// Stats 实例的累计统计，客户端与服务端只有对应的一项不为nil
+type Stats struct {
+	Client *client.Stats
+	Server *server.Stats
+}
+
+// Stats 返回实例的累计统计，Run之前返回空的统计
+func (b *Bridge) Stats() Stats {
+	var stats Stats
+	if c := b.client.Load(); c != nil {
+		client_stats := c.Stats()
+		stats.Client = &client_stats
+	} else if b.client_cfg != nil {
+		stats.Client = &client.Stats{}
+	}
+	if s := b.server.Load(); s != nil {
+		server_stats := s.Stats()
+		stats.Server = &server_stats
+	} else if b.server_cfg != nil {
+		stats.Server = &server.Stats{}
+	}
+	return stats
+}
+
+// 实例尚未运行时发送返回的错误
+var err_not_running = errors.New("实例尚未运行")
+
+// 进程内连接发送上行包
+func (b *Bridge) client_send(payload []byte) error {
+	c := b.client.Load()
+	if c == nil {
+		return err_not_running
+	}
+	return c.Send(payload)
+}
+
+// 进程内监听发送下行包
+func (b *Bridge) server_send(payload []byte) error {
+	s := b.server.Load()
+	if s == nil {
+		return err_not_running
+	}
+	return s.Send(payload)
+}
+
+// PacketConn 返回客户端的进程内连接：写入的包经过聚合链路发送到服务端（忽略地址），读取服务端发来的包
+// 只有没有设置Listen与Deliver的客户端可以使用；Run之前可以获取，Run之前写入返回错误；实例停止后连接关闭
+func (b *Bridge) PacketConn() (net.PacketConn, error) {
+	if b.conn == nil {
+		return nil, errors.New("只有没有设置本地监听地址的客户端可以使用进程内连接")
+	}
+	return b.conn, nil
+}
+
+// Listener 返回服务端的进程内监听，每个客户端会话对应一个net.PacketConn
+// 只有没有设置Forward与Deliver的服务端可以使用；实例停止后监听关闭
+func (b *Bridge) Listener() (*Listener, error) {
+	if b.listener == nil {
+		return nil, errors.New("只有没有设置转发地址的服务端可以使用进程内监听")
+	}
+	return b.listener, nil
+}
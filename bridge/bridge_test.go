@@ -0,0 +1,386 @@
+package bridge
+
+import (
+	"UDPRainbowBridge/client"
+	"UDPRainbowBridge/core"
+	"UDPRainbowBridge/server"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// 等待对端收到包、会话建立等的最长时间
+const wait_timeout = 5 * time.Second
+
+// 返回一个当前空闲的本机UDP地址
+func free_addr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+// 在后台运行实例，等待启动完成，返回取消函数与Run的返回值
+func run(t *testing.T, b *Bridge) (context.CancelFunc, chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		b.Close()
+	})
+
+	deadline := time.Now().Add(wait_timeout)
+	for b.client.Load() == nil && b.server.Load() == nil {
+		select {
+		case err := <-done:
+			t.Fatalf("启动失败: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待启动超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cancel, done
+}
+
+// 本机回环地址上运行的一对客户端与服务端，服务端监听两个端口，客户端的两条链路分别连接
+type pair struct {
+	client   *Bridge
+	server   *Bridge
+	conn     net.PacketConn
+	listener *Listener
+
+	cancel_client context.CancelFunc
+	cancel_server context.CancelFunc
+	client_done   chan error
+	server_done   chan error
+}
+
+// 按两端的安全配置启动一对实例
+func start_pair(t *testing.T, server_security core.SecurityConfig, client_security core.SecurityConfig) *pair {
+	t.Helper()
+	listen := []string{free_addr(t), free_addr(t)}
+
+	s, err := NewServer(server.Config{Listen: listen, Security: server_security})
+	if err != nil {
+		t.Fatalf("创建服务端失败: %v", err)
+	}
+	c, err := NewClient(client.Config{
+		Links: []client.LinkConfig{
+			{Local: "127.0.0.1:0", Remote: listen[0]},
+			{Local: "127.0.0.1:0", Remote: listen[1]},
+		},
+		Security: client_security,
+	})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+
+	p := &pair{client: c, server: s}
+	if p.conn, err = c.PacketConn(); err != nil {
+		t.Fatal(err)
+	}
+	if p.listener, err = s.Listener(); err != nil {
+		t.Fatal(err)
+	}
+	p.cancel_server, p.server_done = run(t, s)
+	p.cancel_client, p.client_done = run(t, c)
+	return p
+}
+
+// 客户端反复发送直到服务端出现会话，返回会话连接
+func (p *pair) accept(t *testing.T) net.PacketConn {
+	t.Helper()
+	accepted := make(chan net.PacketConn, 1)
+	go func() {
+		if session, err := p.listener.Accept(); err == nil {
+			accepted <- session
+		}
+	}()
+
+	timeout := time.After(wait_timeout)
+	for {
+		p.conn.WriteTo([]byte("hello"), nil)
+		select {
+		case session := <-accepted:
+			return session
+		case <-timeout:
+			t.Fatal("等待服务端会话超时")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// 反复发送payload直到对端读到（会话建立、地址验证之前的包可能被丢弃），忽略读到的其它包
+func deliver(t *testing.T, from net.PacketConn, to net.PacketConn, payload []byte) {
+	t.Helper()
+	buf := make([]byte, 2048)
+	deadline := time.Now().Add(wait_timeout)
+	for time.Now().Before(deadline) {
+		from.WriteTo(payload, nil)
+		to.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		for {
+			n, _, err := to.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("读取失败: %v", err)
+			}
+			if bytes.Equal(buf[:n], payload) {
+				to.SetReadDeadline(time.Time{})
+				return
+			}
+		}
+	}
+	t.Fatalf("等待 %q 超时", payload)
+}
+
+// 读完连接中已经收到的包
+func drain(conn net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+}
+
+// 等待Run返回，返回值应为nil
+func wait_run(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run返回错误: %v", err)
+		}
+	case <-time.After(wait_timeout):
+		t.Fatal("等待Run返回超时")
+	}
+}
+
+func TestBridgeRoundTrip(t *testing.T) {
+	client_priv, client_pub, err := core.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_priv, server_pub, err := core.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		server_security core.SecurityConfig
+		client_security core.SecurityConfig
+		// 是否持续收发一段时间，期间客户端按发送的字节数换钥
+		rekey bool
+	}{
+		{"不认证", core.SecurityConfig{}, core.SecurityConfig{}, false},
+		{
+			"预共享密钥",
+			core.SecurityConfig{PSK: "secret", Cipher: core.CipherChaCha20},
+			core.SecurityConfig{PSK: "secret", Cipher: core.CipherChaCha20},
+			false,
+		},
+		{
+			"握手与换钥",
+			core.SecurityConfig{PrivateKey: server_priv, AllowedKeys: []string{client_pub}, Cipher: core.CipherAESGCM},
+			core.SecurityConfig{PrivateKey: client_priv, PeerKey: server_pub, Cipher: core.CipherAESGCM, RekeyBytes: 1024},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := start_pair(t, tt.server_security, tt.client_security)
+			session := p.accept(t)
+			deliver(t, p.conn, session, []byte("up"))
+			deliver(t, session, p.conn, []byte("down"))
+
+			if tt.rekey {
+				// 客户端每秒检查一次是否需要换钥，持续收发覆盖至少两次换钥
+				for i := 0; i < 25; i++ {
+					deliver(t, p.conn, session, []byte(fmt.Sprintf("up-%d", i)))
+					deliver(t, session, p.conn, []byte(fmt.Sprintf("down-%d", i)))
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+
+			if server_output, client_output := p.server.Stats().Server.Output, p.client.Stats().Client.Output; server_output == 0 || client_output == 0 {
+				t.Fatalf("服务端转发 %d 个包，客户端转发 %d 个包", server_output, client_output)
+			}
+		})
+	}
+}
+
+func TestBridgeReadDeadline(t *testing.T) {
+	p := start_pair(t, core.SecurityConfig{}, core.SecurityConfig{})
+	session := p.accept(t)
+
+	start := time.Now()
+	p.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := p.conn.ReadFrom(make([]byte, 2048)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("到达截止时间时返回 %v，应为 os.ErrDeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("截止时间100毫秒，实际等待 %v", elapsed)
+	}
+
+	// 截止时间过后连接仍然可以使用
+	p.conn.SetReadDeadline(time.Time{})
+	deliver(t, session, p.conn, []byte("after deadline"))
+}
+
+func TestBridgeCloseUnblocksReaders(t *testing.T) {
+	p := start_pair(t, core.SecurityConfig{}, core.SecurityConfig{})
+	session := p.accept(t)
+	deliver(t, session, p.conn, []byte("ready"))
+	drain(session)
+	drain(p.conn)
+
+	// 阻塞中的读取与Accept
+	read := func(conn net.PacketConn) chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := conn.ReadFrom(make([]byte, 2048))
+			result <- err
+		}()
+		return result
+	}
+	client_read := read(p.conn)
+	session_read := read(session)
+	accept := make(chan error, 1)
+	go func() {
+		_, err := p.listener.Accept()
+		accept <- err
+	}()
+
+	expect_closed := func(name string, result chan error) {
+		t.Helper()
+		select {
+		case err := <-result:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("%s 返回 %v，应为 net.ErrClosed", name, err)
+			}
+		case <-time.After(wait_timeout):
+			t.Fatalf("%s 没有被唤醒", name)
+		}
+	}
+
+	// 取消服务端的ctx
+	p.cancel_server()
+	wait_run(t, p.server_done)
+	expect_closed("服务端会话读取", session_read)
+	expect_closed("Accept", accept)
+
+	// 关闭客户端
+	p.client.Close()
+	wait_run(t, p.client_done)
+	expect_closed("客户端读取", client_read)
+	if _, err := p.conn.WriteTo([]byte("closed"), nil); err == nil {
+		t.Fatal("客户端关闭后写入成功")
+	}
+}
+
+func TestBridgeSideBySide(t *testing.T) {
+	first := start_pair(t, core.SecurityConfig{PSK: "first"}, core.SecurityConfig{PSK: "first"})
+	second := start_pair(t, core.SecurityConfig{PSK: "second"}, core.SecurityConfig{PSK: "second"})
+	first_session := first.accept(t)
+	second_session := second.accept(t)
+	deliver(t, first.conn, first_session, []byte("first ready"))
+	deliver(t, second.conn, second_session, []byte("second ready"))
+
+	// 两对实例交替发送，序列号相同，各自的去重互不影响
+	const count = 20
+	for i := 0; i < count; i++ {
+		first.conn.WriteTo([]byte(fmt.Sprintf("first-%d", i)), nil)
+		second.conn.WriteTo([]byte(fmt.Sprintf("second-%d", i)), nil)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	receive := func(session net.PacketConn, prefix string) {
+		t.Helper()
+		received := make(map[string]bool)
+		buf := make([]byte, 2048)
+		session.SetReadDeadline(time.Now().Add(wait_timeout))
+		for len(received) < count {
+			n, _, err := session.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("%s 只收到 %d 个包: %v", prefix, len(received), err)
+			}
+			payload := string(buf[:n])
+			if payload == "hello" || payload == prefix+" ready" {
+				continue
+			}
+			if !bytes.HasPrefix(buf[:n], []byte(prefix+"-")) {
+				t.Fatalf("%s 的会话收到了另一个实例的包 %q", prefix, payload)
+			}
+			received[payload] = true
+		}
+	}
+	receive(first_session, "first")
+	receive(second_session, "second")
+
+	// 关闭一个实例不影响另一个
+	first.client.Close()
+	first.server.Close()
+	deliver(t, second.conn, second_session, []byte("second alive"))
+	deliver(t, second_session, second.conn, []byte("second down"))
+}
+
+func TestBridgeConfigErrors(t *testing.T) {
+	if _, err := NewClient(client.Config{}); err == nil {
+		t.Fatal("没有链路的客户端创建成功")
+	}
+	if _, err := NewServer(server.Config{}); err == nil {
+		t.Fatal("没有监听地址的服务端创建成功")
+	}
+	if _, err := NewClient(client.Config{Links: []client.LinkConfig{{Remote: "127.0.0.1:9"}}, Mode: "mode9"}); err == nil {
+		t.Fatal("不支持的模式创建成功")
+	}
+
+	// 设置了本地监听地址、转发地址时不能使用进程内连接与监听
+	c, err := NewClient(client.Config{Listen: "127.0.0.1:0", Links: []client.LinkConfig{{Remote: "127.0.0.1:9"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PacketConn(); err == nil {
+		t.Fatal("设置了本地监听地址的客户端返回了进程内连接")
+	}
+	s, err := NewServer(server.Config{Listen: []string{"127.0.0.1:0"}, Forward: "127.0.0.1:9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Listener(); err == nil {
+		t.Fatal("设置了转发地址的服务端返回了进程内监听")
+	}
+
+	// Run之前写入返回错误，同一个实例只能Run一次
+	c, err = NewClient(client.Config{Links: []client.LinkConfig{{Local: "127.0.0.1:0", Remote: free_addr(t)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := c.PacketConn()
+	if _, err := conn.WriteTo([]byte("early"), nil); err == nil {
+		t.Fatal("Run之前写入成功")
+	}
+	run(t, c)
+	if err := c.Run(context.Background()); err == nil {
+		t.Fatal("第二次Run没有返回错误")
+	}
+}
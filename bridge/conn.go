@@ -0,0 +1,215 @@
+package bridge
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 进程内连接的接收队列长度，应用读取不及时、队列满了时丢弃新到的包（与UDP接收缓冲区满时相同）
+const conn_queue_len = 1024
+
+// Addr 进程内连接的地址
+type Addr string
+
+// Network 返回网络名称
+func (a Addr) Network() string { return "bridge" }
+
+// String 返回地址
+func (a Addr) String() string { return string(a) }
+
+// 进程内的数据包连接：写入的包交给实例发送，实例收到的包放入接收队列等待读取
+type packet_conn struct {
+	local  net.Addr
+	remote net.Addr
+
+	// 发送一个包
+	send func(payload []byte) error
+
+	// 接收队列
+	packets chan []byte
+
+	// 关闭信号与关闭原因：net.ErrClosed（本端关闭）或io.EOF（会话结束，队列中的包读完后返回）
+	closed     chan struct{}
+	close_once sync.Once
+	close_err  error
+
+	// 读写截止时间
+	read_deadline  *deadline
+	write_deadline *deadline
+
+	// 关闭时调用，可以为nil
+	on_close func()
+}
+
+// 创建进程内连接
+func new_packet_conn(local net.Addr, remote net.Addr, send func(payload []byte) error) *packet_conn {
+	return &packet_conn{
+		local:          local,
+		remote:         remote,
+		send:           send,
+		packets:        make(chan []byte, conn_queue_len),
+		closed:         make(chan struct{}),
+		read_deadline:  new_deadline(),
+		write_deadline: new_deadline(),
+	}
+}
+
+// 放入一个收到的包，payload会被复制；队列满了或连接已关闭时丢弃
+func (conn *packet_conn) push(payload []byte) {
+	select {
+	case <-conn.closed:
+		return
+	default:
+	}
+
+	select {
+	case conn.packets <- append([]byte(nil), payload...):
+	default:
+	}
+}
+
+// 按原因关闭连接
+func (conn *packet_conn) close_with(err error) {
+	conn.close_once.Do(func() {
+		conn.close_err = err
+		close(conn.closed)
+		if conn.on_close != nil {
+			conn.on_close()
+		}
+	})
+}
+
+// ReadFrom 读取一个包，p不够长时截断
+func (conn *packet_conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-conn.closed:
+		if conn.close_err != io.EOF {
+			return 0, nil, conn.close_err
+		}
+	default:
+	}
+
+	select {
+	case packet := <-conn.packets:
+		return copy(p, packet), conn.remote, nil
+	case <-conn.closed:
+		// 会话结束时先读完队列中的包
+		select {
+		case packet := <-conn.packets:
+			if conn.close_err == io.EOF {
+				return copy(p, packet), conn.remote, nil
+			}
+		default:
+		}
+		return 0, nil, conn.close_err
+	case <-conn.read_deadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo 发送一个包，地址只能是对端，忽略addr
+func (conn *packet_conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-conn.closed:
+		return 0, conn.close_err
+	case <-conn.write_deadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	if err := conn.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 关闭连接，之后的读写返回net.ErrClosed
+func (conn *packet_conn) Close() error {
+	conn.close_with(net.ErrClosed)
+	return nil
+}
+
+// LocalAddr 返回本端地址
+func (conn *packet_conn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+// SetDeadline 同时设置读写截止时间
+func (conn *packet_conn) SetDeadline(t time.Time) error {
+	conn.read_deadline.set(t)
+	conn.write_deadline.set(t)
+	return nil
+}
+
+// SetReadDeadline 设置读取截止时间，零值表示不限制
+func (conn *packet_conn) SetReadDeadline(t time.Time) error {
+	conn.read_deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline 设置写入截止时间，零值表示不限制
+func (conn *packet_conn) SetWriteDeadline(t time.Time) error {
+	conn.write_deadline.set(t)
+	return nil
+}
+
+// 截止时间：到期时关闭expired，修改时唤醒正在等待的调用
+type deadline struct {
+	mutex   sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+// 创建一个不限制的截止时间
+func new_deadline() *deadline {
+	return &deadline{expired: make(chan struct{})}
+}
+
+// 修改截止时间，已经过去的时间立即到期
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// 停止之前的定时器，定时器已经触发时等它关闭expired
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.expired
+	}
+	d.timer = nil
+
+	expired := false
+	select {
+	case <-d.expired:
+		expired = true
+	default:
+	}
+
+	if t.IsZero() {
+		if expired {
+			d.expired = make(chan struct{})
+		}
+		return
+	}
+
+	if wait := time.Until(t); wait > 0 {
+		if expired {
+			d.expired = make(chan struct{})
+		}
+		ch := d.expired
+		d.timer = time.AfterFunc(wait, func() { close(ch) })
+		return
+	}
+
+	if !expired {
+		close(d.expired)
+	}
+}
+
+// 返回到期时关闭的通道
+func (d *deadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.expired
+}
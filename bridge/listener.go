@@ -0,0 +1,138 @@
+package bridge
+
+import (
+	"UDPRainbowBridge/server"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 等待Accept的会话数量，超过时丢弃新会话的包（应用没有及时Accept）
+const accept_queue_len = 16
+
+// Listener 服务端的进程内监听，每个客户端会话对应一个net.PacketConn
+//
+// 会话从收到客户端的第一个包开始，到应用关闭该连接或者全部链路超时（见server.Config.LinkTimeout）为止，
+// 之后客户端再发来包时产生新的会话；链路超时未启用时会话只在应用关闭连接时结束
+type Listener struct {
+	// 发送下行包
+	send func(payload []byte) error
+
+	// 等待Accept的会话
+	sessions chan *packet_conn
+
+	// 会话锁
+	mutex sync.Mutex
+
+	// 当前会话，没有时为nil（会话锁保护）
+	current *packet_conn
+
+	// 会话编号，用作会话连接的对端地址（会话锁保护）
+	next_id int
+
+	// 关闭信号
+	closed     chan struct{}
+	close_once sync.Once
+}
+
+// 创建进程内监听
+func new_listener(send func(payload []byte) error) *Listener {
+	return &Listener{
+		send:     send,
+		sessions: make(chan *packet_conn, accept_queue_len),
+		closed:   make(chan struct{}),
+	}
+}
+
+// 收到客户端的包：交给当前会话，没有会话时创建一个等待Accept
+func (l *Listener) deliver(payload []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.current == nil {
+		select {
+		case <-l.closed:
+			return
+		default:
+		}
+
+		l.next_id++
+		conn := new_packet_conn(l.Addr(), Addr(fmt.Sprintf("session-%d", l.next_id)), l.send)
+		select {
+		case l.sessions <- conn:
+		default:
+			// 应用没有及时Accept
+			return
+		}
+
+		conn.on_close = func() { l.forget(conn) }
+		l.current = conn
+	}
+	l.current.push(payload)
+}
+
+// 会话结束后不再把包交给它
+func (l *Listener) forget(conn *packet_conn) {
+	l.mutex.Lock()
+	if l.current == conn {
+		l.current = nil
+	}
+	l.mutex.Unlock()
+}
+
+// 检查链路状态，全部链路超时时结束当前会话，done关闭时返回
+func (l *Listener) watch(s *server.Server, done chan struct{}) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		if s.Connected() {
+			continue
+		}
+
+		l.mutex.Lock()
+		current := l.current
+		l.mutex.Unlock()
+		if current != nil {
+			current.close_with(io.EOF)
+		}
+	}
+}
+
+// Accept 等待下一个客户端会话，监听关闭后返回net.ErrClosed
+func (l *Listener) Accept() (net.PacketConn, error) {
+	select {
+	case conn := <-l.sessions:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听与当前会话
+func (l *Listener) Close() error {
+	l.close_once.Do(func() {
+		close(l.closed)
+	})
+
+	l.mutex.Lock()
+	current := l.current
+	l.mutex.Unlock()
+	if current != nil {
+		current.Close()
+	}
+	return nil
+}
+
+// Addr 返回监听地址
+func (l *Listener) Addr() net.Addr {
+	return Addr("server")
+}
@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// 等待条件成立
+func wait_for(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(wait_timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 建立会话并等待两端协商完成
+func negotiated_pair(t *testing.T, security core.SecurityConfig) (*pair, net.PacketConn) {
+	t.Helper()
+	p := start_pair(t, security, security)
+	session := p.accept(t)
+	deliver(t, p.conn, session, []byte("up"))
+	deliver(t, session, p.conn, []byte("down"))
+	wait_for(t, "两端协商完成", func() bool {
+		return p.client.Stats().Client.Negotiated && p.server.Stats().Server.Negotiated
+	})
+	drain(session)
+	drain(p.conn)
+	return p, session
+}
+
+// 连续发送count个包后立即停止发送方所在的实例，接收方应收到全部的包
+func send_then_stop(t *testing.T, from net.PacketConn, to net.PacketConn, prefix string, count int, stop func()) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if _, err := from.WriteTo([]byte(fmt.Sprintf("%s-%d", prefix, i)), nil); err != nil {
+			t.Fatalf("发送失败: %v", err)
+		}
+	}
+	stop()
+
+	received := make(map[string]bool)
+	buf := make([]byte, 2048)
+	to.SetReadDeadline(time.Now().Add(wait_timeout))
+	for len(received) < count {
+		n, _, err := to.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("停止前发送的 %d 个包只收到 %d 个: %v", count, len(received), err)
+		}
+		received[string(buf[:n])] = true
+	}
+}
+
+var shutdown_securities = []struct {
+	name     string
+	security core.SecurityConfig
+}{
+	{"不认证", core.SecurityConfig{}},
+	{"预共享密钥", core.SecurityConfig{PSK: "secret", Cipher: core.CipherChaCha20}},
+}
+
+func TestClientShutdown(t *testing.T) {
+	for _, tt := range shutdown_securities {
+		t.Run(tt.name, func(t *testing.T) {
+			p, session := negotiated_pair(t, tt.security)
+
+			// 发送队列中的包在停止前发完
+			send_then_stop(t, p.conn, session, "up", 50, p.client.Close)
+			wait_run(t, p.client_done)
+
+			// 服务端收到会话关闭帧，清除全部链路的客户端地址与协商结果
+			wait_for(t, "服务端清除客户端", func() bool {
+				stats := p.server.Stats().Server
+				for _, link := range stats.Links {
+					if len(link.Client) > 0 || link.Validated {
+						return false
+					}
+				}
+				return !stats.Negotiated
+			})
+			if p.server.server.Load().Connected() {
+				t.Fatal("客户端关闭会话后服务端仍有可用链路")
+			}
+		})
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	for _, tt := range shutdown_securities {
+		t.Run(tt.name, func(t *testing.T) {
+			p, session := negotiated_pair(t, tt.security)
+
+			// 发送队列中的包在停止前发完
+			send_then_stop(t, session, p.conn, "down", 50, p.cancel_server)
+			wait_run(t, p.server_done)
+
+			// 客户端收到会话关闭帧，清除协商结果，重新请求参数
+			wait_for(t, "客户端清除协商结果", func() bool {
+				return !p.client.Stats().Client.Negotiated
+			})
+		})
+	}
+}
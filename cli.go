@@ -0,0 +1,132 @@
+package main
+
+import (
+	"UDPRainbowBridge/core"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// keygen：生成一对X25519密钥
+func run_keygen(args []string) error {
+	flags := new_flag_set("keygen", "生成一对X25519密钥，私钥用于本端 -key，公钥交给对端（客户端的 -peer-key 或服务端的 -allow-keys）")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := core.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("生成密钥失败: %v", err)
+	}
+	fmt.Println("私钥:", privateKey)
+	fmt.Println("公钥:", publicKey)
+	return nil
+}
+
+// status：通过管理接口查询运行中的实例
+func run_status(args []string) error {
+	flags := new_flag_set("status", "通过管理接口查询运行中的实例的链路、会话与协商状态")
+	admin := flags.String("admin", "", "管理接口地址，为空时使用 -config 中的 observability.admin 参数值示例：127.0.0.1:9100")
+	configPath := flags.String("config", "", "可选，运行中的实例使用的配置文件，用于获取管理接口地址")
+	name := flags.String("instance", "", "可选，只查询指定名称的实例（配置文件包含多个实例时使用）")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	addr := *admin
+	if len(addr) == 0 && len(*configPath) > 0 {
+		cfg, err := load_config(*configPath)
+		if err != nil {
+			return fmt.Errorf("读取配置文件失败: %v", err)
+		}
+		addr = cfg.Observability.Admin
+	}
+	if len(addr) == 0 {
+		return errors.New("需要 -admin 或配置了 observability.admin 的 -config")
+	}
+
+	command := "status"
+	if len(*name) > 0 {
+		command += " " + *name
+	}
+	reply, err := admin_request(addr, command)
+	if err != nil {
+		return err
+	}
+	fmt.Print(reply)
+	return nil
+}
+
+// 向管理接口发送一条命令并返回全部回复
+func admin_request(addr string, command string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("连接管理接口 %s 失败: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", fmt.Errorf("发送命令失败: %v", err)
+	}
+
+	reply, err := io.ReadAll(bufio.NewReader(conn))
+	if err != nil {
+		return "", fmt.Errorf("读取回复失败: %v", err)
+	}
+	return string(reply), nil
+}
+
+// validate：检查配置，解析全部地址并检查网卡与端口是否可用，不启动转发
+func run_validate(args []string) error {
+	var source config_source
+
+	if len(args) > 0 && (args[0] == "server" || args[0] == "client") {
+		// 与运行时相同的参数：validate client -config client.json -send wwan0
+		var err error
+		if source, err = parse_bridge_flags(args[0], args[1:]); err != nil {
+			return err
+		}
+	} else {
+		flags := new_flag_set("validate", "检查配置并解析全部地址、检查网卡与端口是否可用，不发送任何流量\n也可以写成 validate server|client [运行参数]，检查与运行时完全相同的配置")
+		configPath := flags.String("config", "", "JSON配置文件")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if len(*configPath) == 0 {
+			flags.Usage()
+			return errors.New("需要 -config，或者写成 validate server|client [运行参数]")
+		}
+		source = config_source{path: *configPath, opt: default_config(), set: map[string]bool{}}
+	}
+
+	cfg, err := source.load()
+	if err != nil {
+		return err
+	}
+
+	// 配置文件包含多个实例时逐个检查
+	failed := 0
+	for _, instance_cfg := range cfg.instance_configs() {
+		if len(instance_cfg.Name) > 0 {
+			fmt.Printf("配置格式检查通过（实例 %s，%s）\n", instance_cfg.Name, instance_cfg.Role)
+		} else {
+			fmt.Printf("配置格式检查通过（%s）\n", instance_cfg.Role)
+		}
+		failed += check_environment(instance_cfg, os.Stdout)
+	}
+	if len(cfg.Instances) > 0 {
+		c := &env_checker{out: os.Stdout}
+		check_admin_environment(cfg, c)
+		failed += c.failed
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 项检查失败", failed)
+	}
+	fmt.Println("全部检查通过")
+	return nil
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// 未指定发送地址时使用的本地地址：由系统选择地址与端口
+const any_local_addr = ":0"
+
+// 收到服务端参数后多久重新请求一次，服务端修改监听端口后客户端可以跟上
+const config_refresh_interval = 5 * time.Minute
+
+// 被服务端拒绝后多久重新请求一次，服务端修改配置后客户端可以恢复
+const config_refused_retry = 10 * time.Second
+
+// 参数请求线程：请求携带本端能力，协商成功之前每秒在所有链路上请求一次（被拒绝后每10秒），之后定期刷新
+func (c *Client) config_thread() {
+	for {
+		// 每条链路上的请求分别生成，计数器不同，不会被服务端当作重放丢弃
+		for _, link := range c.current_links() {
+			request, err := c.engine.NewConfigRequest(*c.local_hello.Load())
+			if err != nil {
+				fmt.Println("生成参数请求失败:", err)
+				return
+			}
+			if request == nil {
+				break
+			}
+			if err := c.write_link(link, request); err != nil && err != err_link_down {
+				fmt.Printf("套接字 %d 请求服务端参数失败：%v\n", link.id, err)
+			}
+		}
+
+		wait := 1 * time.Second
+		if c.negotiated.Load() {
+			wait = config_refresh_interval
+		} else if c.refused.Load() {
+			wait = config_refused_retry
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-c.config_wake:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 让参数请求线程立即重新请求一次
+func (c *Client) request_config() {
+	select {
+	case c.config_wake <- struct{}{}:
+	default:
+	}
+}
+
+// 处理服务端下发的参数：先完成能力协商，只使用-server时再交给链路检查线程按监听地址增删链路
+func (c *Client) handle_config(link *Link, buf []byte) {
+	params, err := c.engine.OpenConfigResponse(buf)
+	if err != nil {
+		link.auth_failed()
+		return
+	}
+
+	if !c.negotiate_server(params) {
+		return
+	}
+
+	// 服务端建议的保活间隔更短时使用服务端的，保证在服务端的超时时间内有包到达
+	if recommended := params.Keepalive(); recommended > 0 {
+		if current := c.keepalive_period(); current <= 0 || recommended < current {
+			c.keepalive_interval.Store(int64(recommended))
+			fmt.Printf("使用服务端建议的保活间隔：%v\n", recommended)
+		}
+	}
+
+	if len(c.server_addr) > 0 {
+		c.pending_params.Store(params)
+	}
+}
+
+// 补全服务端监听地址：主机部分为空时使用连接服务端的主机
+func (c *Client) endpoint_addr(endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if len(host) == 0 {
+		host, _, _ = net.SplitHostPort(c.server_addr)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// 应用服务端参数（只在持有链路变更锁时调用）
+func (c *Client) apply_server_params() {
+	params := c.pending_params.Swap(nil)
+	if params == nil {
+		return
+	}
+
+	var endpoints []string
+	for _, endpoint := range params.Endpoints {
+		addr, err := c.endpoint_addr(endpoint)
+		if err != nil {
+			fmt.Printf("服务端监听地址 %s 无效：%v\n", endpoint, err)
+			continue
+		}
+		endpoints = append(endpoints, addr)
+	}
+	if len(endpoints) == 0 || slices.Equal(endpoints, c.config_endpoints) {
+		return
+	}
+
+	fmt.Printf("[%s] 服务端监听地址：%v\n", time.Now().Format("2006-01-02 15:04:05"), endpoints)
+	c.config_endpoints = endpoints
+
+	c.reconcile_links(endpoints)
+}
+
+// 按服务端监听地址增删链路，自动发现的链路全部重建，重新分散到新的监听地址上
+func (c *Client) reconcile_links(endpoints []string) {
+	for _, link := range c.current_links() {
+		if link.discovered {
+			c.remove_link(link)
+		}
+	}
+
+	c.sync_static_links(c.endpoint_links(endpoints))
+
+	if len(c.discover_patterns) > 0 {
+		c.discover_remotes = nil
+		for _, endpoint := range endpoints {
+			c.discover_remotes = append(c.discover_remotes, LinkConfig{Remote: endpoint})
+		}
+		c.sync_discovered_links()
+	}
+}
+
+// 链路配置与服务端监听地址按顺序配对，数量不一致时较短的一方循环使用；只使用自动发现时不创建固定链路
+func (c *Client) endpoint_links(endpoints []string) []LinkConfig {
+	count := max(len(endpoints), len(c.config_links))
+	if len(c.config_links) == 0 && len(c.discover_patterns) > 0 {
+		count = 0
+	}
+
+	configs := make([]LinkConfig, count)
+	for index := range configs {
+		cfg := LinkConfig{Local: any_local_addr}
+		if len(c.config_links) > 0 {
+			cfg = c.config_links[index%len(c.config_links)]
+		}
+		cfg.Remote = endpoints[index%len(endpoints)]
+		configs[index] = cfg
+	}
+	return configs
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package client
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// 权限不足时的提示只输出一次
+var bind_device_warn_once sync.Once
+
+// 返回把套接字绑定到指定网卡的控制函数（SO_BINDTODEVICE），保证包从该网卡发出而不受路由表影响
+// 没有CAP_NET_RAW权限时退化为只绑定该网卡的地址
+func bind_device_control(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if err != nil {
+			return err
+		}
+
+		if bindErr == syscall.EPERM {
+			bind_device_warn_once.Do(func() {
+				fmt.Println("警告：没有权限使用SO_BINDTODEVICE，链路只绑定网卡地址，出口仍由路由表决定")
+			})
+			return nil
+		}
+		return bindErr
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package client
+
+import "syscall"
+
+// 非Linux系统不支持SO_BINDTODEVICE，只绑定网卡地址
+func bind_device_control(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
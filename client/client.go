@@ -2,152 +2,278 @@ package client
 
 import (
 	"UDPRainbowBridge/core"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// InterfaceAddress 用于存储网络接口的名称和IPv4地址
-type InterfaceAddress struct {
-	NAME string
-	IPV4 string
-}
-
+// 每条链路发送队列的长度
 const send_queue_max_len = 1024
 
-// 全局的序列号映射表和互斥锁
-var (
+// Client 一个客户端实例，同一进程内可以运行多个，去重、会话、链路与统计都互不影响
+type Client struct {
+	// 实例名称，日志与管理接口中区分实例
+	name string
+
+	// 去重、帧认证/加密、握手与混淆状态
+	engine *core.Engine
+
 	// 序列号锁
-	index_mutex = sync.Mutex{}
+	index_mutex sync.Mutex
 
 	// 地址锁
-	addr_mutex = sync.Mutex{}
+	addr_mutex sync.Mutex
 
 	// 本地监听套接字与对端端口结构体
 	local_addr_record *core.RecordSocket
 
-	// 聚合连接
-	sockets []*net.UDPConn
+	// 聚合链路（links_mutex保护，只整体替换）
+	links []*Link
+
+	// 链路集合锁，链路集合只整体替换，不在原切片上修改，读到的快照可以无锁遍历
+	links_mutex sync.RWMutex
+
+	// 下一个新链路的编号
+	next_link_id int
+
+	// 链路变更锁：链路检查线程的每一轮检查与重新加载配置互斥，链路的增删、重建不会交错
+	link_change_mutex sync.Mutex
+
+	// 链路检查间隔，0表示不检查（原子操作）
+	link_check_interval atomic.Int64
+
+	// 远程域名重新解析间隔，0表示只在创建链路时解析（链路变更锁保护）
+	resolve_interval time.Duration
+
+	// 端口跳变间隔，0表示不跳变
+	// 部分运营商会对长时间不变的UDP五元组限速，定期更换源端口与目标端口可以避开
+	hop_interval time.Duration
+
+	// 保活间隔，0表示不发送保活帧
+	// 链路空闲时运营商的NAT映射会过期，之后服务端的下行包无法到达；
+	// 客户端在每条链路空闲超过该间隔时发送一个保活帧，维持映射并让服务端知道链路仍然可用
+	// 收到服务端建议的更短间隔后会被替换（原子操作）
+	keepalive_interval atomic.Int64
 
-	// 命中包统计
-	hit_counts []int
+	// 最大包体，链路读取缓存按它分配
+	link_mtu int
 
 	// 命中统计锁
-	hit_mutex = sync.Mutex{}
+	hit_mutex sync.Mutex
 
-	// 聚合端口发送队列组()
-	send_queue [][send_queue_max_len][]byte
+	// 上行模式（string，重新加载配置时替换）
+	mode atomic.Value
 
-	// 监听顿口发送队列指针列表，第一位代表当前数据位置，第二位代表当前已经发送数据位置
-	send_queue_point_list [][2]int64
+	// 统计日志间隔，0表示不输出（原子操作）
+	stats_interval atomic.Int64
 
-	// 模式
-	mode string
-)
+	// 服务端地址，设置后从服务端获取监听地址等参数，为空表示使用-r配置的远程地址
+	server_addr string
 
-// 使用传入的字符串地址创建udp套接字
-func create_cluster_socket(remote_addr_list []string, send_addr_list []string) []*net.UDPConn {
-	var sockets []*net.UDPConn
-	for index, local_ip := range send_addr_list {
-		// 本地地址
-		localAddr, err_resolveLocal := net.ResolveUDPAddr("udp", local_ip)
+	// 与服务端监听地址按顺序配对的链路配置（不含远程地址）
+	config_links []LinkConfig
 
-		if err_resolveLocal != nil {
-			fmt.Println("解析本地地址失败:", err_resolveLocal)
-			continue
-		}
+	// 收到的服务端参数，等待链路检查线程应用
+	pending_params atomic.Pointer[core.ServerParams]
 
-		// 远程地址
-		remoteAddr, err_resolveRemote := net.ResolveUDPAddr("udp", remote_addr_list[index])
+	// 当前使用的服务端监听地址（链路变更锁保护）
+	config_endpoints []string
 
-		if err_resolveRemote != nil {
-			fmt.Println("解析远程地址失败:", err_resolveRemote)
-			continue
-		}
+	// 唤醒参数请求线程立即请求一次，例如本端能力在重新加载配置后发生变化
+	config_wake chan struct{}
+
+	// 自动发现网卡的名称匹配模式（path.Match语法），为空表示不启用自动发现
+	discover_patterns []string
+
+	// 自动发现的链路可以使用的远程地址与地址族（只使用Remote与Family）
+	discover_remotes []LinkConfig
+
+	// 本端能力，启动时生成，重新加载配置修改模式后整体替换
+	local_hello atomic.Pointer[core.Hello]
+
+	// 是否已与服务端协商成功
+	negotiated atomic.Bool
+
+	// 平滑停止中，不再接收本地包
+	input_closed atomic.Bool
 
-		// 创建链接
-		conn, err := net.DialUDP("udp", localAddr, remoteAddr)
+	// 是否被服务端拒绝（或与服务端不兼容），被拒绝时不再发送本地包
+	refused atomic.Bool
+
+	// 协商出的最大包体，0表示尚未协商（原子操作）
+	agreed_mtu int64
+
+	// 超过协商出的最大包体被丢弃的本地包统计（原子操作）
+	oversize_drop_count int64
+
+	// 协商结果日志锁，多条链路会同时收到回复，只在结果变化时输出
+	negotiate_mutex sync.Mutex
+
+	// 上一次的协商结果与拒绝原因（由协商结果日志锁保护）
+	last_agreement *core.Agreement
+	last_refused   string
+
+	// 当前生效的配置（链路变更锁保护），重新加载时与新配置比较
+	running_config Config
+
+	// 收到的包交给调用方，不转发到本地监听地址（嵌入运行时使用，为nil时不启用）
+	deliver func(payload []byte)
+
+	// 发送锁，本地包的编码与入队只有一个写入方
+	send_mutex sync.Mutex
+
+	// 累计统计：从本地应用收到的包、转发给本地应用的包（原子操作）
+	input_total  int64
+	output_total int64
+
+	// 实例停止信号，Close时关闭
+	done chan struct{}
+
+	// 保证只停止一次
+	close_once sync.Once
+
+	// 后台线程，Close时等待全部退出
+	threads sync.WaitGroup
+
+	// 读取线程正在使用的链路套接字（包括退役中的），Close时全部关闭
+	sockets       map[*net.UDPConn]struct{}
+	sockets_mutex sync.Mutex
+}
+
+// 按链路配置创建聚合链路
+// 本地地址无法绑定（端口被占用或没有权限）时返回错误，其它原因创建失败的链路也会保留，由链路检查线程在地址可用后重建
+func (c *Client) create_cluster_socket(configs []LinkConfig) error {
+	for index, cfg := range configs {
+		link, err := new_link(cfg)
 		if err != nil {
-			fmt.Println("创建udp套接字失败:", err)
-			continue
+			return err
 		}
 
-		// 添加到数组后面
-		sockets = append(sockets, conn)
+		conn, err := dial_link(link)
+		if err != nil && bind_failed(err) {
+			return fmt.Errorf("链路 %d 绑定 %s 失败: %v", index, cfg.Local, err)
+		}
+		if err != nil {
+			fmt.Printf("链路 %d 创建失败，稍后重试：%v\n", index, err)
+			link.down = true
+		} else {
+			c.attach(link, conn)
+			fmt.Printf("创建udp套接字，对端ip：%s 本地ip：%s\n", conn.RemoteAddr().String(), conn.LocalAddr().String())
+		}
 
-		fmt.Printf("创建udp套接字，对端ip：%s 本地ip：%s\n", remoteAddr.String(), localAddr.String())
+		// 添加到链路集合
+		c.add_link(link)
 	}
-	return sockets
+	return nil
 }
 
-// 监听集群套接字信息
-func handle_cluster_socket_info(socket *net.UDPConn, index int, mtu int) {
+// 监听集群套接字信息，每个套接字一个线程，套接字关闭（链路重建、被移除）或退役到期后退出
+func (c *Client) handle_cluster_socket_info(link *Link, socket *net.UDPConn) {
 	defer socket.Close()
+	if !c.track_socket(socket) {
+		return
+	}
+	defer c.untrack_socket(socket)
 
-	// 缓存
-	buf := make([]byte, mtu)
+	// 缓存（需要额外容纳帧头、认证标签与混淆开销）
+	buf := make([]byte, c.link_mtu+c.engine.FrameOverhead()+c.engine.ObfsOverhead())
 
 	// 循环读取数据
 	for {
 		n, _, err := socket.ReadFromUDP(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+				return
+			}
 			fmt.Println("读取数据失败:", err)
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
-		if n < 4 {
+		// 还原混淆
+		packet, _, ok := c.engine.Deobfuscate(buf[:n])
+		if !ok {
+			link.auth_failed()
+			continue
+		}
+
+		if len(packet) < core.SeqLen {
 			fmt.Println("数据包长度小于4字节，丢弃。")
 			continue
 		}
 
-		// 取前四个字节解析序列号
-		seq := string(buf[:4])
+		// 控制帧单独处理
+		if core.IsControlFrame(packet) {
+			c.handle_control_frame(link, packet)
+			continue
+		}
+
+		// 认证失败的包直接丢弃
+		frame, ok := c.engine.DecodeFrame(packet)
+		if !ok {
+			link.auth_failed()
+			continue
+		}
+
+		// 帧头中的序列号
+		seq := frame.Seq
 
 		// 判断序列号是否有效
-		index_mutex.Lock()
-		if !core.IndexIsValid(seq) {
+		c.index_mutex.Lock()
+		if !c.engine.IndexIsValid(seq) {
 			// fmt.Println("序列号无效:", seq)
-			index_mutex.Unlock()
+			c.index_mutex.Unlock()
 			continue
 		}
 
 		// 记录包序号
-		core.RecordIndex(seq)
+		c.engine.RecordIndex(seq)
 
 		// 释放锁
-		index_mutex.Unlock()
+		c.index_mutex.Unlock()
+
+		// 增加命中统计
+		c.hit_mutex.Lock()
+		link.hit_count++
+		c.hit_mutex.Unlock()
+		atomic.AddInt64(&link.hit_total, 1)
+
+		// 嵌入运行时直接交给调用方，不经过本地监听套接字
+		if c.deliver != nil {
+			c.deliver(frame.Payload)
+			atomic.AddInt64(&c.output_total, 1)
+			continue
+		}
 
 		// 判断local_addr_record是否为空
-		if local_addr_record == nil {
+		if c.local_addr_record == nil {
 			fmt.Println("local_addr_record为空，丢弃数据包。")
 			continue
 		}
 
-		// 增加命中统计
-		hit_mutex.Lock()
-		hit_counts[index]++
-		hit_mutex.Unlock()
-
 		// 将数据转发到本地监听端口
-		if local_addr_record != nil {
+		if c.local_addr_record != nil {
 			// 使用local_addr_record获取地址
-			addr_mutex.Lock()
+			c.addr_mutex.Lock()
 
 			// 判断Addr是否有值
-			if len(local_addr_record.Addr) == 0 {
+			if len(c.local_addr_record.Addr) == 0 {
 				// 地址为空值，不发送
-				addr_mutex.Unlock()
+				c.addr_mutex.Unlock()
 				continue
 			}
 
-			remoteAddr, _ := net.ResolveUDPAddr("udp", local_addr_record.Addr)
-			addr_mutex.Unlock()
-			_, sendErr := local_addr_record.Socket.WriteToUDP([]byte(buf[4:n]), remoteAddr)
+			remoteAddr, _ := net.ResolveUDPAddr("udp", c.local_addr_record.Addr)
+			c.addr_mutex.Unlock()
+			_, sendErr := c.local_addr_record.Socket.WriteToUDP(frame.Payload, remoteAddr)
 			if sendErr != nil {
 				fmt.Println("转发数据包失败:", sendErr)
 			} else {
+				atomic.AddInt64(&c.output_total, 1)
 				// 打印日志输出
 				// fmt.Printf("转发数据包到本地监听端口:%s\n", local_addr_record.Addr)
 			}
@@ -155,219 +281,391 @@ func handle_cluster_socket_info(socket *net.UDPConn, index int, mtu int) {
 	}
 }
 
+// 处理服务端发来的控制帧
+func (c *Client) handle_control_frame(link *Link, buf []byte) {
+	switch core.ControlType(buf) {
+	case core.ControlHandshakeResp:
+		established, err := c.engine.HandleHandshakeResponse(buf)
+		if err != nil {
+			link.auth_failed()
+			fmt.Printf("套接字 %d 处理握手响应失败：%v\n", link.id, err)
+			return
+		}
+
+		if established {
+			fmt.Printf("握手完成，会话已建立（套接字 %d）\n", link.id)
+		}
+	case core.ControlConfig:
+		c.handle_config(link, buf)
+	case core.ControlClose:
+		c.handle_server_close(link, buf)
+	case core.ControlRekey:
+		if c.engine.AcceptRekeyRequest(buf) {
+			// 服务端重启后协商结果也已丢失，握手完成后重新请求参数
+			fmt.Printf("[%s] %s服务端不认识当前会话（可能已重启），重新握手（套接字 %d）\n", time.Now().Format("2006-01-02 15:04:05"), c.tag(), link.id)
+			c.negotiated.Store(false)
+			c.request_config()
+		}
+	case core.ControlCookieChallenge:
+		// 原样应答服务端的地址验证质询，证明本链路地址可达
+		echo := core.CookieEcho(buf)
+		if echo == nil {
+			return
+		}
+		if err := c.write_link(link, echo); err != nil {
+			fmt.Printf("套接字 %d 应答地址验证失败：%v\n", link.id, err)
+		}
+	default:
+		link.auth_failed()
+	}
+}
+
+// 握手线程：建立会话，并按时间或流量定期换钥
+func (c *Client) handshake_thread(rekey_interval time.Duration, rekey_bytes uint64) {
+	for {
+		init, err := c.engine.NewHandshakeInit()
+		if err != nil {
+			fmt.Println("发起握手失败:", err)
+			return
+		}
+
+		// 在所有链路上发送并重试，任意一条链路收到响应即可，单条链路故障不影响会话建立
+		for retry := 0; c.engine.HandshakePending(); retry++ {
+			if retry%10 == 0 {
+				for _, link := range c.current_links() {
+					if err := c.write_link(link, init); err != nil && err != err_link_down {
+						fmt.Printf("套接字 %d 发送握手失败：%v\n", link.id, err)
+					}
+				}
+			}
+
+			if !c.sleep(100 * time.Millisecond) {
+				return
+			}
+		}
+
+		// 等待下一次换钥
+		for !c.engine.RekeyDue(rekey_interval, rekey_bytes) {
+			if !c.sleep(1 * time.Second) {
+				return
+			}
+		}
+	}
+}
+
 // 创建本地监听套接字
-func create_local_socket(listen_addr string) bool {
+func (c *Client) create_local_socket(listen_addr string) error {
 	// 创建本地监听套接字
 	listenUdpAddr, err_resolve := net.ResolveUDPAddr("udp", listen_addr)
 
 	if err_resolve != nil {
-		fmt.Println("解析本地监听地址失败:", err_resolve)
-		return false
+		return fmt.Errorf("解析本地监听地址失败: %v", err_resolve)
 	}
 
 	conn, err := net.ListenUDP("udp", listenUdpAddr)
+	if err != nil {
+		return fmt.Errorf("创建本地监听套接字失败: %v", err)
+	}
 
 	// 创建RecordSocket结构体
-	local_addr_record = &core.RecordSocket{
+	c.local_addr_record = &core.RecordSocket{
 		Socket: conn,
 		Addr:   "",
 	}
 
-	if err != nil {
-		fmt.Println("创建本地监听套接字失败:", err)
-		return false
-	}
-
 	fmt.Printf("创建本地监听套接字：%s\n", listenUdpAddr.String())
 
-	return true
+	return nil
 }
 
 // 处理本地监听套接字信息
-func handle_local_socket_info(mtu int) {
-	defer local_addr_record.Socket.Close()
+func (c *Client) handle_local_socket_info(mtu int) {
+	defer c.local_addr_record.Socket.Close()
 
 	// 缓存
 	buf := make([]byte, mtu)
 
-	sendIndex := 0
-
 	// 循环读取数据
 	for {
-		n, addr, err := local_addr_record.Socket.ReadFromUDP(buf)
+		n, addr, err := c.local_addr_record.Socket.ReadFromUDP(buf)
 		if err != nil {
+			if c.stopped() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("读取本地监听套接字数据失败:", err)
 			continue
 		}
 
 		// 记录地址
-		addr_mutex.Lock()
-		local_addr_record.Addr = addr.String()
-		addr_mutex.Unlock()
-
-		// 增加包序号
-		index := core.GetIndex()
-
-		// 添加序列号
-		packet := append([]byte(index), buf[:n]...)
-
-		if mode == "mode1" {
-			// 多倍发包模式
-			// 通过所有的UDP连接发送数据包
-			for index := range sockets {
-				// 将数据包放入发送队列
-				// 先获取位置
-				next_index := atomic.LoadInt64(&send_queue_point_list[index][0])
-
-				// 判断是否满了（下一个位置是发送位置）(理论上不应该发生)
-				waitCount := 0
-				for {
-					if next_index+1 == atomic.LoadInt64(&send_queue_point_list[index][1]) {
-						// 等待写入
-						time.Sleep(1 * time.Millisecond)
-
-						fmt.Println("发送队列满了，等待写入完成", waitCount)
-						waitCount++
-					} else {
-						break
-					}
-				}
+		c.addr_mutex.Lock()
+		c.local_addr_record.Addr = addr.String()
+		c.addr_mutex.Unlock()
 
-				// 写入数据
-				send_queue[index][next_index] = packet
-				// 坐标后移
-				atomic.StoreInt64(&send_queue_point_list[index][0], (next_index+1)%send_queue_max_len)
+		c.send_local(buf[:n])
+	}
+}
 
-			}
-		} else if mode == "mode2" {
-			// 链路聚合模式
-			// 按顺序进行发包
-			// 先获取位置
-			next_index := atomic.LoadInt64(&send_queue_point_list[sendIndex][0])
-
-			// 判断是否满了（下一个位置是发送位置）(理论上不应该发生)
-			waitCount := 0
-			for {
-				if next_index+1 == atomic.LoadInt64(&send_queue_point_list[sendIndex][1]) {
-					// 等待写入
-					time.Sleep(1 * time.Millisecond)
-
-					fmt.Println("发送队列满了，等待写入完成", waitCount)
-					waitCount++
-				} else {
-					break
-				}
-			}
+// Send 发送一个包到服务端，与从本地监听地址收到的包相同处理（嵌入运行时使用）
+// 与UDP一样不保证送达：被服务端拒绝、会话尚未建立或没有可用的链路时丢弃；payload可以在返回后复用
+func (c *Client) Send(payload []byte) error {
+	if c.stopped() || c.input_closed.Load() {
+		return net.ErrClosed
+	}
+	c.send_local(payload)
+	return nil
+}
 
-			// 写入数据
-			send_queue[sendIndex][next_index] = packet
-			// 坐标后移
-			atomic.StoreInt64(&send_queue_point_list[sendIndex][0], (next_index+1)%send_queue_max_len)
+// 编码一个本地包，按上行模式放入链路的发送队列
+// 可能被多个线程同时调用（嵌入运行时），发送锁保证序列号与加权轮询只有一个写入方，包按序列号的顺序入队
+func (c *Client) send_local(payload []byte) {
+	c.send_mutex.Lock()
+	defer c.send_mutex.Unlock()
 
-			// sendIndex++
-			sendIndex = (sendIndex + 1) % len(sockets)
-		}
+	// 平滑停止中不再接收本地包
+	if c.input_closed.Load() {
+		return
+	}
+
+	atomic.AddInt64(&c.input_total, 1)
+
+	// 被服务端拒绝，或超过协商出的最大包体（服务端无法接收）时丢弃
+	if c.drop_local_packet(len(payload)) {
+		return
+	}
+
+	// 增加包序号
+	index := c.engine.GetIndex()
+
+	// 握手尚未完成，丢弃
+	if !c.engine.SessionEstablished() {
+		return
+	}
 
+	// 链路集合可能随网卡增删变化，每个包使用一次快照
+	// 跳过等待重建与最大包体不够的链路，成本更高的链路只在成本低的链路都不可用时使用
+	eligible := eligible_links(c.current_links(), len(payload))
+	if len(eligible) == 0 {
+		return
+	}
+
+	upMode := c.mode.Load().(string)
+	if upMode == "mode1" {
+		// 多倍发包模式
+		// 通过所有可用的链路发送数据包，每个副本分别编码（添加序列号，按配置认证或加密），
+		// 认证模式下各副本的计数器不同，不会被服务端的抗重放窗口丢弃
+		for _, link := range eligible {
+			// 将数据包放入发送队列
+			if packet := c.engine.EncodeFrame(index, payload); packet != nil {
+				link.enqueue(packet)
+			}
+		}
+	} else if upMode == "mode2" {
+		// 链路聚合模式
+		// 按权重轮流发包
+		if packet := c.engine.EncodeFrame(index, payload); packet != nil {
+			pick_weighted(eligible).enqueue(packet)
+		}
 	}
 }
 
-func print_hit_counts() {
+// 将数据包放入链路的发送队列，队列满时等待发送线程取出，链路已被移除时丢弃
+func (link *Link) enqueue(packet []byte) {
+	link.send_queue.Push(packet)
+}
+
+func (c *Client) print_hit_counts() {
 	for {
-		// 间隔五秒输出一次
-		time.Sleep(5 * time.Second)
+		// 未启用时等待重新加载配置
+		interval := time.Duration(c.stats_interval.Load())
+		if interval <= 0 {
+			if !c.sleep(1 * time.Second) {
+				return
+			}
+			continue
+		}
+		if !c.sleep(interval) {
+			return
+		}
+
+		current := c.current_links()
+
+		// 输出认证失败统计
+		for _, link := range current {
+			failed := atomic.SwapInt64(&link.auth_fail_count, 0)
+			if failed > 0 {
+				fmt.Printf("套接字 %d 认证失败包数量: %d\n", link.id, failed)
+			}
+		}
+
+		// 输出超过协商最大包体被丢弃的包数
+		if dropped := atomic.SwapInt64(&c.oversize_drop_count, 0); dropped > 0 {
+			fmt.Printf("超过协商最大包体被丢弃的包数量: %d\n", dropped)
+		}
 
 		// 获取总数
+		c.hit_mutex.Lock()
 		total := 0
-		for _, count := range hit_counts {
-			total += count
+		for _, link := range current {
+			total += link.hit_count
 		}
 
 		if total == 0 {
+			c.hit_mutex.Unlock()
 			continue
 		}
 
-		fmt.Printf("---------------总命中包数量: %d----------------------\n", total)
-
+		fmt.Printf("---------------%s总命中包数量: %d----------------------\n", c.tag(), total)
 
 		// 输出统计信息
-		hit_mutex.Lock()
-		for index, count := range hit_counts {
-			fmt.Printf("套接字 %d 命中包数量: %d%% \n", index, count * 100 / total )
-			hit_counts[index] = 0
+		for _, link := range current {
+			fmt.Printf("套接字 %d（%s）命中包数量: %d%% \n", link.id, link.label(), link.hit_count*100/total)
+			link.hit_count = 0
 		}
-		hit_mutex.Unlock()
+		c.hit_mutex.Unlock()
 	}
 }
 
-// 发送数据包的线程
-func send_packet_thread(index int) {
-	for {
-		// 判断是否有数据
-		if atomic.LoadInt64(&send_queue_point_list[index][0]) == atomic.LoadInt64(&send_queue_point_list[index][1]) {
-			// 没有数据，等待
-			time.Sleep(1 * time.Millisecond)
-			continue
-		}
+// 通过聚合链路发送一个包，按配置进行混淆
+func (c *Client) write_link(link *Link, packet []byte) error {
+	return link.write(c.engine.Obfuscate(0, packet))
+}
 
-		// 获取需要发送的数据
-		packet := send_queue[index][atomic.LoadInt64(&send_queue_point_list[index][1])]
+// 发送数据包的线程，发送队列关闭并取完后退出
+func (c *Client) send_packet_thread(link *Link) {
+	defer close(link.sender_done)
 
-		// 指向下一个数据
-		atomic.StoreInt64(&send_queue_point_list[index][1], (send_queue_point_list[index][1]+1)%send_queue_max_len)
+	for {
+		// 等待数据，队列关闭并取完后退出
+		packet, ok := link.send_queue.Pop()
+		if !ok {
+			return
+		}
 
 		// 发送数据
-		_, sendErr := sockets[index].Write(packet)
+		sendErr := c.write_link(link, packet)
 
-		if sendErr != nil {
-			fmt.Printf("数据表转发失败，服务端地址：%s\n", sockets[index].RemoteAddr().String())
+		if sendErr == err_link_down {
+			// 链路正在等待重建，丢弃
+		} else if sendErr != nil {
+			fmt.Printf("数据表转发失败，服务端地址：%s，原因：%v\n", link.RemoteAddr, sendErr)
 		} else {
-			// fmt.Printf("数据转发成功，服务端地址：%s\n", sockets[index].RemoteAddr().String())
+			// fmt.Printf("数据转发成功，服务端地址：%s\n", link.RemoteAddr)
 		}
 	}
 }
 
-func Start(remote_ip_list []string, listen_ip_list []string, send_ip_list []string, mtu int, m string) {
-	mode = m
+// Start 按配置创建并启动一个客户端实例，启动后立即返回，各线程在后台运行，Close停止
+// name为实例名称，只运行一个实例时可以为空
+func Start(name string, cfg Config) (*Client, error) {
+	c := &Client{
+		name:        name,
+		config_wake: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		sockets:     make(map[*net.UDPConn]struct{}),
+	}
 
-	// 用选择的接口建立udp套接字
-	for index, addr := range remote_ip_list {
-		fmt.Printf("远程地址%d: %s\n", index, addr)
+	c.running_config = cfg
+	c.mode.Store(cfg.Mode)
+	c.resolve_interval = cfg.ResolveInterval
+	c.hop_interval = cfg.Hop
+	c.keepalive_interval.Store(int64(cfg.Keepalive))
+	c.stats_interval.Store(int64(cfg.StatsInterval))
+	c.link_mtu = cfg.MTU
+	security := cfg.Security
+
+	// 链路（包括自动发现的链路）需要从链路配置或服务端获得远程地址
+	if len(cfg.Server) == 0 && len(cfg.Links) == 0 {
+		return nil, errors.New("没有远程地址：需要配置带远程地址的链路，或者配置服务端地址")
+	}
+
+	// 自动发现的链路使用与配置的链路相同的远程地址
+	initial := static_links(cfg)
+	c.discover_remotes = cfg.Links
+
+	// 只配置了服务端地址时先用一条链路连接服务端，收到服务端参数后按服务端的监听地址创建链路
+	if c.server_addr = cfg.Server; len(c.server_addr) > 0 {
+		c.config_links = initial
+
+		bootstrap := LinkConfig{Local: any_local_addr}
+		if len(c.config_links) > 0 {
+			bootstrap = c.config_links[0]
+		}
+		bootstrap.Remote = c.server_addr
+
+		initial = []LinkConfig{bootstrap}
+		c.discover_remotes = initial
+		if len(c.config_links) == 0 && len(cfg.Discover) > 0 {
+			// 只使用自动发现的链路
+			initial = nil
+		}
 	}
-	sockets = create_cluster_socket(remote_ip_list, send_ip_list)
-	hit_counts = make([]int, len(sockets))
 
-	// 初始化发送队列数组
-	send_queue = make([][send_queue_max_len][]byte, len(remote_ip_list))
-	send_queue_point_list = make([][2]int64, len(remote_ip_list))
+	// 设置帧认证、加密与握手
+	engine, err := core.NewEngine(security, false)
+	if err != nil {
+		return nil, fmt.Errorf("初始化帧认证失败: %v", err)
+	}
+	c.engine = engine
 
-	for index := range hit_counts {
-		hit_counts[index] = 0
-		send_queue[index] = [send_queue_max_len][]byte{}
-		send_queue_point_list[index] = [2]int64{0, 0}
+	// 创建本地监听套接字（先于链路创建，失败时不留下任何线程），嵌入运行时不需要
+	if c.deliver = cfg.Deliver; c.deliver == nil {
+		if err := c.create_local_socket(cfg.Listen); err != nil {
+			return nil, err
+		}
 	}
 
-	// 监听sockets中的套接字接受信息
-	for index, socket := range sockets {
-		go handle_cluster_socket_info(socket, index, mtu)
+	// 本端能力（包含加密算法，需要在设置加密之后生成）
+	hello := c.engine.LocalHello(cfg.Mode, client_modes, cfg.MTU)
+	hello.DownMode = cfg.DownMode
+	c.local_hello.Store(&hello)
 
-		// 启动写回线程
-		go send_packet_thread(index)
+	// 用选择的接口建立udp套接字
+	for index, link := range initial {
+		fmt.Printf("远程地址%d: %s\n", index, link.Remote)
+	}
+	if err := c.create_cluster_socket(initial); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("创建聚合链路失败: %v", err)
 	}
 
-	// 创建本地监听套接字
-	if !create_local_socket(listen_ip_list[0]) {
-		// 创建本地监听套接字失败
-		return
+	// 自动发现上行网卡，为每个网卡创建链路，之后由链路检查线程按网卡增删链路
+	if c.discover_patterns = cfg.Discover; len(c.discover_patterns) > 0 {
+		c.sync_discovered_links()
 	}
 
+	// 自动发现、端口跳变与应用服务端参数都由链路检查线程完成
+	c.link_check_interval.Store(int64(link_check_period(cfg)))
+
 	// 监听本地套接字
-	go handle_local_socket_info(mtu)
+	if c.local_addr_record != nil {
+		c.spawn(func() { c.handle_local_socket_info(cfg.MTU) })
+	}
+
+	// 链路检查，本地地址消失或变化时自动重建（未启用时也运行，重新加载配置后可能启用）
+	c.spawn(func() { c.watch_links() })
+
+	// 握手与换钥
+	if c.engine.HandshakeEnabled() {
+		c.spawn(func() { c.handshake_thread(security.RekeyInterval, security.RekeyBytes) })
+	}
+
+	// 链路保活（未启用时也运行，服务端可能下发建议的保活间隔）
+	c.spawn(func() { c.keepalive_thread() })
+
+	// 与服务端协商能力，只使用-server时同时获取服务端的监听地址
+	c.spawn(func() { c.config_thread() })
 
 	// 统计日志
-	go print_hit_counts()
+	c.spawn(func() { c.print_hit_counts() })
 
-	fmt.Printf("程序运行，等待输入\n")
+	fmt.Printf("%s程序运行，等待输入\n", c.tag())
+	return c, nil
+}
 
-	// 阻止主函数退出
-	select {}
+// 统计日志中区分实例的前缀，只运行一个实例（没有名称）时为空
+func (c *Client) tag() string {
+	if len(c.name) == 0 {
+		return ""
+	}
+	return "实例 " + c.name + " "
 }
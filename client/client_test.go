@@ -0,0 +1,29 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStartBindError(t *testing.T) {
+	busy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	c, err := Start("", Config{
+		Links:   []LinkConfig{{Local: busy.LocalAddr().String(), Remote: "127.0.0.1:9"}},
+		Deliver: func([]byte) {},
+		MTU:     1400,
+		Mode:    "mode1",
+	})
+	if err == nil {
+		c.Close()
+		t.Fatal("链路的本地地址被占用时启动成功")
+	}
+	if !strings.Contains(err.Error(), "绑定 "+busy.LocalAddr().String()+" 失败") {
+		t.Fatalf("错误信息 %q 中没有绑定失败的地址", err)
+	}
+}
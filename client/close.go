@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// 实例已停止时重新加载配置返回的错误
+var err_stopped = errors.New("实例已停止")
+
+// 启动一个后台线程，Close时等待它退出
+func (c *Client) spawn(thread func()) {
+	c.threads.Add(1)
+	go func() {
+		defer c.threads.Done()
+		thread()
+	}()
+}
+
+// 判断实例是否已停止
+func (c *Client) stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// 等待一段时间，实例停止时提前返回false，线程随之退出
+func (c *Client) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// 记录读取线程正在使用的套接字，实例已停止时返回false
+func (c *Client) track_socket(conn *net.UDPConn) bool {
+	c.sockets_mutex.Lock()
+	defer c.sockets_mutex.Unlock()
+
+	if c.stopped() {
+		return false
+	}
+	c.sockets[conn] = struct{}{}
+	return true
+}
+
+// 读取线程退出时移除记录
+func (c *Client) untrack_socket(conn *net.UDPConn) {
+	c.sockets_mutex.Lock()
+	delete(c.sockets, conn)
+	c.sockets_mutex.Unlock()
+}
+
+// Close 停止客户端实例：关闭本地监听套接字与全部链路，等待所有线程退出，可以重复调用
+func (c *Client) Close() {
+	c.close_once.Do(func() {
+		close(c.done)
+
+		if c.local_addr_record != nil {
+			c.local_addr_record.Socket.Close()
+		}
+
+		// 与链路检查、重新加载配置互斥，停止之后不会再创建链路
+		c.link_change_mutex.Lock()
+		for _, link := range c.current_links() {
+			c.remove_link(link)
+		}
+		c.link_change_mutex.Unlock()
+
+		// 退役中的套接字也要关闭，读取线程不必等到退役到期
+		c.sockets_mutex.Lock()
+		for conn := range c.sockets {
+			conn.Close()
+		}
+		c.sockets_mutex.Unlock()
+	})
+	c.threads.Wait()
+}
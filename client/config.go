@@ -0,0 +1,66 @@
+package client
+
+import (
+	"UDPRainbowBridge/core"
+	"time"
+)
+
+// Config 客户端配置
+type Config struct {
+	// 聚合链路，只配置服务端地址时Remote为空，按顺序与服务端下发的监听地址配对
+	Links []LinkConfig
+
+	// 本地监听地址，应用程序把数据发到这里
+	Listen string
+
+	// 设置后收到的包交给它，不创建本地监听套接字（嵌入运行时使用，应用通过Client.Send发送）
+	// 在链路读取线程中调用，payload在返回后会被复用，需要保留时复制
+	Deliver func(payload []byte)
+
+	// 服务端地址，设置后从服务端获取监听地址，链路不需要配置远程地址
+	Server string
+
+	// 最大包体
+	MTU int
+
+	// 上行模式与请求服务端使用的下行模式（为空时使用服务端的模式）
+	Mode     string
+	DownMode string
+
+	// 帧认证、加密、握手与混淆
+	Security core.SecurityConfig
+
+	// 自动发现上行网卡的名称匹配模式，为空表示不启用
+	Discover []string
+
+	// 链路检查、远程域名重新解析、端口跳变、保活与统计日志的间隔，0表示不启用
+	LinkCheck       time.Duration
+	ResolveInterval time.Duration
+	Hop             time.Duration
+	Keepalive       time.Duration
+	StatsInterval   time.Duration
+}
+
+// LinkConfig 一条聚合链路的配置
+type LinkConfig struct {
+	// 链路名称，只用于日志与统计
+	Name string
+
+	// 发送地址：IP:端口或网卡名称，为空时由系统选择
+	Local string
+
+	// 远程地址
+	Remote string
+
+	// 解析远程地址使用的地址族（any、4、6）
+	Family string
+
+	// 聚合模式下的权重，链路按权重分配包，0按1处理
+	Weight int
+
+	// 链路成本，只有成本更低的链路都不可用时才使用成本更高的链路（例如按流量计费的备用链路）
+	Cost int
+
+	// 链路最大包体，超过的包不从这条链路发送，0表示不限制
+	MTU int
+}
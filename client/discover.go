@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"time"
+)
+
+// 判断网卡名称是否匹配任意一个模式
+func interface_name_match(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断网卡是否有可用的单播地址（排除链路本地地址）
+func interface_has_address(iface net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// 枚举可作为上行链路的网卡：已启用、非回环、有可用地址并且名称匹配
+func discover_interfaces(patterns []string) map[string]bool {
+	found := make(map[string]bool)
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Println("获取网卡列表失败:", err)
+		return nil
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if !interface_name_match(iface.Name, patterns) || !interface_has_address(iface) {
+			continue
+		}
+		found[iface.Name] = true
+	}
+	return found
+}
+
+// 为新链路选择远程地址：选择当前链路最少的那个，让自动发现的链路尽量分散到服务端不同的监听端口
+// 返回远程地址在列表中的位置
+func (c *Client) pick_discover_remote(current []*Link) int {
+	used := make(map[string]int)
+	for _, link := range current {
+		used[link.RemoteAddr]++
+	}
+
+	remote := 0
+	for index, target := range c.discover_remotes {
+		if used[target.Remote] < used[c.discover_remotes[remote].Remote] {
+			remote = index
+		}
+	}
+	return remote
+}
+
+// 按当前的网卡列表增删自动发现的链路（只在持有链路变更锁时调用）
+func (c *Client) sync_discovered_links() {
+	// 还没有可以使用的远程地址
+	if len(c.discover_remotes) == 0 {
+		return
+	}
+
+	found := discover_interfaces(c.discover_patterns)
+	if found == nil {
+		return
+	}
+
+	current := c.current_links()
+	bound := make(map[string]bool)
+	for _, link := range current {
+		if len(link.Interface) == 0 {
+			continue
+		}
+		bound[link.Interface] = true
+
+		// 网卡消失、被禁用或失去地址时移除链路
+		if link.discovered && !found[link.Interface] {
+			c.remove_link(link)
+			fmt.Printf("[%s] 网卡 %s 已移除，删除链路 %d\n", time.Now().Format("2006-01-02 15:04:05"), link.Interface, link.id)
+		}
+	}
+
+	for name := range found {
+		// 已经按名称配置过的网卡不重复创建
+		if bound[name] {
+			continue
+		}
+
+		remote := c.pick_discover_remote(c.current_links())
+		target := c.discover_remotes[remote]
+		link, err := new_link(LinkConfig{Local: name, Remote: target.Remote, Family: target.Family})
+		if err != nil {
+			fmt.Printf("网卡 %s 创建链路失败：%v\n", name, err)
+			continue
+		}
+		link.discovered = true
+
+		conn, err := dial_link(link)
+		if err != nil {
+			fmt.Printf("网卡 %s 创建链路失败，稍后重试：%v\n", name, err)
+			link.down = true
+		} else {
+			c.attach(link, conn)
+		}
+
+		c.add_link(link)
+		fmt.Printf("[%s] 发现网卡 %s，创建链路 %d，对端ip：%s\n", time.Now().Format("2006-01-02 15:04:05"), name, link.id, link.RemoteAddr)
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInterfaceNameMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     bool
+	}{
+		{"eth0", []string{"eth*"}, true},
+		{"wwan0", []string{"eth*", "wwan?"}, true},
+		{"wwan10", []string{"wwan?"}, false},
+		{"enp3s0", []string{"en[op]*"}, true},
+		{"wlan0", []string{"eth*"}, false},
+		{"eth0", nil, false},
+		// 格式错误的模式不匹配任何网卡，也不影响其它模式
+		{"eth0", []string{"eth[", "eth0"}, true},
+		{"eth[", []string{"eth["}, false},
+	}
+
+	for _, tt := range tests {
+		if got := interface_name_match(tt.name, tt.patterns); got != tt.want {
+			t.Errorf("interface_name_match(%q, %q) = %v，应为 %v", tt.name, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoverInterfaces(t *testing.T) {
+	// 没有匹配的网卡时返回空集合而不是nil（nil表示获取网卡列表失败）
+	found := discover_interfaces([]string{"no-such-interface*"})
+	if found == nil || len(found) != 0 {
+		t.Fatalf("没有匹配的网卡时返回 %v", found)
+	}
+
+	// 匹配全部名称时也只返回已启用、非回环、有可用地址的网卡
+	for name := range discover_interfaces([]string{"*"}) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || !interface_has_address(*iface) {
+			t.Fatalf("网卡 %s（%v）不应被发现", name, iface.Flags)
+		}
+	}
+
+	// 回环网卡不会被发现
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			if found := discover_interfaces([]string{iface.Name}); len(found) != 0 {
+				t.Fatalf("回环网卡 %s 被发现", iface.Name)
+			}
+		}
+	}
+}
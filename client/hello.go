@@ -0,0 +1,60 @@
+package client
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// 客户端支持的模式
+var client_modes = []string{"mode1", "mode2"}
+
+// 按服务端回复的能力协商，返回是否可以继续使用服务端下发的参数
+// 服务端已经拒绝时使用服务端给出的原因，否则本端按相同规则再协商一次
+func (c *Client) negotiate_server(params *core.ServerParams) bool {
+	reason := params.Refused
+	agreement, err := c.engine.Negotiate(*c.local_hello.Load(), params.Hello)
+	if len(reason) == 0 && err != nil {
+		reason = err.Error()
+	}
+
+	c.negotiate_mutex.Lock()
+	defer c.negotiate_mutex.Unlock()
+
+	if len(reason) > 0 {
+		c.negotiated.Store(false)
+		c.refused.Store(true)
+		if reason != c.last_refused {
+			c.last_refused = reason
+			c.last_agreement = nil
+			fmt.Printf("[%s] 服务端拒绝连接，停止发送：%s\n", time.Now().Format("2006-01-02 15:04:05"), reason)
+		}
+		return false
+	}
+
+	atomic.StoreInt64(&c.agreed_mtu, int64(agreement.MTU))
+	c.negotiated.Store(true)
+	c.refused.Store(false)
+	c.last_refused = ""
+	if c.last_agreement == nil || !reflect.DeepEqual(*c.last_agreement, agreement) {
+		c.last_agreement = &agreement
+		fmt.Printf("[%s] 与服务端协商完成：协议版本 %d，上行模式 %s，下行模式 %s，MTU %d，功能 %v\n", time.Now().Format("2006-01-02 15:04:05"), agreement.Version, agreement.UpMode, agreement.DownMode, agreement.MTU, agreement.Features)
+	}
+	return true
+}
+
+// 判断本地包是否需要丢弃：被服务端拒绝，或超过协商出的最大包体
+func (c *Client) drop_local_packet(n int) bool {
+	if c.refused.Load() {
+		return true
+	}
+
+	limit := atomic.LoadInt64(&c.agreed_mtu)
+	if limit > 0 && int64(n) > limit {
+		atomic.AddInt64(&c.oversize_drop_count, 1)
+		return true
+	}
+	return false
+}
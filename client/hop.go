@@ -0,0 +1,47 @@
+package client
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+// 退役的套接字继续接收的时间，覆盖服务端切换到新地址之前已经发出的包
+const link_retire_grace = 5 * time.Second
+
+// 在端口范围内随机选择一个端口，范围内有多个端口时避开当前端口
+func pick_port(ports [2]int, current int) int {
+	if ports[0] == ports[1] {
+		return ports[0]
+	}
+
+	for {
+		port := ports[0] + rand.Intn(ports[1]-ports[0]+1)
+		if port != current {
+			return port
+		}
+	}
+}
+
+// 计划下一次端口跳变，间隔在配置值上下浮动25%，避免所有链路同时跳变
+func (c *Client) schedule_hop(link *Link) {
+	if c.hop_interval <= 0 {
+		return
+	}
+	jitter := time.Duration(rand.Int63n(int64(c.hop_interval)/2+1)) - c.hop_interval/4
+	link.next_hop = time.Now().Add(c.hop_interval + jitter)
+}
+
+// 判断链路是否到了端口跳变的时间
+// 本地与远程端口都固定为单个端口时无法跳变
+func (c *Client) hop_due(link *Link) bool {
+	if c.hop_interval <= 0 || time.Now().Before(link.next_hop) {
+		return false
+	}
+	return !link.fixed_port() || link.remote_ports[0] != link.remote_ports[1]
+}
+
+// 让旧套接字退役：到期后读取线程退出并关闭它
+func retire_socket(conn *net.UDPConn) {
+	conn.SetReadDeadline(time.Now().Add(link_retire_grace))
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPickPort(t *testing.T) {
+	if port := pick_port([2]int{9000, 9000}, 9000); port != 9000 {
+		t.Fatalf("单个端口时选择了 %d", port)
+	}
+
+	ports := [2]int{9000, 9002}
+	for i := 0; i < 100; i++ {
+		port := pick_port(ports, 9001)
+		if port < ports[0] || port > ports[1] || port == 9001 {
+			t.Fatalf("在 %v 中避开 9001 时选择了 %d", ports, port)
+		}
+	}
+}
+
+func TestHopDue(t *testing.T) {
+	tests := []struct {
+		name         string
+		interval     time.Duration
+		local_ports  [2]int
+		remote_ports [2]int
+		want         bool
+	}{
+		{"未启用", 0, [2]int{0, 0}, [2]int{9000, 9009}, false},
+		{"本地端口自动选择", time.Second, [2]int{0, 0}, [2]int{9000, 9000}, true},
+		{"本地端口范围", time.Second, [2]int{7000, 7009}, [2]int{9000, 9000}, true},
+		{"远程端口范围", time.Second, [2]int{7000, 7000}, [2]int{9000, 9009}, true},
+		{"端口都固定", time.Second, [2]int{7000, 7000}, [2]int{9000, 9000}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{hop_interval: tt.interval}
+			link := &Link{local_ports: tt.local_ports, remote_ports: tt.remote_ports}
+			if got := c.hop_due(link); got != tt.want {
+				t.Fatalf("hop_due = %v，应为 %v", got, tt.want)
+			}
+
+			// 还没到跳变时间
+			c.schedule_hop(link)
+			if tt.interval > 0 && c.hop_due(link) {
+				t.Fatal("刚计划跳变就到期")
+			}
+		})
+	}
+}
+
+func TestScheduleHopJitter(t *testing.T) {
+	c := &Client{hop_interval: 4 * time.Second}
+	link := &Link{}
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		c.schedule_hop(link)
+		// 间隔在配置值上下浮动25%
+		if wait := link.next_hop.Sub(start); wait < 3*time.Second || wait > 5*time.Second+time.Millisecond {
+			t.Fatalf("下一次跳变在 %v 之后，应在3到5秒之间", wait)
+		}
+	}
+}
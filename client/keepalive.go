@@ -0,0 +1,47 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 当前的保活间隔
+func (c *Client) keepalive_period() time.Duration {
+	return time.Duration(c.keepalive_interval.Load())
+}
+
+// 在链路上发送一个保活帧，会话尚未建立时不发送
+func (c *Client) send_keepalive(link *Link) {
+	if c.keepalive_period() <= 0 {
+		return
+	}
+
+	if packet := c.engine.NewKeepalive(); packet != nil {
+		c.write_link(link, packet)
+	}
+}
+
+// 保活线程：检查每条链路最近一次发送的时间，空闲超过保活间隔时发送保活帧
+func (c *Client) keepalive_thread() {
+	for {
+		// 检查间隔取保活间隔的一半，最长1秒；未启用保活时等待服务端建议的间隔
+		interval := c.keepalive_period()
+		tick := min(interval/2, time.Second)
+		if interval <= 0 {
+			tick = time.Second
+		}
+		if !c.sleep(tick) {
+			return
+		}
+		if interval <= 0 {
+			continue
+		}
+
+		for _, link := range c.current_links() {
+			last := time.Unix(0, atomic.LoadInt64(&link.last_send))
+			if time.Since(last) >= interval {
+				c.send_keepalive(link)
+			}
+		}
+	}
+}
@@ -0,0 +1,613 @@
+package client
+
+import (
+	"UDPRainbowBridge/core"
+	"UDPRainbowBridge/queue"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// InterfaceAddress 用于存储网络接口的名称和IPv4、IPv6地址（没有时为空）
+type InterfaceAddress struct {
+	NAME string
+	IPV4 string
+	IPV6 string
+}
+
+// 连续发送失败多少次后重建链路
+const link_write_failure_limit = 3
+
+// 链路不可用（等待重建）时发送返回的错误
+var err_link_down = errors.New("链路不可用")
+
+// Link 一条聚合链路
+// 本地地址消失或变化（例如LTE模块重新拨号后拿到新的IP）时套接字会被重建，
+// 会话、去重等状态都不在链路上，重建不影响会话；服务端在收到新地址发来的下一个认证帧时迁移该链路的地址
+type Link struct {
+	// 链路编号，日志与统计中使用，链路移除后不会复用
+	id int
+
+	// 本地地址与远程地址配置
+	LocalAddr  string
+	RemoteAddr string
+
+	// 解析远程地址使用的网络类型（udp、udp4、udp6），决定使用A还是AAAA记录
+	network string
+
+	// 本地地址为IP时对应的网络类型（udp4、udp6），远程地址按本地地址的地址族解析
+	local_network string
+
+	// 上次解析远程地址的时间（只在创建与检查线程中读写）
+	resolved_at time.Time
+
+	// 绑定的网卡名称，按IP绑定时为空
+	Interface string
+
+	// 本地地址的主机部分（按IP绑定时）与本地端口范围，端口为0表示自动选择
+	local_host  string
+	local_ports [2]int
+
+	// 当前使用的本地端口
+	port int
+
+	// 远程地址的主机部分与端口范围，端口跳变时在范围内选择目标端口
+	remote_host  string
+	remote_ports [2]int
+
+	// 当前使用的远程端口
+	remote_port int
+
+	// 下一次端口跳变的时间（只在创建与检查线程中读写）
+	next_hop time.Time
+
+	// 网卡当前的地址（只在创建与检查线程中读写）
+	bound InterfaceAddress
+
+	// 当前使用的套接字，链路不可用时为nil
+	socket atomic.Pointer[net.UDPConn]
+
+	// 连续发送失败次数（原子操作）
+	write_failures int32
+
+	// 最近一次发送成功的时间（UnixNano，原子操作），用于判断是否需要发送保活帧
+	last_send int64
+
+	// 链路是否处于不可用状态（只在检查线程中读写）
+	down bool
+
+	// 是否为自动发现的链路，网卡消失时会被移除
+	discovered bool
+
+	// 发送队列，链路被移除时关闭，发送线程取完剩余的包后退出
+	send_queue *queue.Queue
+
+	// 发送线程退出时关闭，停止时用来等待队列中的包发完
+	sender_done chan struct{}
+
+	// 链路配置：名称、权重、成本与最大包体，重新加载配置时整体替换（原子操作）
+	settings atomic.Pointer[LinkConfig]
+
+	// 平滑加权轮询的当前值（发送锁保护）
+	current_weight int
+
+	// 命中包统计（hit_mutex保护）
+	hit_count int
+
+	// 认证失败包统计（原子操作）
+	auth_fail_count int64
+
+	// 累计统计：发送成功、命中与认证失败的包（原子操作），统计日志输出后不清零
+	sent_total      int64
+	hit_total       int64
+	auth_fail_total int64
+}
+
+// 返回当前链路集合的快照
+func (c *Client) current_links() []*Link {
+	c.links_mutex.RLock()
+	defer c.links_mutex.RUnlock()
+	return c.links
+}
+
+// 把链路加入链路集合，并启动它的发送线程
+func (c *Client) add_link(link *Link) {
+	c.links_mutex.Lock()
+	link.id = c.next_link_id
+	c.next_link_id++
+	updated := make([]*Link, 0, len(c.links)+1)
+	updated = append(updated, c.links...)
+	c.links = append(updated, link)
+	c.links_mutex.Unlock()
+
+	// 启动写回线程
+	c.spawn(func() { c.send_packet_thread(link) })
+}
+
+// 把链路从链路集合中移除，关闭发送队列与套接字后读取与发送线程会自行退出（队列中剩余的包被丢弃）
+func (c *Client) remove_link(link *Link) {
+	c.links_mutex.Lock()
+	updated := make([]*Link, 0, len(c.links))
+	for _, item := range c.links {
+		if item != link {
+			updated = append(updated, item)
+		}
+	}
+	c.links = updated
+	c.links_mutex.Unlock()
+
+	link.send_queue.Close()
+	if conn := link.socket.Swap(nil); conn != nil {
+		conn.Close()
+	}
+}
+
+// Socket 返回链路当前使用的套接字，链路不可用时返回nil
+func (link *Link) Socket() *net.UDPConn {
+	return link.socket.Load()
+}
+
+// 开始使用新的套接字，并启动它的读取线程（套接字关闭或退役后线程退出）
+func (c *Client) attach(link *Link, conn *net.UDPConn) {
+	link.socket.Store(conn)
+	c.schedule_hop(link)
+
+	// 监听链路中的套接字接受信息
+	c.spawn(func() { c.handle_cluster_socket_info(link, conn) })
+}
+
+// 根据本地地址配置创建链路
+// 本地地址的主机部分不是IP时视为网卡名称，例如 eth0、wwan0:5000，链路会跟随网卡当前的地址
+// 本地与远程端口都可以写成范围（例如 9000-9009），端口跳变时在范围内选择
+func new_link(cfg LinkConfig) (*Link, error) {
+	network, err := core.ResolveNetwork(cfg.Family)
+	if err != nil {
+		return nil, fmt.Errorf("远程地址 %s 配置错误: %v", cfg.Remote, err)
+	}
+
+	local_spec, remote := cfg.Local, cfg.Remote
+	link := &Link{
+		LocalAddr:   local_spec,
+		RemoteAddr:  remote,
+		network:     network,
+		send_queue:  queue.New(send_queue_max_len),
+		sender_done: make(chan struct{}),
+	}
+	link.settings.Store(&cfg)
+
+	host, port, err := net.SplitHostPort(local_spec)
+	if err != nil {
+		// 只写了网卡名称，没有端口
+		host, port = local_spec, "0"
+	}
+
+	if link.local_ports[0], link.local_ports[1], err = core.ParsePortRange(port); err != nil {
+		return nil, fmt.Errorf("本地地址 %s 配置错误: %v", local_spec, err)
+	}
+
+	// IPv6链路本地地址可以带区域，例如 [fe80::1%wwan0]:0
+	ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+	if ip == nil && len(host) > 0 {
+		link.Interface = host
+	} else {
+		link.local_host = host
+		if ip != nil {
+			link.local_network = ip_network(ip)
+		}
+	}
+
+	if link.remote_host, port, err = net.SplitHostPort(remote); err != nil {
+		return nil, fmt.Errorf("远程地址 %s 配置错误: %v", remote, err)
+	}
+	if link.remote_ports[0], link.remote_ports[1], err = core.ParsePortRange(port); err != nil {
+		return nil, fmt.Errorf("远程地址 %s 配置错误: %v", remote, err)
+	}
+
+	link.port = pick_port(link.local_ports, 0)
+	link.remote_port = pick_port(link.remote_ports, 0)
+	return link, nil
+}
+
+// 返回当前使用的远程地址（主机部分可能是域名）
+func (link *Link) remote_target() string {
+	return net.JoinHostPort(link.remote_host, strconv.Itoa(link.remote_port))
+}
+
+// 返回IP对应的网络类型
+func ip_network(ip net.IP) string {
+	if ip.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// 返回地址族标签，用于日志与统计
+func ip_family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// 返回链路当前的地址族标签，链路不可用时返回"-"
+func (link *Link) family() string {
+	conn := link.Socket()
+	if conn == nil {
+		return "-"
+	}
+	return ip_family(conn.RemoteAddr().(*net.UDPAddr).IP)
+}
+
+// 返回统计日志中的链路标签：名称（有的话）与地址族
+func (link *Link) label() string {
+	name := link.settings.Load().Name
+	if len(name) == 0 {
+		return link.family()
+	}
+	return name + "，" + link.family()
+}
+
+// 链路配置中的本地地址、远程地址与地址族，相同的链路在重新加载配置时保留
+func (link *Link) pair() link_pair {
+	return pair_of(*link.settings.Load())
+}
+
+// 解析远程地址使用的网络类型
+// 没有指定地址族时跟随本地地址：本地地址为IP时使用相同的地址族，按网卡绑定时使用网卡拥有的地址族
+func (link *Link) remote_network(ifAddr InterfaceAddress) string {
+	if link.network != "udp" {
+		return link.network
+	}
+
+	if len(link.local_network) > 0 {
+		return link.local_network
+	}
+
+	if len(link.Interface) > 0 {
+		if len(ifAddr.IPV4) == 0 {
+			return "udp6"
+		}
+		if len(ifAddr.IPV6) == 0 {
+			return "udp4"
+		}
+	}
+	return "udp"
+}
+
+// 按远程地址的地址族选择网卡的本地地址，IPv6链路本地地址带上网卡名称作为区域
+func (ifAddr InterfaceAddress) local_addr(remote net.IP, port int) (*net.UDPAddr, error) {
+	if remote.To4() != nil {
+		if len(ifAddr.IPV4) == 0 {
+			return nil, fmt.Errorf("网卡 %s 没有IPv4地址", ifAddr.NAME)
+		}
+		return &net.UDPAddr{IP: net.ParseIP(ifAddr.IPV4), Port: port}, nil
+	}
+
+	if len(ifAddr.IPV6) == 0 {
+		return nil, fmt.Errorf("网卡 %s 没有IPv6地址", ifAddr.NAME)
+	}
+	localAddr := &net.UDPAddr{IP: net.ParseIP(ifAddr.IPV6), Port: port}
+	if localAddr.IP.IsLinkLocalUnicast() {
+		localAddr.Zone = ifAddr.NAME
+	}
+	return localAddr, nil
+}
+
+// 查询网卡当前的IPv4与IPv6地址，IPv6优先使用非链路本地的地址，没有时使用链路本地地址
+func resolve_interface_address(name string) (InterfaceAddress, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return InterfaceAddress{}, fmt.Errorf("网卡 %s 不存在", name)
+	}
+
+	if iface.Flags&net.FlagUp == 0 {
+		return InterfaceAddress{}, fmt.Errorf("网卡 %s 未启用", name)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return InterfaceAddress{}, err
+	}
+
+	ifAddr := InterfaceAddress{NAME: name}
+	linkLocal := ""
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case ipNet.IP.To4() != nil:
+			if len(ifAddr.IPV4) == 0 {
+				ifAddr.IPV4 = ipNet.IP.String()
+			}
+		case ipNet.IP.IsLinkLocalUnicast():
+			if len(linkLocal) == 0 {
+				linkLocal = ipNet.IP.String()
+			}
+		default:
+			if len(ifAddr.IPV6) == 0 {
+				ifAddr.IPV6 = ipNet.IP.String()
+			}
+		}
+	}
+
+	if len(ifAddr.IPV6) == 0 {
+		ifAddr.IPV6 = linkLocal
+	}
+
+	if len(ifAddr.IPV4) == 0 && len(ifAddr.IPV6) == 0 {
+		return InterfaceAddress{}, fmt.Errorf("网卡 %s 没有可用地址", name)
+	}
+	return ifAddr, nil
+}
+
+// 返回网卡地址中与远程地址同一地址族的那个
+func (ifAddr InterfaceAddress) address_for(remote net.IP) string {
+	if remote.To4() != nil {
+		return ifAddr.IPV4
+	}
+	return ifAddr.IPV6
+}
+
+// 判断配置的本地地址是否未指定具体IP（由系统按路由选择源地址）
+func (link *Link) unspecified_local() bool {
+	if len(link.Interface) > 0 {
+		return false
+	}
+	ip := net.ParseIP(strings.SplitN(link.local_host, "%", 2)[0])
+	return ip == nil || ip.IsUnspecified()
+}
+
+// 判断配置的本地地址是否指定了固定端口
+func (link *Link) fixed_port() bool {
+	return link.local_ports[0] != 0 && link.local_ports[0] == link.local_ports[1]
+}
+
+// 判断创建链路套接字的错误是否为本地地址无法绑定（端口被占用或没有权限），这类错误等待重建也不会恢复
+// 地址或网卡暂时不存在、远程地址解析失败等情况不属于此类，由链路检查线程稍后重建
+func bind_failed(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.EACCES)
+}
+
+// 按配置创建链路套接字，每次创建都会重新解析远程地址
+func dial_link(link *Link) (*net.UDPConn, error) {
+	link.resolved_at = time.Now()
+
+	if len(link.Interface) == 0 {
+		remoteAddr, err := net.ResolveUDPAddr(link.remote_network(InterfaceAddress{}), link.remote_target())
+		if err != nil {
+			return nil, fmt.Errorf("解析远程地址失败: %v", err)
+		}
+
+		localAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(link.local_host, strconv.Itoa(link.port)))
+		if err != nil {
+			return nil, fmt.Errorf("解析本地地址失败: %v", err)
+		}
+
+		conn, err := net.DialUDP("udp", localAddr, remoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("创建udp套接字失败: %w", err)
+		}
+		return conn, nil
+	}
+
+	// 按网卡绑定：使用网卡当前与远程地址同一地址族的地址，并在支持的系统上绑定到网卡本身
+	ifAddr, err := resolve_interface_address(link.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteAddr, err := net.ResolveUDPAddr(link.remote_network(ifAddr), link.remote_target())
+	if err != nil {
+		return nil, fmt.Errorf("解析远程地址失败: %v", err)
+	}
+
+	localAddr, err := ifAddr.local_addr(remoteAddr.IP, link.port)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{
+		LocalAddr: localAddr,
+		Control:   bind_device_control(link.Interface),
+	}
+	conn, err := dialer.Dial("udp", remoteAddr.String())
+	if err != nil {
+		return nil, fmt.Errorf("创建udp套接字失败: %w", err)
+	}
+
+	link.bound = ifAddr
+	return conn.(*net.UDPConn), nil
+}
+
+// 重建链路套接字，新套接字创建成功后旧套接字退役：不再发送，但在一段时间内继续接收，
+// 服务端切换到新地址之前发出的包不会丢失
+func (c *Client) rebuild_link(link *Link, reason string) {
+	old := link.Socket()
+
+	// 绑定了固定端口时需要先关闭旧套接字才能重新绑定
+	if old != nil && link.fixed_port() {
+		link.socket.Store(nil)
+		old.Close()
+		old = nil
+	}
+
+	// 本地端口为范围时换一个端口，避免与仍在接收的旧套接字冲突
+	link.port = pick_port(link.local_ports, link.port)
+
+	conn, err := dial_link(link)
+	if err != nil {
+		if old != nil {
+			link.socket.Store(nil)
+			old.Close()
+		}
+		if !link.down {
+			fmt.Printf("[%s] 链路 %d 不可用（%s）：%v\n", time.Now().Format("2006-01-02 15:04:05"), link.id, reason, err)
+			link.down = true
+		}
+		return
+	}
+
+	c.attach(link, conn)
+	atomic.StoreInt32(&link.write_failures, 0)
+	if old != nil {
+		retire_socket(old)
+	}
+
+	// 立即发送保活帧，服务端不必等到下一个上行数据包就能切换到新地址
+	c.send_keepalive(link)
+
+	fmt.Printf("[%s] 链路 %d 已重建（%s），本地地址：%s 对端：%s\n", time.Now().Format("2006-01-02 15:04:05"), link.id, reason, conn.LocalAddr().String(), conn.RemoteAddr().String())
+	link.down = false
+}
+
+// 判断本机是否还拥有该IP（回环网段内的任意地址都属于本机）
+func local_ip_present(ip net.IP, addrs []net.Addr) bool {
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.Equal(ip) || (ip.IsLoopback() && ipNet.Contains(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 查询系统当前到远程地址会选择的源地址（不发送任何数据）
+func route_source_ip(remoteAddr *net.UDPAddr) net.IP {
+	probe, err := net.DialUDP("udp", nil, remoteAddr)
+	if err != nil {
+		return nil
+	}
+	defer probe.Close()
+
+	return probe.LocalAddr().(*net.UDPAddr).IP
+}
+
+// 检查单条链路，需要时重建
+func (c *Client) check_link(link *Link, addrs []net.Addr) {
+	conn := link.Socket()
+	if conn == nil {
+		c.rebuild_link(link, "重试创建")
+		return
+	}
+
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	remoteAddr := conn.RemoteAddr().(*net.UDPAddr)
+
+	// 按网卡绑定时跟随网卡当前的地址
+	if len(link.Interface) > 0 {
+		ifAddr, err := resolve_interface_address(link.Interface)
+		if err != nil {
+			c.rebuild_link(link, err.Error())
+			return
+		}
+		if current, bound := ifAddr.address_for(remoteAddr.IP), link.bound.address_for(remoteAddr.IP); current != bound {
+			c.rebuild_link(link, fmt.Sprintf("网卡 %s 地址变更 %s -> %s", link.Interface, bound, current))
+			return
+		}
+	} else if !local_ip_present(localIP, addrs) {
+		c.rebuild_link(link, fmt.Sprintf("本地地址 %s 已消失", localIP.String()))
+		return
+	}
+
+	// 未指定本地IP时，路由选择的源地址变化也需要重建（例如默认出口换了IP）
+	if link.unspecified_local() {
+		if sourceIP := route_source_ip(remoteAddr); sourceIP != nil && !sourceIP.Equal(localIP) {
+			c.rebuild_link(link, fmt.Sprintf("本地地址变更 %s -> %s", localIP.String(), sourceIP.String()))
+			return
+		}
+	}
+
+	// 远程地址为域名时定期重新解析（例如服务端使用动态域名），解析结果变化时重建链路
+	if c.resolve_interval > 0 && core.IsHostname(link.RemoteAddr) && time.Since(link.resolved_at) >= c.resolve_interval {
+		link.resolved_at = time.Now()
+		resolved, err := net.ResolveUDPAddr(link.remote_network(link.bound), link.remote_target())
+		if err != nil {
+			fmt.Printf("[%s] 链路 %d 重新解析远程地址 %s 失败：%v\n", time.Now().Format("2006-01-02 15:04:05"), link.id, link.RemoteAddr, err)
+		} else if !core.SameUDPAddr(resolved, remoteAddr) {
+			c.rebuild_link(link, fmt.Sprintf("远程地址 %s 解析结果变更 %s -> %s", link.RemoteAddr, remoteAddr.String(), resolved.String()))
+			return
+		}
+	}
+
+	// 连续发送失败时重建链路，重建时会重新解析远程地址
+	if atomic.LoadInt32(&link.write_failures) >= link_write_failure_limit {
+		c.rebuild_link(link, "连续发送失败")
+		return
+	}
+
+	// 端口跳变
+	if c.hop_due(link) {
+		link.remote_port = pick_port(link.remote_ports, link.remote_port)
+		c.rebuild_link(link, "端口跳变")
+	}
+}
+
+// 链路检查线程，定期检查本地地址，地址消失或变化时自动重建链路
+// 启用了网卡自动发现时同时增删自动发现的链路；每一轮检查持有链路变更锁，不与重新加载配置交错
+func (c *Client) watch_links() {
+	for {
+		// 未启用时等待重新加载配置
+		interval := time.Duration(c.link_check_interval.Load())
+		if interval <= 0 {
+			if !c.sleep(1 * time.Second) {
+				return
+			}
+			continue
+		}
+		if !c.sleep(interval) {
+			return
+		}
+
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			fmt.Println("获取本地地址失败:", err)
+			continue
+		}
+
+		c.link_change_mutex.Lock()
+		if c.stopped() {
+			// 实例已停止，链路已全部移除
+			c.link_change_mutex.Unlock()
+			return
+		}
+		c.apply_server_params()
+
+		if len(c.discover_patterns) > 0 {
+			c.sync_discovered_links()
+		}
+
+		for _, link := range c.current_links() {
+			c.check_link(link, addrs)
+		}
+		c.link_change_mutex.Unlock()
+	}
+}
+
+// 通过链路发送一个包，并记录连续发送失败次数
+func (link *Link) write(packet []byte) error {
+	conn := link.Socket()
+	if conn == nil {
+		return err_link_down
+	}
+
+	if _, err := conn.Write(packet); err != nil {
+		atomic.AddInt32(&link.write_failures, 1)
+		return err
+	}
+
+	atomic.StoreInt32(&link.write_failures, 0)
+	atomic.StoreInt64(&link.last_send, time.Now().UnixNano())
+	atomic.AddInt64(&link.sent_total, 1)
+	return nil
+}
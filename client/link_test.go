@@ -0,0 +1,84 @@
+package client
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestLinkSetCopyOnWrite(t *testing.T) {
+	c, err := Start("", Config{
+		Links:   []LinkConfig{{Local: "127.0.0.1:0", Remote: remote_addr(t)}, {Local: "127.0.0.1:0", Remote: remote_addr(t)}},
+		Deliver: func([]byte) {},
+		MTU:     1400,
+		Mode:    "mode1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// 链路增删期间不断遍历快照（-race检查读取方不需要加锁）
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, link := range c.current_links() {
+					_ = link.settings.Load().Weight
+				}
+			}
+		}()
+	}
+
+	before := c.current_links()
+	snapshot := slices.Clone(before)
+
+	// 热增加
+	added, err := new_link(LinkConfig{Local: "127.0.0.1:0", Remote: remote_addr(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial_link(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.attach(added, conn)
+	c.add_link(added)
+
+	// 热删除
+	removed := before[0]
+	c.remove_link(removed)
+
+	close(stop)
+	readers.Wait()
+
+	// 之前取得的快照不受影响
+	if !slices.Equal(before, snapshot) {
+		t.Fatal("链路增删修改了之前取得的快照")
+	}
+
+	after := c.current_links()
+	if !slices.Equal(after, []*Link{before[1], added}) {
+		t.Fatalf("增删后的链路集合有 %d 条链路，内容不符", len(after))
+	}
+	if added.id <= before[1].id {
+		t.Fatalf("新链路编号 %d 不大于已有的 %d", added.id, before[1].id)
+	}
+
+	// 删除的链路关闭了套接字与发送队列，发送线程退出
+	if removed.Socket() != nil {
+		t.Fatal("删除的链路仍有套接字")
+	}
+	if removed.send_queue.Push([]byte("x")) {
+		t.Fatal("删除的链路的发送队列仍可以放入")
+	}
+	<-removed.sender_done
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 链路的本地地址、远程地址与地址族，三者相同的链路视为同一条
+type link_pair struct {
+	local  string
+	remote string
+	family string
+}
+
+// 返回链路配置对应的链路标识
+func pair_of(cfg LinkConfig) link_pair {
+	return link_pair{cfg.Local, cfg.Remote, cfg.Family}
+}
+
+// 启动时直接创建的链路：启用自动发现时没有发送地址的链路只提供远程地址，不单独创建
+func static_links(cfg Config) []LinkConfig {
+	if len(cfg.Discover) == 0 {
+		return cfg.Links
+	}
+
+	var configs []LinkConfig
+	for _, link := range cfg.Links {
+		if len(link.Local) > 0 {
+			configs = append(configs, link)
+		}
+	}
+	return configs
+}
+
+// 实际使用的链路检查间隔：自动发现、端口跳变与应用服务端参数都由链路检查线程完成，需要时强制启用
+func link_check_period(cfg Config) time.Duration {
+	if cfg.LinkCheck <= 0 && (len(cfg.Discover) > 0 || cfg.Hop > 0 || len(cfg.Server) > 0) {
+		return 2 * time.Second
+	}
+	return cfg.LinkCheck
+}
+
+// 按链路配置增删固定链路（自动发现的链路不受影响，只在持有链路变更锁时调用）
+// 本地地址、远程地址与地址族不变的链路保留原来的套接字，只更新名称、权重、成本与最大包体
+func (c *Client) sync_static_links(configs []LinkConfig) {
+	by_pair := make(map[link_pair][]LinkConfig)
+	for _, cfg := range configs {
+		by_pair[pair_of(cfg)] = append(by_pair[pair_of(cfg)], cfg)
+	}
+
+	kept := make(map[link_pair]int)
+	for _, link := range c.current_links() {
+		if link.discovered {
+			continue
+		}
+
+		pair := link.pair()
+		if kept[pair] < len(by_pair[pair]) {
+			update_link_settings(link, by_pair[pair][kept[pair]])
+			kept[pair]++
+			continue
+		}
+
+		c.remove_link(link)
+		fmt.Printf("[%s] 删除链路 %d，对端ip：%s\n", time.Now().Format("2006-01-02 15:04:05"), link.id, link.RemoteAddr)
+	}
+
+	seen := make(map[link_pair]int)
+	for _, cfg := range configs {
+		pair := pair_of(cfg)
+		if seen[pair]++; seen[pair] <= kept[pair] {
+			continue
+		}
+
+		link, err := new_link(cfg)
+		if err != nil {
+			fmt.Printf("创建链路失败：%v\n", err)
+			continue
+		}
+
+		conn, err := dial_link(link)
+		if err != nil {
+			fmt.Printf("链路 %s -> %s 创建失败，稍后重试：%v\n", cfg.Local, cfg.Remote, err)
+			link.down = true
+		} else {
+			c.attach(link, conn)
+		}
+
+		c.add_link(link)
+		fmt.Printf("[%s] 创建链路 %d，对端ip：%s\n", time.Now().Format("2006-01-02 15:04:05"), link.id, link.RemoteAddr)
+	}
+}
+
+// 更新链路的名称、权重、成本与最大包体，有变化时输出日志
+func update_link_settings(link *Link, cfg LinkConfig) {
+	if *link.settings.Load() == cfg {
+		return
+	}
+
+	link.settings.Store(&cfg)
+	fmt.Printf("[%s] 链路 %d（%s）配置更新：权重 %d，成本 %d，最大包体 %d\n", time.Now().Format("2006-01-02 15:04:05"), link.id, link.label(), max(cfg.Weight, 1), cfg.Cost, cfg.MTU)
+}
+
+// 返回新旧配置之间需要重启才能生效的变化（本地监听地址、服务端地址、最大包体与加密认证配置）
+func restart_changes(cfg Config, previous Config) []string {
+	var restart []string
+	if cfg.Listen != previous.Listen {
+		restart = append(restart, "本地监听地址")
+	}
+	if cfg.Server != previous.Server {
+		restart = append(restart, "服务端地址")
+	}
+	if cfg.MTU != previous.MTU {
+		restart = append(restart, "最大包体")
+	}
+	if !reflect.DeepEqual(cfg.Security, previous.Security) {
+		restart = append(restart, "加密与认证")
+	}
+	return restart
+}
+
+// Reload 重新加载配置：增删链路，原地更新链路权重、模式与各项间隔，会话与保留的链路不受影响
+// 本地监听地址、服务端地址、最大包体与加密认证配置需要重启才能生效，变化时继续使用原来的值，返回这些配置项的名称
+func (c *Client) Reload(cfg Config) ([]string, error) {
+	c.link_change_mutex.Lock()
+	defer c.link_change_mutex.Unlock()
+
+	if c.stopped() {
+		return nil, err_stopped
+	}
+
+	// 先检查全部链路配置，有错误时不做任何修改
+	for index, link := range cfg.Links {
+		if len(link.Remote) == 0 {
+			link.Remote = c.running_config.Server
+		}
+		if _, err := new_link(link); err != nil {
+			return nil, fmt.Errorf("链路 %d 配置错误: %v", index, err)
+		}
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	previous := c.running_config
+
+	restart := restart_changes(cfg, previous)
+	if len(restart) > 0 {
+		fmt.Printf("[%s] 以下配置需要重启才能生效，继续使用原来的值：%s\n", now, strings.Join(restart, "、"))
+		cfg.Listen, cfg.Server, cfg.MTU, cfg.Security = previous.Listen, previous.Server, previous.MTU, previous.Security
+	}
+
+	// 模式变化后重新与服务端协商
+	if cfg.Mode != previous.Mode || cfg.DownMode != previous.DownMode {
+		c.mode.Store(cfg.Mode)
+
+		hello := *c.local_hello.Load()
+		hello.Mode, hello.DownMode = cfg.Mode, cfg.DownMode
+		c.local_hello.Store(&hello)
+		c.request_config()
+		fmt.Printf("[%s] 上行模式 %s，请求的下行模式 %s，重新与服务端协商\n", now, cfg.Mode, cfg.DownMode)
+	}
+
+	c.resolve_interval = cfg.ResolveInterval
+	c.hop_interval = cfg.Hop
+	c.stats_interval.Store(int64(cfg.StatsInterval))
+	c.link_check_interval.Store(int64(link_check_period(cfg)))
+
+	// 保活间隔变化后重新请求参数，服务端建议的更短间隔会被重新采用
+	if cfg.Keepalive != previous.Keepalive {
+		c.keepalive_interval.Store(int64(cfg.Keepalive))
+		c.request_config()
+	}
+
+	// 固定链路：只使用-server时与服务端监听地址重新配对（还没有收到监听地址时等收到后再创建）
+	if len(c.server_addr) > 0 {
+		c.config_links = static_links(cfg)
+		if len(c.config_endpoints) > 0 {
+			c.sync_static_links(c.endpoint_links(c.config_endpoints))
+		}
+	} else {
+		c.discover_remotes = cfg.Links
+		c.sync_static_links(static_links(cfg))
+	}
+
+	// 自动发现的链路：不再启用时全部删除
+	if !slices.Equal(cfg.Discover, previous.Discover) {
+		c.discover_patterns = cfg.Discover
+		if len(c.discover_patterns) == 0 {
+			for _, link := range c.current_links() {
+				if link.discovered {
+					c.remove_link(link)
+					fmt.Printf("[%s] 不再自动发现网卡，删除链路 %d（%s）\n", now, link.id, link.Interface)
+				}
+			}
+		}
+	}
+	if len(c.discover_patterns) > 0 && len(c.discover_remotes) > 0 {
+		c.sync_discovered_links()
+	}
+
+	c.running_config = cfg
+	fmt.Printf("[%s] %s配置已重新加载，当前链路 %d 条\n", now, c.tag(), len(c.current_links()))
+	return restart, nil
+}
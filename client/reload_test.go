@@ -0,0 +1,149 @@
+package client
+
+import (
+	"UDPRainbowBridge/core"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestartChanges(t *testing.T) {
+	base := Config{
+		Links:     []LinkConfig{{Local: "127.0.0.1:0", Remote: "203.0.113.1:9000"}},
+		Listen:    "127.0.0.1:8000",
+		MTU:       1400,
+		Mode:      "mode1",
+		Security:  core.SecurityConfig{PSK: "secret"},
+		Keepalive: time.Second,
+	}
+
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   []string
+	}{
+		{"不变", func(cfg *Config) {}, nil},
+		{"增删链路", func(cfg *Config) {
+			cfg.Links = []LinkConfig{{Local: "127.0.0.2:0", Remote: "203.0.113.1:9001"}, {Remote: "203.0.113.2:9000"}}
+		}, nil},
+		{"链路权重与成本", func(cfg *Config) {
+			cfg.Links = []LinkConfig{{Local: "127.0.0.1:0", Remote: "203.0.113.1:9000", Weight: 3, Cost: 1}}
+		}, nil},
+		{"模式切换", func(cfg *Config) { cfg.Mode, cfg.DownMode = "mode2", "mode1" }, nil},
+		{"各项间隔", func(cfg *Config) { cfg.Keepalive, cfg.StatsInterval, cfg.Hop = 2*time.Second, time.Minute, time.Second }, nil},
+		{"自动发现", func(cfg *Config) { cfg.Discover = []string{"eth*"} }, nil},
+		{"本地监听地址", func(cfg *Config) { cfg.Listen = "127.0.0.1:8001" }, []string{"本地监听地址"}},
+		{"服务端地址", func(cfg *Config) { cfg.Server = "203.0.113.1:9000" }, []string{"服务端地址"}},
+		{"最大包体", func(cfg *Config) { cfg.MTU = 1500 }, []string{"最大包体"}},
+		{"加密与认证", func(cfg *Config) { cfg.Security.Cipher = core.CipherChaCha20 }, []string{"加密与认证"}},
+		{"混淆", func(cfg *Config) { cfg.Security.Obfs = []string{"xor"} }, []string{"加密与认证"}},
+		{"同时修改", func(cfg *Config) {
+			cfg.MTU, cfg.Mode, cfg.Listen = 1500, "mode2", "127.0.0.1:8001"
+		}, []string{"本地监听地址", "最大包体"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Links = slices.Clone(base.Links)
+			tt.change(&cfg)
+			if got := restart_changes(cfg, base); !slices.Equal(got, tt.want) {
+				t.Fatalf("需要重启的配置 %v，应为 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 返回一个本机UDP地址作为链路的远程地址，测试结束时关闭
+func remote_addr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+// 按远程地址查找链路
+func link_to(c *Client, remote string) *Link {
+	for _, link := range c.current_links() {
+		if link.RemoteAddr == remote {
+			return link
+		}
+	}
+	return nil
+}
+
+func TestReloadLinks(t *testing.T) {
+	kept_remote, removed_remote, added_remote := remote_addr(t), remote_addr(t), remote_addr(t)
+	cfg := Config{
+		Links:   []LinkConfig{{Local: "127.0.0.1:0", Remote: kept_remote}, {Local: "127.0.0.1:0", Remote: removed_remote}},
+		Deliver: func([]byte) {},
+		MTU:     1400,
+		Mode:    "mode1",
+	}
+	c, err := Start("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	kept := link_to(c, kept_remote)
+	if kept == nil || link_to(c, removed_remote) == nil {
+		t.Fatal("启动后缺少链路")
+	}
+	kept_socket := kept.Socket()
+
+	// 保留一条链路并修改权重，删除一条，新增一条；同时切换模式、修改最大包体
+	reloaded := cfg
+	reloaded.Links = []LinkConfig{{Local: "127.0.0.1:0", Remote: kept_remote, Weight: 3}, {Local: "127.0.0.1:0", Remote: added_remote}}
+	reloaded.Mode = "mode2"
+	reloaded.MTU = 1500
+	restart, err := c.Reload(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restart, []string{"最大包体"}) {
+		t.Fatalf("需要重启的配置 %v，应为 [最大包体]", restart)
+	}
+
+	if len(c.current_links()) != 2 {
+		t.Fatalf("重新加载后有 %d 条链路，应为 2 条", len(c.current_links()))
+	}
+	if link_to(c, kept_remote) != kept || kept.Socket() != kept_socket {
+		t.Fatal("没有变化的链路被重新创建")
+	}
+	if weight := kept.settings.Load().Weight; weight != 3 {
+		t.Fatalf("保留的链路权重 %d，应为 3", weight)
+	}
+	if link_to(c, removed_remote) != nil {
+		t.Fatal("删除的链路仍在链路集合中")
+	}
+	added := link_to(c, added_remote)
+	if added == nil || added.Socket() == nil {
+		t.Fatal("新增的链路没有创建")
+	}
+	if mode := c.mode.Load().(string); mode != "mode2" {
+		t.Fatalf("上行模式 %s，应为 mode2", mode)
+	}
+	if c.running_config.MTU != 1400 {
+		t.Fatalf("需要重启的最大包体被修改为 %d", c.running_config.MTU)
+	}
+
+	// 链路配置有错误时不做任何修改
+	broken := reloaded
+	broken.Links = []LinkConfig{{Local: "127.0.0.1:0", Remote: "not an address"}}
+	if _, err := c.Reload(broken); err == nil {
+		t.Fatal("链路配置错误时重新加载成功")
+	}
+	if len(c.current_links()) != 2 || link_to(c, kept_remote) != kept || link_to(c, added_remote) != added {
+		t.Fatal("重新加载失败后链路被修改")
+	}
+
+	c.Close()
+	if _, err := c.Reload(reloaded); err != err_stopped {
+		t.Fatalf("停止后重新加载返回 %v，应为 err_stopped", err)
+	}
+}
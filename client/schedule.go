@@ -0,0 +1,43 @@
+package client
+
+// 选出一个包可以使用的链路：套接字可用、包不超过链路最大包体，并且在满足条件的链路中成本最低
+func eligible_links(current []*Link, size int) []*Link {
+	var eligible []*Link
+	lowest := 0
+	for _, link := range current {
+		settings := link.settings.Load()
+		if link.Socket() == nil || (settings.MTU > 0 && size > settings.MTU) {
+			continue
+		}
+
+		if len(eligible) > 0 && settings.Cost > lowest {
+			continue
+		}
+		if len(eligible) > 0 && settings.Cost < lowest {
+			eligible = eligible[:0]
+		}
+		lowest = settings.Cost
+		eligible = append(eligible, link)
+	}
+	return eligible
+}
+
+// 平滑加权轮询：每次给所有链路加上各自的权重，选出当前值最大的链路并减去总权重
+// 权重为2:1时发送顺序为A、B、A，不会连续挤在同一条链路上（只在持有发送锁时调用），权重为0按1处理
+func pick_weighted(eligible []*Link) *Link {
+	var best *Link
+	total := 0
+	for _, link := range eligible {
+		weight := max(link.settings.Load().Weight, 1)
+		link.current_weight += weight
+		total += weight
+		if best == nil || link.current_weight > best.current_weight {
+			best = link
+		}
+	}
+
+	if best != nil {
+		best.current_weight -= total
+	}
+	return best
+}
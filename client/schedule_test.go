@@ -0,0 +1,88 @@
+package client
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+// 按配置创建不需要套接字的链路
+func weighted_links(configs ...LinkConfig) []*Link {
+	links := make([]*Link, len(configs))
+	for index, cfg := range configs {
+		links[index] = &Link{id: index}
+		links[index].settings.Store(&cfg)
+	}
+	return links
+}
+
+func TestPickWeighted(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		// 一轮（权重之和个包）的发送顺序
+		want []int
+	}{
+		{"2:1", []int{2, 1}, []int{0, 1, 0}},
+		{"5:1:1", []int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0}},
+		{"权重相同", []int{1, 1, 1}, []int{0, 1, 2}},
+		{"权重为0按1处理", []int{0, 2}, []int{1, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configs []LinkConfig
+			for _, weight := range tt.weights {
+				configs = append(configs, LinkConfig{Weight: weight})
+			}
+			links := weighted_links(configs...)
+
+			// 连续多轮的顺序相同，每轮按权重分配
+			for round := 0; round < 3; round++ {
+				var got []int
+				for range tt.want {
+					got = append(got, pick_weighted(links).id)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Fatalf("第 %d 轮发送顺序 %v，应为 %v", round, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if pick_weighted(nil) != nil {
+		t.Fatal("没有链路时选出了链路")
+	}
+}
+
+func TestEligibleLinks(t *testing.T) {
+	links := weighted_links(
+		LinkConfig{Cost: 0, MTU: 1000},
+		LinkConfig{Cost: 0},
+		LinkConfig{Cost: 1},
+		LinkConfig{Cost: 0},
+	)
+	for _, link := range links[:3] {
+		link.socket.Store(&net.UDPConn{})
+	}
+
+	ids := func(eligible []*Link) []int {
+		var result []int
+		for _, link := range eligible {
+			result = append(result, link.id)
+		}
+		return result
+	}
+
+	// 没有套接字的链路不可用，成本更高的链路只在成本低的都不可用时使用
+	if got := ids(eligible_links(links, 500)); !slices.Equal(got, []int{0, 1}) {
+		t.Fatalf("小包可用的链路 %v，应为 [0 1]", got)
+	}
+	if got := ids(eligible_links(links, 1200)); !slices.Equal(got, []int{1}) {
+		t.Fatalf("超过链路最大包体的包可用的链路 %v，应为 [1]", got)
+	}
+	links[1].socket.Store(nil)
+	if got := ids(eligible_links(links, 1200)); !slices.Equal(got, []int{2}) {
+		t.Fatalf("低成本链路都不可用时可用的链路 %v，应为 [2]", got)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Shutdown 平滑停止客户端实例：不再接收本地包，等待各链路的发送队列发完（最多timeout），
+// 通知服务端会话关闭，然后Close并输出最终统计；可以重复调用，之后的调用等待停止完成
+func (c *Client) Shutdown(timeout time.Duration) {
+	if !c.input_closed.CompareAndSwap(false, true) || c.stopped() {
+		// 已经在停止中或已停止，等待停止完成
+		<-c.done
+		c.Close()
+		return
+	}
+
+	// 等待正在编码的包放入发送队列，之后send_local不会再放入新的包
+	c.send_mutex.Lock()
+	c.send_mutex.Unlock()
+
+	// 关闭发送队列，发送线程发完剩余的包后退出；之后Close关闭套接字时不会有发送中的包
+
+	if remaining := c.drain(timeout); remaining > 0 {
+		fmt.Printf("%s发送队列在 %v 内没有发完，丢弃剩余的 %d 个包\n", c.tag(), timeout, remaining)
+	}
+
+	// 在所有链路上通知服务端，任意一条链路送达即可（每条链路分别生成，计数器不同）
+	for _, link := range c.current_links() {
+		if packet := c.engine.NewClose(); packet != nil {
+			c.write_link(link, packet)
+		}
+	}
+
+	stats := c.Stats()
+	c.Close()
+	c.print_final_stats(stats)
+}
+
+// 关闭各链路的发送队列并等待发送线程退出（最多timeout），返回超时时仍未发送的包数
+func (c *Client) drain(timeout time.Duration) int {
+	links := c.current_links()
+	for _, link := range links {
+		link.send_queue.Close()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for index, link := range links {
+		select {
+		case <-link.sender_done:
+		case <-timer.C:
+			remaining := 0
+			for _, link := range links[index:] {
+				remaining += link.send_queue.Len()
+			}
+			return remaining
+		}
+	}
+	return 0
+}
+
+// 服务端关闭会话：服务端可能正在重启，重新请求参数，启用握手时重新建立会话
+func (c *Client) handle_server_close(link *Link, buf []byte) {
+	if _, ok := c.engine.OpenClose(buf); !ok {
+		link.auth_failed()
+		return
+	}
+	if !c.negotiated.Swap(false) {
+		// 多条链路上都会收到，只处理一次
+		return
+	}
+
+	fmt.Printf("[%s] %s服务端关闭会话（套接字 %d），重新请求参数\n", time.Now().Format("2006-01-02 15:04:05"), c.tag(), link.id)
+	c.engine.RequestRekey()
+	c.request_config()
+}
+
+// 输出停止时的累计统计
+func (c *Client) print_final_stats(stats Stats) {
+	fmt.Printf("[%s] %s客户端已停止，从本地应用收到 %d 个包，转发给本地应用 %d 个包\n", time.Now().Format("2006-01-02 15:04:05"), c.tag(), stats.Input, stats.Output)
+	for _, link := range stats.Links {
+		fmt.Printf("套接字 %d（%s -> %s）发送 %d，命中 %d，认证失败 %d\n", link.ID, link.Local, link.Remote, link.Sent, link.Hits, link.AuthFailures)
+	}
+}
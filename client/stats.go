@@ -0,0 +1,74 @@
+package client
+
+import "sync/atomic"
+
+// Stats 客户端实例的累计统计，统计日志输出后清零的计数不影响这里
+type Stats struct {
+	// 从本地应用收到的包数
+	Input uint64
+
+	// 去重之后转发给本地应用的包数
+	Output uint64
+
+	// 是否已与服务端协商成功
+	Negotiated bool
+
+	// 每条链路的统计
+	Links []LinkStats
+}
+
+// LinkStats 一条聚合链路的累计统计
+type LinkStats struct {
+	// 链路编号与名称
+	ID   int
+	Name string
+
+	// 当前的本地地址（链路不可用时为配置的发送地址）与远程地址
+	Local  string
+	Remote string
+
+	// 链路当前是否可用
+	Up bool
+
+	// 发送成功的包数（包括保活与控制帧）
+	Sent uint64
+
+	// 首先到达、被转发给本地应用的包数
+	Hits uint64
+
+	// 认证失败的包数
+	AuthFailures uint64
+}
+
+// 记录一个认证失败的包
+func (link *Link) auth_failed() {
+	atomic.AddInt64(&link.auth_fail_count, 1)
+	atomic.AddInt64(&link.auth_fail_total, 1)
+}
+
+// Stats 返回实例的累计统计
+func (c *Client) Stats() Stats {
+	stats := Stats{
+		Input:      uint64(atomic.LoadInt64(&c.input_total)),
+		Output:     uint64(atomic.LoadInt64(&c.output_total)),
+		Negotiated: c.negotiated.Load(),
+	}
+
+	for _, link := range c.current_links() {
+		item := LinkStats{
+			ID:           link.id,
+			Name:         link.settings.Load().Name,
+			Local:        link.LocalAddr,
+			Remote:       link.RemoteAddr,
+			Sent:         uint64(atomic.LoadInt64(&link.sent_total)),
+			Hits:         uint64(atomic.LoadInt64(&link.hit_total)),
+			AuthFailures: uint64(atomic.LoadInt64(&link.auth_fail_total)),
+		}
+		if conn := link.Socket(); conn != nil {
+			item.Up = true
+			item.Local = conn.LocalAddr().String()
+		}
+		stats.Links = append(stats.Links, item)
+	}
+	return stats
+}
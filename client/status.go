@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Status 返回当前的协商结果与每条链路的状态，供管理接口的 status 命令使用
+func (c *Client) Status() string {
+	var b strings.Builder
+
+	c.negotiate_mutex.Lock()
+	agreement, reason := c.last_agreement, c.last_refused
+	c.negotiate_mutex.Unlock()
+
+	switch {
+	case len(reason) > 0:
+		fmt.Fprintf(&b, "协商：被服务端拒绝（%s）\n", reason)
+	case agreement != nil:
+		fmt.Fprintf(&b, "协商：协议版本 %d，上行模式 %s，下行模式 %s，MTU %d，功能 %v\n", agreement.Version, agreement.UpMode, agreement.DownMode, agreement.MTU, agreement.Features)
+	default:
+		fmt.Fprintf(&b, "协商：等待服务端回复\n")
+	}
+	fmt.Fprintf(&b, "保活间隔：%v\n", c.keepalive_period())
+
+	for _, link := range c.current_links() {
+		settings := link.settings.Load()
+		state := "不可用"
+		local := link.LocalAddr
+		if conn := link.Socket(); conn != nil {
+			state = "可用"
+			local = conn.LocalAddr().String()
+		}
+
+		last := "从未发送"
+		if sent := atomic.LoadInt64(&link.last_send); sent > 0 {
+			last = fmt.Sprintf("%v前发送", time.Since(time.Unix(0, sent)).Round(time.Millisecond))
+		}
+
+		fmt.Fprintf(&b, "链路 %d（%s）：%s -> %s，%s，权重 %d，成本 %d，最大包体 %d，%s\n", link.id, link.label(), local, link.RemoteAddr, state, max(settings.Weight, 1), settings.Cost, settings.MTU, last)
+	}
+	return b.String()
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"UDPRainbowBridge/client"
+	"UDPRainbowBridge/core"
+	"UDPRainbowBridge/server"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration 配置文件中的时间间隔，写成字符串，例如 "10s"、"5m"、"0"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("时间间隔需要写成字符串，例如 \"10s\"")
+	}
+
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("无效的时间间隔 %q", text)
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config 配置文件
+// 命令行参数会覆盖配置文件中的对应项
+type Config struct {
+	// 实例名称，多实例配置中必填，用于日志、统计与管理接口
+	Name string `json:"name"`
+
+	// 运行角色：server、client
+	Role string `json:"role"`
+
+	// 模式（客户端为上行模式，服务端为默认的下行模式）与客户端请求的下行模式
+	Mode     string `json:"mode"`
+	DownMode string `json:"down_mode"`
+
+	// 最大包体
+	MTU int `json:"mtu"`
+
+	// 服务端地址（客户端用），设置后链路不需要配置远程地址
+	Server string `json:"server"`
+
+	// 隧道：客户端的本地监听地址与服务端的转发地址，目前只支持一个
+	Tunnels []TunnelConfig `json:"tunnels"`
+
+	// 链路：客户端为聚合链路，服务端为监听端口
+	Links []LinkConfig `json:"links"`
+
+	// 自动发现上行网卡的名称匹配模式（客户端用）
+	Discover []string `json:"discover"`
+
+	Security  SecurityConfig  `json:"security"`
+	Admission AdmissionConfig `json:"admission"`
+
+	// 客户端链路检查、端口跳变与保活间隔
+	LinkCheck Duration `json:"link_check"`
+	Hop       Duration `json:"hop"`
+	Keepalive Duration `json:"keepalive"`
+
+	// 转发地址为域名时的重新解析间隔
+	ResolveInterval Duration `json:"resolve_interval"`
+
+	// 服务端链路超时
+	LinkTimeout Duration `json:"link_timeout"`
+
+	Observability ObservabilityConfig `json:"observability"`
+
+	// 同一进程内运行的多个实例（客户端、服务端都可以），每个实例是一份完整的配置
+	// 配置了实例时顶层只写管理接口，各实例的去重、会话、链路与统计互不影响
+	Instances instance_list `json:"instances"`
+}
+
+// 多实例配置，每个实例都从默认配置开始解析
+type instance_list []*Config
+
+func (list *instance_list) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("instances 应为数组")
+	}
+
+	*list = nil
+	for index, item := range items {
+		cfg := default_config()
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			return fmt.Errorf("instances[%d]（行列从该实例开始计算）%v", index, describe_json_error(item, err))
+		}
+		*list = append(*list, cfg)
+	}
+	return nil
+}
+
+// TunnelConfig 一条隧道：客户端在listen上接收应用的包，服务端把包转发到forward
+type TunnelConfig struct {
+	Name          string `json:"name"`
+	Listen        string `json:"listen"`
+	Forward       string `json:"forward"`
+	ForwardFamily string `json:"forward_family"`
+}
+
+// LinkConfig 一条链路
+// 客户端使用local、remote、family、weight、cost、mtu；服务端使用listen、advertise、allow_cidr
+type LinkConfig struct {
+	Name   string `json:"name"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	Family string `json:"family"`
+	Weight int    `json:"weight"`
+	Cost   int    `json:"cost"`
+	MTU    int    `json:"mtu"`
+
+	Listen    string   `json:"listen"`
+	Advertise string   `json:"advertise"`
+	AllowCIDR []string `json:"allow_cidr"`
+}
+
+// SecurityConfig 帧认证、加密、握手与混淆
+type SecurityConfig struct {
+	PSK        string   `json:"psk"`
+	Cipher     string   `json:"cipher"`
+	Key        string   `json:"key"`
+	PeerKey    string   `json:"peer_key"`
+	AllowKeys  []string `json:"allow_keys"`
+	Rekey      Duration `json:"rekey"`
+	RekeyBytes uint64   `json:"rekey_bytes"`
+	Obfs       []string `json:"obfs"`
+	ObfsKey    string   `json:"obfs_key"`
+	ObfsPad    int      `json:"obfs_pad"`
+}
+
+// AdmissionConfig 服务端准入过滤
+type AdmissionConfig struct {
+	AllowCIDR      []string `json:"allow_cidr"`
+	RatePPS        float64  `json:"rate_pps"`
+	RateBPS        float64  `json:"rate_bps"`
+	UnvalidatedPPS float64  `json:"unvalidated_pps"`
+}
+
+// ObservabilityConfig 统计日志与管理接口
+type ObservabilityConfig struct {
+	// 统计日志间隔，0表示不输出
+	StatsInterval Duration `json:"stats_interval"`
+
+	// 管理接口监听地址（TCP），为空表示不启用
+	Admin string `json:"admin"`
+}
+
+// 默认配置，与命令行参数的默认值一致
+func default_config() *Config {
+	return &Config{
+		Mode: "mode1",
+		MTU:  1492,
+		Security: SecurityConfig{
+			Cipher:     core.CipherNone,
+			Rekey:      Duration(2 * time.Minute),
+			RekeyBytes: 1 << 30,
+			Obfs:       []string{"none"},
+			ObfsPad:    32,
+		},
+		Admission: AdmissionConfig{
+			UnvalidatedPPS: 10,
+		},
+		LinkCheck:       Duration(2 * time.Second),
+		Keepalive:       Duration(10 * time.Second),
+		ResolveInterval: Duration(5 * time.Minute),
+		LinkTimeout:     Duration(30 * time.Second),
+		Observability: ObservabilityConfig{
+			StatsInterval: Duration(5 * time.Second),
+		},
+	}
+}
+
+// 读取配置文件，没有写的项使用默认值
+func load_config(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := default_config()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, describe_json_error(data, err))
+	}
+	return cfg, nil
+}
+
+// 把JSON解析错误转换为带行号与列号的说明
+func describe_json_error(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s 的类型应为 %s", typeErr.Field, typeErr.Type)
+	} else if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Errorf("未知的配置项 %s", field)
+	} else {
+		return err
+	}
+
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Errorf("第 %d 行第 %d 列: %v", line, column, err)
+}
+
+// 配置错误收集器，每条错误带上出错项的位置
+type config_errors []error
+
+func (errs *config_errors) add(field string, format string, args ...interface{}) {
+	*errs = append(*errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+// 链路在错误信息中的位置，有名称时带上名称
+func link_field(index int, link LinkConfig, name string) string {
+	if len(link.Name) > 0 {
+		return fmt.Sprintf("links[%d]（%s）.%s", index, link.Name, name)
+	}
+	return fmt.Sprintf("links[%d].%s", index, name)
+}
+
+// 检查地址格式：主机:端口，端口可以写成范围
+func check_addr(addr string, allowEmptyHost bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("地址 %q 格式错误，应为 主机:端口（IPv6地址需要用[]包裹）", addr)
+	}
+	if len(host) == 0 && !allowEmptyHost {
+		return fmt.Errorf("地址 %q 缺少主机", addr)
+	}
+	if _, _, err := core.ParsePortRange(port); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 检查客户端的发送地址：IP:端口、网卡名称或网卡名称:端口
+func check_local(local string) error {
+	if _, port, err := net.SplitHostPort(local); err == nil {
+		_, _, err = core.ParsePortRange(port)
+		return err
+	}
+	if strings.ContainsAny(local, ":[]") {
+		return fmt.Errorf("发送地址 %q 格式错误，应为 IP:端口 或网卡名称", local)
+	}
+	return nil
+}
+
+// Validate 检查配置，返回所有错误
+func (cfg *Config) Validate() error {
+	if len(cfg.Instances) > 0 {
+		return cfg.validate_instances()
+	}
+
+	var errs config_errors
+
+	if cfg.Role != "server" && cfg.Role != "client" {
+		errs.add("role", "应为 server 或 client（或使用子命令 server、client），当前为 %q", cfg.Role)
+	}
+	if cfg.Mode != "mode1" && cfg.Mode != "mode2" {
+		errs.add("mode", "应为 mode1 或 mode2，当前为 %q", cfg.Mode)
+	}
+	if len(cfg.DownMode) > 0 && cfg.DownMode != "mode1" && cfg.DownMode != "mode2" {
+		errs.add("down_mode", "应为 mode1、mode2 或为空，当前为 %q", cfg.DownMode)
+	}
+	if cfg.MTU < core.MinMTU || cfg.MTU > 65507 {
+		errs.add("mtu", "应在 %d-65507 之间，当前为 %d", core.MinMTU, cfg.MTU)
+	}
+
+	if len(cfg.Tunnels) != 1 {
+		errs.add("tunnels", "需要且目前只支持一个隧道，当前为 %d 个", len(cfg.Tunnels))
+	}
+
+	durations := []struct {
+		field string
+		value Duration
+	}{
+		{"link_check", cfg.LinkCheck}, {"hop", cfg.Hop}, {"keepalive", cfg.Keepalive},
+		{"resolve_interval", cfg.ResolveInterval}, {"link_timeout", cfg.LinkTimeout},
+		{"security.rekey", cfg.Security.Rekey}, {"observability.stats_interval", cfg.Observability.StatsInterval},
+	}
+	for _, item := range durations {
+		if item.value < 0 {
+			errs.add(item.field, "不能为负数")
+		}
+	}
+
+	security_valid := true
+	if !core.CipherSupported(cfg.Security.Cipher) {
+		errs.add("security.cipher", "不支持的加密算法 %q（可选 none、chacha20-poly1305、aes-256-gcm）", cfg.Security.Cipher)
+		security_valid = false
+	}
+	for index, name := range cfg.Security.Obfs {
+		if name = strings.TrimSpace(name); len(name) > 0 && !core.ObfuscatorRegistered(name) {
+			errs.add(fmt.Sprintf("security.obfs[%d]", index), "不支持的混淆方式 %q（可选 none、mask、stun）", name)
+			security_valid = false
+		}
+	}
+
+	// 其余安全配置按启动时相同的规则检查：密钥格式、加密需要预共享密钥或握手、握手服务端需要公钥白名单等
+	if security_valid && (cfg.Role == "client" || cfg.Role == "server") {
+		if _, err := core.NewEngine(cfg.security(), cfg.Role == "server"); err != nil {
+			errs.add("security", "%v", err)
+		}
+	}
+
+	if len(cfg.Observability.Admin) > 0 {
+		if err := check_addr(cfg.Observability.Admin, true); err != nil {
+			errs.add("observability.admin", "%v", err)
+		}
+	}
+
+	names := make(map[string]int)
+	for index, link := range cfg.Links {
+		if len(link.Name) == 0 {
+			continue
+		}
+		if first, exists := names[link.Name]; exists {
+			errs.add(link_field(index, link, "name"), "与 links[%d] 重名", first)
+		}
+		names[link.Name] = index
+	}
+
+	if cfg.Role == "client" {
+		cfg.validate_client(&errs)
+	} else if cfg.Role == "server" {
+		cfg.validate_server(&errs)
+	}
+
+	return errors.Join(errs...)
+}
+
+// 检查多实例配置：每个实例单独检查，实例需要不重复的名称，管理接口由全部实例共用，只能写在顶层
+func (cfg *Config) validate_instances() error {
+	var errs config_errors
+
+	if len(cfg.Role) > 0 || len(cfg.Server) > 0 || len(cfg.Tunnels) > 0 || len(cfg.Links) > 0 || len(cfg.Discover) > 0 {
+		errs.add("instances", "配置了 instances 时 role、server、tunnels、links、discover 需要写在各个实例中")
+	}
+	if len(cfg.Observability.Admin) > 0 {
+		if err := check_addr(cfg.Observability.Admin, true); err != nil {
+			errs.add("observability.admin", "%v", err)
+		}
+	}
+
+	names := make(map[string]int)
+	for index, instance := range cfg.Instances {
+		field := fmt.Sprintf("instances[%d]", index)
+		if len(instance.Name) == 0 {
+			errs.add(field+".name", "多实例配置中每个实例都需要名称")
+		} else if first, exists := names[instance.Name]; exists {
+			errs.add(field+".name", "与 instances[%d] 重名", first)
+		} else {
+			names[instance.Name] = index
+		}
+
+		if len(instance.Instances) > 0 {
+			errs.add(field+".instances", "实例中不能再配置实例")
+			continue
+		}
+		if len(instance.Observability.Admin) > 0 {
+			errs.add(field+".observability.admin", "管理接口由全部实例共用，只能写在顶层")
+		}
+
+		if err := instance.Validate(); err != nil {
+			for _, item := range err.(interface{ Unwrap() []error }).Unwrap() {
+				errs = append(errs, fmt.Errorf("%s.%v", field, item))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// 返回需要运行的全部实例的配置，没有配置实例时只有顶层这一个
+func (cfg *Config) instance_configs() []*Config {
+	if len(cfg.Instances) > 0 {
+		return cfg.Instances
+	}
+	return []*Config{cfg}
+}
+
+// 检查客户端配置
+func (cfg *Config) validate_client(errs *config_errors) {
+	if len(cfg.Tunnels) == 1 {
+		if err := check_addr(cfg.Tunnels[0].Listen, false); err != nil {
+			errs.add("tunnels[0].listen", "%v", err)
+		}
+	}
+
+	if len(cfg.Server) > 0 {
+		if err := check_addr(cfg.Server, false); err != nil {
+			errs.add("server", "%v", err)
+		}
+	} else if len(cfg.Links) == 0 {
+		// 自动发现的链路使用配置的链路的远程地址，只配置 discover 时没有远程地址
+		errs.add("links", "至少需要一条带远程地址的链路（或配置 server）")
+	}
+
+	for index, link := range cfg.Links {
+		if len(cfg.Server) == 0 {
+			if err := check_addr(link.Remote, false); err != nil {
+				errs.add(link_field(index, link, "remote"), "%v", err)
+			}
+		} else if len(link.Remote) > 0 {
+			errs.add(link_field(index, link, "remote"), "配置了 server 时远程地址由服务端下发，不能再指定")
+		}
+
+		if len(link.Local) > 0 {
+			if err := check_local(link.Local); err != nil {
+				errs.add(link_field(index, link, "local"), "%v", err)
+			}
+		}
+		if _, err := core.ResolveNetwork(link.Family); err != nil {
+			errs.add(link_field(index, link, "family"), "%v", err)
+		}
+		if link.Weight < 0 || link.Weight > 100 {
+			errs.add(link_field(index, link, "weight"), "应在 0-100 之间（0按1处理），当前为 %d", link.Weight)
+		}
+		if link.Cost < 0 {
+			errs.add(link_field(index, link, "cost"), "不能为负数")
+		}
+		if link.MTU != 0 && (link.MTU < core.MinMTU || link.MTU > cfg.MTU) {
+			errs.add(link_field(index, link, "mtu"), "应为0或在 %d-%d（全局mtu）之间，当前为 %d", core.MinMTU, cfg.MTU, link.MTU)
+		}
+		if len(link.Listen) > 0 || len(link.Advertise) > 0 || len(link.AllowCIDR) > 0 {
+			errs.add(link_field(index, link, "listen"), "listen、advertise、allow_cidr 只能在服务端使用")
+		}
+	}
+}
+
+// 检查服务端配置
+func (cfg *Config) validate_server(errs *config_errors) {
+	if len(cfg.Tunnels) == 1 {
+		tunnel := cfg.Tunnels[0]
+		if err := check_addr(tunnel.Forward, false); err != nil {
+			errs.add("tunnels[0].forward", "%v", err)
+		}
+		if _, err := core.ResolveNetwork(tunnel.ForwardFamily); err != nil {
+			errs.add("tunnels[0].forward_family", "%v", err)
+		}
+	}
+
+	if len(cfg.Links) == 0 {
+		errs.add("links", "至少需要一个监听端口")
+	}
+
+	advertised := 0
+	for index, link := range cfg.Links {
+		if err := check_addr(link.Listen, true); err != nil {
+			errs.add(link_field(index, link, "listen"), "%v", err)
+		}
+		if len(link.Advertise) > 0 {
+			advertised++
+			if err := check_addr(link.Advertise, true); err != nil {
+				errs.add(link_field(index, link, "advertise"), "%v", err)
+			}
+		}
+		if _, err := core.ParseCIDRList(link.AllowCIDR); err != nil {
+			errs.add(link_field(index, link, "allow_cidr"), "%v", err)
+		}
+		if len(link.Local) > 0 || len(link.Remote) > 0 || link.Weight != 0 || link.Cost != 0 || link.MTU != 0 {
+			errs.add(link_field(index, link, "local"), "local、remote、weight、cost、mtu 只能在客户端使用")
+		}
+	}
+	if advertised > 0 && advertised < len(cfg.Links) {
+		errs.add("links", "advertise 需要为每个监听端口都配置，或都不配置")
+	}
+
+	if _, err := core.ParseCIDRList(cfg.Admission.AllowCIDR); err != nil {
+		errs.add("admission.allow_cidr", "%v", err)
+	}
+	if cfg.Admission.RatePPS < 0 || cfg.Admission.RateBPS < 0 || cfg.Admission.UnvalidatedPPS < 0 {
+		errs.add("admission", "限速值不能为负数")
+	}
+}
+
+// 生成核心模块使用的安全配置
+func (cfg *Config) security() core.SecurityConfig {
+	return core.SecurityConfig{
+		PSK:           cfg.Security.PSK,
+		Cipher:        cfg.Security.Cipher,
+		PrivateKey:    cfg.Security.Key,
+		PeerKey:       cfg.Security.PeerKey,
+		AllowedKeys:   cfg.Security.AllowKeys,
+		RekeyInterval: time.Duration(cfg.Security.Rekey),
+		RekeyBytes:    cfg.Security.RekeyBytes,
+		Obfs:          cfg.Security.Obfs,
+		ObfsKey:       cfg.Security.ObfsKey,
+		ObfsPad:       cfg.Security.ObfsPad,
+	}
+}
+
+// 生成客户端配置（需要先通过检查）
+func (cfg *Config) client_config() client.Config {
+	links := make([]client.LinkConfig, len(cfg.Links))
+	for index, link := range cfg.Links {
+		links[index] = client.LinkConfig{
+			Name:   link.Name,
+			Local:  link.Local,
+			Remote: link.Remote,
+			Family: link.Family,
+			Weight: link.Weight,
+			Cost:   link.Cost,
+			MTU:    link.MTU,
+		}
+	}
+
+	return client.Config{
+		Links:           links,
+		Listen:          cfg.Tunnels[0].Listen,
+		Server:          cfg.Server,
+		MTU:             cfg.MTU,
+		Mode:            cfg.Mode,
+		DownMode:        cfg.DownMode,
+		Security:        cfg.security(),
+		Discover:        cfg.Discover,
+		LinkCheck:       time.Duration(cfg.LinkCheck),
+		ResolveInterval: time.Duration(cfg.ResolveInterval),
+		Hop:             time.Duration(cfg.Hop),
+		Keepalive:       time.Duration(cfg.Keepalive),
+		StatsInterval:   time.Duration(cfg.Observability.StatsInterval),
+	}
+}
+
+// 生成服务端配置（需要先通过检查）
+func (cfg *Config) server_config() server.Config {
+	result := server.Config{
+		Forward:         cfg.Tunnels[0].Forward,
+		ForwardFamily:   cfg.Tunnels[0].ForwardFamily,
+		MTU:             cfg.MTU,
+		Mode:            cfg.Mode,
+		Security:        cfg.security(),
+		UnvalidatedPPS:  cfg.Admission.UnvalidatedPPS,
+		ResolveInterval: time.Duration(cfg.ResolveInterval),
+		LinkTimeout:     time.Duration(cfg.LinkTimeout),
+		StatsInterval:   time.Duration(cfg.Observability.StatsInterval),
+		Admission: server.AdmissionConfig{
+			AllowCIDRs: cfg.Admission.AllowCIDR,
+			RatePPS:    cfg.Admission.RatePPS,
+			RateBPS:    cfg.Admission.RateBPS,
+		},
+	}
+
+	for _, link := range cfg.Links {
+		result.Listen = append(result.Listen, link.Listen)
+		result.Admission.LinkAllowCIDRs = append(result.Admission.LinkAllowCIDRs, link.AllowCIDR)
+		if len(link.Advertise) > 0 {
+			result.Advertise = append(result.Advertise, link.Advertise)
+		}
+	}
+	return result
+}
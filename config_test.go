@@ -0,0 +1,168 @@
+package main
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 把配置写入临时文件并读取
+func load_test_config(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return load_config(path)
+}
+
+func TestValidate(t *testing.T) {
+	client_key, _, _ := core.GenerateKeyPair()
+	server_key, server_pub, _ := core.GenerateKeyPair()
+
+	const client_base = `"role": "client", "tunnels": [{"listen": "127.0.0.1:8000"}]`
+	const server_base = `"role": "server", "tunnels": [{"forward": "127.0.0.1:51820"}], "links": [{"listen": "0.0.0.0:9000"}]`
+
+	tests := []struct {
+		name    string
+		content string
+		// 错误信息中应包含的内容，为空表示检查通过
+		errs []string
+	}{
+		{
+			name:    "客户端",
+			content: `{` + client_base + `, "links": [{"remote": "203.0.113.1:9000"}], "security": {"psk": "abc", "cipher": "chacha20-poly1305"}}`,
+		},
+		{
+			name:    "服务端",
+			content: `{` + server_base + `, "security": {"psk": "abc"}}`,
+		},
+		{
+			name:    "握手客户端",
+			content: fmt.Sprintf(`{%s, "server": "203.0.113.1:9000", "security": {"key": %q, "peer_key": %q}}`, client_base, client_key, server_pub),
+		},
+		{
+			name:    "只配置自动发现",
+			content: `{` + client_base + `, "discover": ["eth*"]}`,
+			errs:    []string{"links: 至少需要一条带远程地址的链路（或配置 server）"},
+		},
+		{
+			name:    "加密没有密钥",
+			content: `{` + client_base + `, "links": [{"remote": "203.0.113.1:9000"}], "security": {"cipher": "chacha20-poly1305"}}`,
+			errs:    []string{"security: 加密算法 chacha20-poly1305 需要同时指定预共享密钥或握手密钥"},
+		},
+		{
+			name:    "私钥格式错误",
+			content: `{` + client_base + `, "server": "203.0.113.1:9000", "security": {"key": "not-base64!", "peer_key": "` + server_pub + `"}}`,
+			errs:    []string{"security: 解析本端私钥失败"},
+		},
+		{
+			name:    "服务端公钥格式错误",
+			content: fmt.Sprintf(`{%s, "server": "203.0.113.1:9000", "security": {"key": %q, "peer_key": "abc"}}`, client_base, client_key),
+			errs:    []string{"security: 解析服务端公钥失败"},
+		},
+		{
+			name:    "握手服务端没有公钥白名单",
+			content: fmt.Sprintf(`{%s, "security": {"key": %q}}`, server_base, server_key),
+			errs:    []string{"security: 启用握手的服务端至少需要一个允许接入的客户端公钥"},
+		},
+		{
+			name:    "客户端公钥格式错误",
+			content: fmt.Sprintf(`{%s, "security": {"key": %q, "allow_keys": ["zz"]}}`, server_base, server_key),
+			errs:    []string{"security: 解析客户端公钥 zz 失败"},
+		},
+		{
+			name:    "多项错误",
+			content: `{` + client_base + `, "mtu": 100, "mode": "mode3", "links": [{"remote": "203.0.113.1", "weight": 101}], "security": {"cipher": "rc4", "obfs": ["xor"]}}`,
+			errs: []string{
+				"mode: 应为 mode1 或 mode2，当前为 \"mode3\"",
+				"mtu: 应在 576-65507 之间，当前为 100",
+				"security.cipher: 不支持的加密算法 \"rc4\"",
+				"security.obfs[0]: 不支持的混淆方式 \"xor\"",
+				"links[0].remote:",
+				"links[0].weight: 应在 0-100 之间（0按1处理），当前为 101",
+			},
+		},
+		{
+			name:    "配置了server时指定远程地址",
+			content: `{` + client_base + `, "server": "203.0.113.1:9000", "links": [{"name": "wan1", "remote": "203.0.113.1:9001"}]}`,
+			errs:    []string{"links[0]（wan1）.remote: 配置了 server 时远程地址由服务端下发，不能再指定"},
+		},
+		{
+			name:    "多实例",
+			content: `{"instances": [{"name": "a", ` + server_base + `}, {"name": "a", ` + client_base + `}]}`,
+			errs: []string{
+				"instances[1].name: 与 instances[0] 重名",
+				"instances[1].links: 至少需要一条带远程地址的链路（或配置 server）",
+			},
+		},
+		{
+			name:    "多实例通过检查",
+			content: `{"observability": {"admin": "127.0.0.1:7000"}, "instances": [{"name": "a", ` + server_base + `}, {"name": "b", ` + client_base + `, "server": "203.0.113.1:9000"}]}`,
+		},
+		{
+			name:    "嵌套实例",
+			content: `{"instances": [{"name": "a", "instances": [{"name": "b", ` + server_base + `}]}]}`,
+			errs:    []string{"instances[0].instances: 实例中不能再配置实例"},
+		},
+		{
+			name:    "多实例的顶层配置",
+			content: `{"role": "client", "observability": {"admin": "127.0.0.1:7000"}, "instances": [{` + server_base + `, "observability": {"admin": "127.0.0.1:7001"}}]}`,
+			errs: []string{
+				"instances: 配置了 instances 时 role、server、tunnels、links、discover 需要写在各个实例中",
+				"instances[0].name: 多实例配置中每个实例都需要名称",
+				"instances[0].observability.admin: 管理接口由全部实例共用，只能写在顶层",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load_test_config(t, tt.content)
+			if err != nil {
+				t.Fatalf("读取配置失败: %v", err)
+			}
+
+			err = cfg.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("检查失败: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("检查通过，应返回错误")
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("错误信息中没有 %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"未知的配置项", `{"role": "client", "listen": "127.0.0.1:8000"}`, `未知的配置项 "listen"`},
+		{"类型错误", "{\n  \"role\": \"client\",\n  \"mtu\": \"1400\"\n}", "第 3 行第 16 列: mtu 的类型应为 int"},
+		{"间隔格式错误", `{"keepalive": "10 seconds"}`, "10 seconds"},
+		{"语法错误", "{\n  \"role\": \"client\",\n}", "第 3 行"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load_test_config(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("读取错误 %v，应包含 %q", err, tt.err)
+			}
+		})
+	}
+}
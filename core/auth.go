@@ -0,0 +1,289 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// 认证标签长度（HMAC-SHA256截断到16字节，与AEAD标签长度一致）
+const AuthTagLen = 16
+
+// 支持的加密算法
+const (
+	CipherNone     = "none"
+	CipherChaCha20 = "chacha20-poly1305"
+	CipherAESGCM   = "aes-256-gcm"
+)
+
+// sessionKeys 一个会话的收发密钥
+type sessionKeys struct {
+	// 会话标识，发送时写入帧头
+	id uint32
+
+	// 两个方向的HMAC密钥
+	sendAuthKey []byte
+	recvAuthKey []byte
+
+	// 两个方向只认证不加密的控制帧使用的HMAC密钥，与数据帧的密钥分开派生
+	sendControlKey []byte
+	recvControlKey []byte
+
+	// 两个方向的AEAD，未启用加密时为空
+	sendAEAD cipher.AEAD
+	recvAEAD cipher.AEAD
+
+	// 发送帧计数器（每个会话从0开始）与发送字节数（原子操作）
+	counter   uint64
+	sentBytes uint64
+
+	// 接收方向的抗重放窗口
+	replay replayWindow
+
+	// 会话建立时间
+	created time.Time
+}
+
+// setupAuth 根据预共享密钥与加密算法初始化帧认证/加密
+// psk为空且未启用握手时关闭认证，加密必须配合预共享密钥或握手使用
+// 启用握手时会话密钥由握手协商，需要先调用setupHandshake
+// isServer用于区分两个方向的密钥，防止对端的帧被反射回去
+func (e *Engine) setupAuth(psk string, cipherName string, isServer bool) error {
+	if len(cipherName) == 0 {
+		cipherName = CipherNone
+	}
+
+	if !CipherSupported(cipherName) {
+		return fmt.Errorf("不支持的加密算法: %s", cipherName)
+	}
+
+	e.cipherSuite = cipherName
+	e.serverRole = isServer
+	e.presharedKey = psk
+	e.authEnabled = len(psk) > 0 || e.HandshakeEnabled()
+
+	if !e.authEnabled {
+		if cipherName != CipherNone {
+			return fmt.Errorf("加密算法 %s 需要同时指定预共享密钥或握手密钥", cipherName)
+		}
+		return nil
+	}
+
+	// 握手模式下等待握手完成后再安装会话
+	if e.HandshakeEnabled() {
+		return nil
+	}
+
+	// 发送使用启动时随机生成的本端会话，接收的对端会话在收到对端的帧后建立
+	keys, err := e.pskSessionKeys(e.localSession)
+	if err != nil {
+		return err
+	}
+	e.currentKeys.Store(keys)
+
+	return nil
+}
+
+// pskSessionKeys 预共享密钥模式下按发送方的会话标识派生会话密钥
+// 每次启动的会话标识不同，密钥也不同，计数器从0开始时nonce也不会与之前的会话重复
+func (e *Engine) pskSessionKeys(id uint32) (*sessionKeys, error) {
+	psk := []byte(e.presharedKey)
+	suffix := string(binary.BigEndian.AppendUint32(nil, id))
+	return e.newSessionKeys(id, func(info string) []byte {
+		return deriveKey(psk, nil, info+suffix)
+	})
+}
+
+// deriveKey 使用HKDF派生出32字节的密钥
+func deriveKey(secret []byte, salt []byte, info string) []byte {
+	if salt == nil {
+		salt = []byte("UDPRainbowBridge")
+	}
+
+	key := make([]byte, 32)
+	reader := hkdf.New(sha256.New, secret, salt, []byte(info))
+	io.ReadFull(reader, key)
+	return key
+}
+
+// newSessionKeys 创建会话密钥，derive按用途派生密钥：两个方向的数据帧密钥，以及只认证不加密的控制帧密钥
+func (e *Engine) newSessionKeys(id uint32, derive func(info string) []byte) (*sessionKeys, error) {
+	// 计数器从0开始，只在同一会话内比较新旧，与时钟无关
+	keys := &sessionKeys{
+		id:      id,
+		created: time.Now(),
+	}
+
+	c2s, s2c := derive("client->server"), derive("server->client")
+	c2sControl, s2cControl := derive("client->server plain-control"), derive("server->client plain-control")
+	if e.serverRole {
+		keys.sendAuthKey, keys.recvAuthKey = s2c, c2s
+		keys.sendControlKey, keys.recvControlKey = s2cControl, c2sControl
+	} else {
+		keys.sendAuthKey, keys.recvAuthKey = c2s, s2c
+		keys.sendControlKey, keys.recvControlKey = c2sControl, s2cControl
+	}
+
+	if e.cipherSuite == CipherNone {
+		return keys, nil
+	}
+
+	var err error
+	if keys.sendAEAD, err = newAEAD(e.cipherSuite, keys.sendAuthKey); err != nil {
+		return nil, err
+	}
+	if keys.recvAEAD, err = newAEAD(e.cipherSuite, keys.recvAuthKey); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// newAEAD 按算法名称创建AEAD
+func newAEAD(cipherName string, key []byte) (cipher.AEAD, error) {
+	if cipherName == CipherChaCha20 {
+		return chacha20poly1305.New(key)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// installSession 安装新协商出的会话
+// confirmed为false时（服务端换钥）先放入待确认位置，收到对端新会话的帧后再切换
+func (e *Engine) installSession(keys *sessionKeys, confirmed bool) {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	if !confirmed && e.currentKeys.Load() != nil {
+		e.pendingKeys.Store(keys)
+		return
+	}
+
+	e.previousKeys.Store(e.currentKeys.Load())
+	e.currentKeys.Store(keys)
+	e.pendingKeys.Store(nil)
+}
+
+// 预共享密钥模式下记录的已被替换的对端会话数量
+const maxRetiredSessions = 64
+
+// confirmSession 收到对端使用新会话的帧（已通过认证）后切换到该会话，返回之后使用的接收密钥，会话已失效时返回nil
+// 握手模式下把待确认会话切换为当前会话；预共享密钥模式下替换对端会话（对端重启），原来的会话不再接受，
+// 因此预共享密钥模式下每个服务端只支持一个客户端，同一密钥的多个客户端会互相顶替
+func (e *Engine) confirmSession(keys *sessionKeys) *sessionKeys {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	if !e.HandshakeEnabled() {
+		peer := e.peerKeys.Load()
+		if peer != nil && peer.id == keys.id {
+			// 其它链路上的帧已经切换过，使用同一个抗重放窗口
+			return peer
+		}
+		if slices.Contains(e.retiredSessions, keys.id) {
+			return nil
+		}
+		if peer != nil {
+			e.retiredSessions = append(e.retiredSessions, peer.id)
+			if len(e.retiredSessions) > maxRetiredSessions {
+				e.retiredSessions = e.retiredSessions[1:]
+			}
+		}
+		e.peerKeys.Store(keys)
+		return keys
+	}
+
+	if e.pendingKeys.Load() == keys {
+		e.previousKeys.Store(e.currentKeys.Load())
+		e.currentKeys.Store(keys)
+		e.pendingKeys.Store(nil)
+	}
+	return keys
+}
+
+// lookupRecvKeys 根据帧头中的会话标识查找接收密钥，返回的bool表示需要在认证通过后调用confirmSession
+// 预共享密钥模式下两端会话标识各自独立，不是当前对端会话时按该会话标识派生密钥（对端可能已重启）
+func (e *Engine) lookupRecvKeys(id uint32) (*sessionKeys, bool) {
+	if !e.HandshakeEnabled() {
+		if peer := e.peerKeys.Load(); peer != nil && peer.id == id {
+			return peer, false
+		}
+		keys, err := e.pskSessionKeys(id)
+		if err != nil {
+			return nil, false
+		}
+		return keys, true
+	}
+
+	current := e.currentKeys.Load()
+	if current != nil && current.id == id {
+		return current, false
+	}
+	if previous := e.previousKeys.Load(); previous != nil && previous.id == id {
+		return previous, false
+	}
+	if pending := e.pendingKeys.Load(); pending != nil && pending.id == id {
+		return pending, true
+	}
+
+	return nil, false
+}
+
+// AuthEnabled 判断是否启用了帧认证
+func (e *Engine) AuthEnabled() bool {
+	return e.authEnabled
+}
+
+// EncryptEnabled 判断是否启用了负载加密
+func (e *Engine) EncryptEnabled() bool {
+	return e.authEnabled && e.cipherSuite != CipherNone
+}
+
+// CipherSupported 判断是否支持该负载加密算法
+func CipherSupported(name string) bool {
+	return name == CipherNone || name == CipherChaCha20 || name == CipherAESGCM
+}
+
+// CipherName 返回当前使用的负载加密算法，未启用加密时为none
+func (e *Engine) CipherName() string {
+	if !e.EncryptEnabled() {
+		return CipherNone
+	}
+	return e.cipherSuite
+}
+
+// SessionEstablished 判断是否已有可用于发送的会话
+func (e *Engine) SessionEstablished() bool {
+	return !e.authEnabled || e.currentKeys.Load() != nil
+}
+
+// signTag 计算认证标签
+func signTag(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:AuthTagLen]
+}
+
+// authFailed 记录一次认证失败
+func (e *Engine) authFailed() {
+	atomic.AddUint64(&e.authFailCount, 1)
+}
+
+// AuthFailCount 返回启动以来认证失败的帧总数
+func (e *Engine) AuthFailCount() uint64 {
+	return atomic.LoadUint64(&e.authFailCount)
+}
@@ -0,0 +1,111 @@
+package core
+
+// 控制帧
+//
+// 数据帧以4个十六进制ASCII字符的序列号开头，首字节不可能为0xFF，
+// 因此控制帧以0xFF开头，第二个字节为控制帧类型，后面是各类型自己的内容。
+const (
+	// 控制帧首字节
+	ControlMagic byte = 0xFF
+
+	// 控制帧头长度
+	controlHeaderLen = 2
+)
+
+// 控制帧类型
+const (
+	// 握手发起（客户端 -> 服务端）
+	ControlHandshakeInit byte = 1
+
+	// 握手响应（服务端 -> 客户端）
+	ControlHandshakeResp byte = 2
+
+	// 地址验证质询（服务端 -> 客户端）
+	ControlCookieChallenge byte = 3
+
+	// 地址验证应答（客户端 -> 服务端）
+	ControlCookieEcho byte = 4
+
+	// 链路保活（客户端 -> 服务端）
+	ControlKeepalive byte = 5
+
+	// 参数请求（客户端 -> 服务端）
+	ControlConfigRequest byte = 6
+
+	// 参数下发（服务端 -> 客户端）
+	ControlConfig byte = 7
+
+	// 会话关闭（双向），发送方平滑停止前通知对端
+	ControlClose byte = 8
+
+	// 要求重新握手（服务端 -> 客户端），服务端不认识帧的会话标识时回复（例如服务端重启后）
+	ControlRekey byte = 9
+)
+
+// 带认证的控制帧内层数据帧使用的序列号，控制帧不参与去重
+const controlSeq = "0000"
+
+// IsControlFrame 判断收到的包是否为控制帧
+func IsControlFrame(buf []byte) bool {
+	return len(buf) >= controlHeaderLen && buf[0] == ControlMagic
+}
+
+// ControlType 返回控制帧类型，调用前需先用IsControlFrame判断
+func ControlType(buf []byte) byte {
+	return buf[1]
+}
+
+// 带认证的控制帧：控制帧头 | 数据帧（按配置认证或加密，负载为控制帧内容）
+// 与数据帧一样经过认证，接收方可以据此安全地更新地址；会话尚未建立时返回nil
+func (e *Engine) sealControl(kind byte, payload []byte) []byte {
+	frame := e.EncodeFrame(controlSeq, payload)
+	if frame == nil {
+		return nil
+	}
+	return append([]byte{ControlMagic, kind}, frame...)
+}
+
+// 校验带认证的控制帧，返回内层的帧（计数器用于判断是否为更新的帧）
+func (e *Engine) openControl(buf []byte) (Frame, bool) {
+	return e.DecodeFrame(buf[controlHeaderLen:])
+}
+
+// 只认证不加密的控制帧：HMAC密钥与加密算法无关（由预共享密钥或握手单独派生的控制帧密钥），两端加密算法不一致时仍然可以互相读出，
+// 用于交换能力（参数请求与下发），不一致时可以给出拒绝原因，而不是只留下认证失败计数
+func (e *Engine) sealPlainControl(kind byte, payload []byte) []byte {
+	frame := e.encodeFrame(controlSeq, payload, false)
+	if frame == nil {
+		return nil
+	}
+	return append([]byte{ControlMagic, kind}, frame...)
+}
+
+// 校验只认证不加密的控制帧
+func (e *Engine) openPlainControl(buf []byte) (Frame, bool) {
+	return e.decodeFrame(buf[controlHeaderLen:], false)
+}
+
+// NewKeepalive 生成保活帧（负载为空的带认证控制帧）
+// 未启用认证时用填充补到地址验证质询的长度：服务端只在收到不短于质询的包时发送质询，空闲的链路靠保活帧通过验证
+func (e *Engine) NewKeepalive() []byte {
+	var padding []byte
+	if !e.AuthEnabled() {
+		padding = make([]byte, cookieLen-controlHeaderLen-SeqLen)
+	}
+	return e.sealControl(ControlKeepalive, padding)
+}
+
+// OpenKeepalive 服务端校验保活帧
+func (e *Engine) OpenKeepalive(buf []byte) (Frame, bool) {
+	return e.openControl(buf)
+}
+
+// NewClose 生成会话关闭帧（负载为空的带认证控制帧）
+func (e *Engine) NewClose() []byte {
+	return e.sealControl(ControlClose, nil)
+}
+
+// OpenClose 校验会话关闭帧
+func (e *Engine) OpenClose(buf []byte) (Frame, bool) {
+	return e.openControl(buf)
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"time"
+)
+
+// 地址验证（return routability）
+//
+//	服务端 -> 新地址 质询：头(2) | 时间戳(8) | 校验(16)
+//	客户端 -> 服务端 应答：头(2) | 时间戳(8) | 校验(16)（原样返回）
+//
+// 校验 = HMAC(服务端随机密钥, 地址 | 时间戳)，服务端无需为每次质询保存状态；
+// 只有真正能在该地址收包的客户端才能返回正确的应答，伪造源地址无法通过验证。
+const (
+	cookieLen = controlHeaderLen + 8 + AuthTagLen
+
+	// 应答的有效期
+	cookieLifetime = 30 * time.Second
+)
+
+// cookieTag 计算地址与时间戳的校验
+func (e *Engine) cookieTag(addr string, timestamp []byte) []byte {
+	return signTag(e.cookieSecret, append([]byte(addr), timestamp...))
+}
+
+// NewCookieChallenge 生成发往addr的地址验证质询
+func (e *Engine) NewCookieChallenge(addr string) []byte {
+	msg := make([]byte, 0, cookieLen)
+	msg = append(msg, ControlMagic, ControlCookieChallenge)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(time.Now().UnixNano()))
+	return append(msg, e.cookieTag(addr, msg[controlHeaderLen:])...)
+}
+
+// CookieEcho 客户端根据收到的质询生成应答，质询格式错误时返回nil
+func CookieEcho(challenge []byte) []byte {
+	if len(challenge) != cookieLen {
+		return nil
+	}
+
+	echo := append([]byte(nil), challenge...)
+	echo[1] = ControlCookieEcho
+	return echo
+}
+
+// VerifyCookieEcho 服务端校验从addr收到的应答
+func (e *Engine) VerifyCookieEcho(echo []byte, addr string) bool {
+	if len(echo) != cookieLen {
+		return false
+	}
+
+	timestamp := echo[controlHeaderLen : controlHeaderLen+8]
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(timestamp)))
+	if time.Since(issued) > cookieLifetime || time.Until(issued) > 0 {
+		return false
+	}
+
+	return hmac.Equal(e.cookieTag(addr, timestamp), echo[controlHeaderLen+8:])
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// 生成指定时间签发的质询
+func challengeAt(e *Engine, addr string, issued time.Time) []byte {
+	msg := []byte{ControlMagic, ControlCookieChallenge}
+	msg = binary.BigEndian.AppendUint64(msg, uint64(issued.UnixNano()))
+	return append(msg, e.cookieTag(addr, msg[controlHeaderLen:])...)
+}
+
+func TestVerifyCookieEcho(t *testing.T) {
+	const addr = "198.51.100.7:40000"
+	server, _ := NewEngine(SecurityConfig{}, true)
+	other, _ := NewEngine(SecurityConfig{}, true)
+
+	tests := []struct {
+		name string
+		echo []byte
+		addr string
+		want bool
+	}{
+		{"正确的应答", CookieEcho(server.NewCookieChallenge(addr)), addr, true},
+		{"有效期内", CookieEcho(challengeAt(server, addr, time.Now().Add(-cookieLifetime+time.Second))), addr, true},
+		{"过期", CookieEcho(challengeAt(server, addr, time.Now().Add(-cookieLifetime-time.Second))), addr, false},
+		{"时间戳在未来", CookieEcho(challengeAt(server, addr, time.Now().Add(time.Minute))), addr, false},
+		{"来自其它地址", CookieEcho(server.NewCookieChallenge(addr)), "198.51.100.7:40001", false},
+		{"其它服务端签发", CookieEcho(other.NewCookieChallenge(addr)), addr, false},
+		{"篡改时间戳", tamper(CookieEcho(server.NewCookieChallenge(addr)), controlHeaderLen+7), addr, false},
+		{"篡改校验", tamper(CookieEcho(server.NewCookieChallenge(addr)), cookieLen-1), addr, false},
+		{"长度错误", CookieEcho(server.NewCookieChallenge(addr))[:cookieLen-1], addr, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := server.VerifyCookieEcho(tt.echo, tt.addr); got != tt.want {
+				t.Fatalf("VerifyCookieEcho = %v，应为 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 翻转指定位置的一位
+func tamper(packet []byte, index int) []byte {
+	packet[index] ^= 0x01
+	return packet
+}
+
+func TestCookieEchoFormat(t *testing.T) {
+	if echo := CookieEcho(make([]byte, cookieLen-1)); echo != nil {
+		t.Fatalf("格式错误的质询生成了应答 %x", echo)
+	}
+
+	challenge := (&Engine{cookieSecret: []byte("secret")}).NewCookieChallenge("127.0.0.1:1")
+	echo := CookieEcho(challenge)
+	if ControlType(echo) != ControlCookieEcho || ControlType(challenge) != ControlCookieChallenge {
+		t.Fatalf("应答类型 %d，应为 %d，质询不应被修改", ControlType(echo), ControlCookieEcho)
+	}
+}
+
+func TestKeepaliveCoversChallenge(t *testing.T) {
+	// 服务端只回复不短于质询的包，空闲链路上的保活帧需要足够长才能通过地址验证
+	for _, tt := range frameConfigs {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newEnginePair(t, tt.cfg)
+			keepalive := client.NewKeepalive()
+			if len(keepalive) < cookieLen {
+				t.Fatalf("保活帧长度 %d，短于质询长度 %d", len(keepalive), cookieLen)
+			}
+			if _, ok := server.OpenKeepalive(keepalive); !ok {
+				t.Fatal("服务端无法校验保活帧")
+			}
+		})
+	}
+}
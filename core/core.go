@@ -7,28 +7,26 @@ import (
 )
 
 // 定义套接字结构体，包含一个udp套接字与套接字的地址
+// 端口跳变时一条链路有一组套接字（Sockets），Socket为对端最近使用的那个
 type RecordSocket struct {
-	Socket *net.UDPConn
-	Addr   string
+	Socket  *net.UDPConn
+	Addr    string
+	Sockets []*net.UDPConn
 }
 
-// 全局变量存储标识符及其过期时间
-var (
-	indices           = make(map[string]time.Time)
-	expiration        = 5 * time.Second
-	counter    uint16 = 0
-)
+// 标识符的过期时间
+const expiration = 5 * time.Second
 
 // RecordIndex 记录接收到的包的唯一标识符
-func RecordIndex(index string) {
+func (e *Engine) RecordIndex(index string) {
 	// 记录该Index，并设置过期时间
-	indices[index] = time.Now().Add(expiration)
+	e.indices[index] = time.Now().Add(expiration)
 }
 
 // IndexIsValid 判断接收到的包的唯一标识符是否有效
 // 返回 true 表示有效（可以处理），false 表示无效（重复包）
-func IndexIsValid(index string) bool {
-	expireTime, exists := indices[index]
+func (e *Engine) IndexIsValid(index string) bool {
+	expireTime, exists := e.indices[index]
 	if exists {
 		if time.Now().Before(expireTime) {
 			// fmt.Printf("标识符无效: %s, %v\n", index, exists)
@@ -36,7 +34,7 @@ func IndexIsValid(index string) bool {
 			return false
 		}
 		// 已过期，删除该标识符
-		delete(indices, index)
+		delete(e.indices, index)
 	}
 	// fmt.Printf("有效: %s, %v\n", index, exists)
 	// 不存在或已过期，标识符有效
@@ -44,12 +42,12 @@ func IndexIsValid(index string) bool {
 }
 
 // GetIndex 生成一个新的唯一标识符
-func GetIndex() string {
+func (e *Engine) GetIndex() string {
 	// 将计数器转换为2字节
-	indexBytes := uint16ToBytes(counter)
+	indexBytes := uint16ToBytes(e.counter)
 
 	// 递增计数器
-	counter++
+	e.counter++
 
 	index := hex.EncodeToString(indexBytes)
 
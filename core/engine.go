@@ -0,0 +1,101 @@
+package core
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Engine 一个桥接实例的协议状态：去重、帧认证/加密、握手、地址验证与混淆
+// 同一进程内的多个实例各自持有一个Engine，互不影响
+type Engine struct {
+	// 接收到的包的唯一标识符及其过期时间
+	indices map[string]time.Time
+
+	// 发送序列号计数器
+	counter uint16
+
+	// 是否启用帧认证
+	authEnabled bool
+
+	// 当前使用的加密算法
+	cipherSuite string
+
+	// 本端是否为服务端，用于区分两个方向的密钥
+	serverRole bool
+
+	// 预共享密钥，握手模式下混入密钥派生
+	presharedKey string
+
+	// 本端会话标识，创建时随机生成，预共享密钥模式下使用
+	localSession uint32
+
+	// 预共享密钥模式下对端当前的会话与已被替换的会话（对端重启后旧会话不再接受，会话切换锁保护）
+	peerKeys        atomic.Pointer[sessionKeys]
+	retiredSessions []uint32
+
+	// 当前会话、上一个会话（换钥过渡期内仍可接收）、待确认会话（服务端换钥后尚未收到对端新会话的帧）
+	currentKeys  atomic.Pointer[sessionKeys]
+	previousKeys atomic.Pointer[sessionKeys]
+	pendingKeys  atomic.Pointer[sessionKeys]
+
+	// 会话切换锁
+	sessionMutex sync.Mutex
+
+	// 认证失败的帧数量
+	authFailCount uint64
+
+	// 是否启用握手
+	handshakeEnabled bool
+
+	// 本端静态私钥
+	staticKey *ecdh.PrivateKey
+
+	// 服务端静态公钥（客户端使用）
+	peerKey *ecdh.PublicKey
+
+	// 允许接入的客户端公钥（服务端使用）
+	allowedKeys map[string]bool
+
+	// 握手状态锁
+	handshakeMutex sync.Mutex
+
+	// 客户端正在进行的握手
+	pendingHandshake *clientHandshake
+
+	// 客户端是否需要立即重新握手（服务端关闭会话后设置）
+	rekeyRequested atomic.Bool
+
+	// 服务端最近处理过的握手发起消息的校验标签，重放的发起消息被拒绝
+	recentInitTags []string
+
+	// 服务端最近一次处理的握手发起消息与响应，多条链路上重复收到同一发起消息时直接重发响应
+	lastInitMessage []byte
+	lastRespMessage []byte
+
+	// 服务端生成质询使用的随机密钥
+	cookieSecret []byte
+
+	// 启用的混淆方式，第一个用于客户端发送
+	obfuscators []Obfuscator
+}
+
+// NewEngine 按安全配置创建协议状态，isServer用于区分两个方向的密钥
+func NewEngine(cfg SecurityConfig, isServer bool) (*Engine, error) {
+	e := &Engine{
+		indices:      make(map[string]time.Time),
+		cipherSuite:  CipherNone,
+		localSession: randomUint32(),
+		cookieSecret: make([]byte, 32),
+		obfuscators:  []Obfuscator{plainObfuscator{}},
+	}
+
+	rand.Read(e.cookieSecret)
+
+	if err := e.setupSecurity(cfg, isServer); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// 帧格式
+//
+//	明文模式：序列号(4) | 负载
+//	认证模式：序列号(4) | 会话(4) | 计数器(8) | 负载 | HMAC标签(16)
+//	加密模式：序列号(4) | 会话(4) | 计数器(8) | 密文 | AEAD标签(16)
+//
+// 认证/加密模式下帧头整体参与认证，序列号与会话字段无法被篡改。
+// AEAD的nonce由会话(4)与计数器(8)拼接而成，计数器每帧递增，保证同一密钥下nonce不重复。
+// 握手模式下会话字段为握手协商出的会话标识，接收方据此选择密钥；
+// 预共享密钥模式下会话字段为发送方启动时随机生成的会话标识，收发密钥按它派生，对端重启后切换到新会话。
+// 计数器在每个会话内从1开始递增，只在同一会话内比较新旧，不依赖两端的时钟。
+// 参数请求与下发即使启用了加密也只用HMAC标签认证（负载为明文），两端加密算法不一致时仍然可以交换能力；
+// 它们的HMAC密钥以"plain-control"单独派生，与未加密的数据帧不能互相替换。
+// 接收方按会话记录一个抗重放窗口，每个计数器只接受一次，因此多倍发包时每条链路上的副本需要分别编码。
+const (
+	// 序列号长度
+	SeqLen = 4
+
+	// 认证/加密模式下的帧头长度
+	secureHeaderLen = SeqLen + 4 + 8
+)
+
+// Frame 解码后的帧
+type Frame struct {
+	// 去重用的序列号
+	Seq string
+
+	// 发送方会话标识（明文模式下为0）
+	Session uint32
+
+	// 发送方帧计数器（明文模式下为0）
+	Counter uint64
+
+	// 负载数据
+	Payload []byte
+}
+
+// FrameMark 接收方记录的某个帧的位置（会话与计数器），用于判断之后收到的帧是否更新
+type FrameMark struct {
+	Session uint32
+	Counter uint64
+}
+
+// Mark 返回帧的位置
+func (f Frame) Mark() FrameMark {
+	return FrameMark{Session: f.Session, Counter: f.Counter}
+}
+
+// Newer 判断通过认证的帧是否比mark记录的帧更新，接收方据此决定是否按它更新客户端地址等状态
+// 同一会话内计数器更大的帧更新；会话不同时只有当前会话（最近建立的会话）的帧更新，
+// 重放的旧会话的帧、换钥过渡期内上一个会话的帧都不能覆盖新会话带来的状态；未启用认证时总是返回true
+func (e *Engine) Newer(mark FrameMark, frame Frame) bool {
+	if !e.AuthEnabled() {
+		return true
+	}
+	if frame.Session == mark.Session {
+		return frame.Counter > mark.Counter
+	}
+
+	current := e.currentKeys.Load()
+	if !e.HandshakeEnabled() {
+		current = e.peerKeys.Load()
+	}
+	return current != nil && current.id == frame.Session
+}
+
+// FrameOverhead 返回每个帧在负载之外额外占用的字节数
+func (e *Engine) FrameOverhead() int {
+	if e.AuthEnabled() {
+		return secureHeaderLen + AuthTagLen
+	}
+	return SeqLen
+}
+
+// EncodeFrame 将序列号与负载编码为一个待发送的帧
+// 启用认证但会话尚未建立（握手未完成）时返回nil，调用方应丢弃该包
+func (e *Engine) EncodeFrame(seq string, payload []byte) []byte {
+	return e.encodeFrame(seq, payload, true)
+}
+
+// encodeFrame 编码帧，encrypt为false时即使启用了加密也只做HMAC认证（负载为明文），
+// 使用单独的控制帧密钥，只认证的控制帧与数据帧不能互相替换
+func (e *Engine) encodeFrame(seq string, payload []byte, encrypt bool) []byte {
+	if !e.AuthEnabled() {
+		return append([]byte(seq), payload...)
+	}
+
+	keys := e.currentKeys.Load()
+	if keys == nil {
+		return nil
+	}
+
+	atomic.AddUint64(&keys.sentBytes, uint64(len(payload)))
+
+	frame := make([]byte, secureHeaderLen, secureHeaderLen+len(payload)+AuthTagLen)
+	copy(frame, seq)
+	binary.BigEndian.PutUint32(frame[SeqLen:], keys.id)
+	binary.BigEndian.PutUint64(frame[SeqLen+4:], atomic.AddUint64(&keys.counter, 1))
+
+	if encrypt && keys.sendAEAD != nil {
+		// 帧头作为附加数据参与认证
+		return keys.sendAEAD.Seal(frame, frame[SeqLen:secureHeaderLen], payload, frame[:secureHeaderLen])
+	}
+
+	authKey := keys.sendAuthKey
+	if !encrypt {
+		authKey = keys.sendControlKey
+	}
+	frame = append(frame, payload...)
+	return append(frame, signTag(authKey, frame)...)
+}
+
+// DecodeFrame 校验并解码收到的帧
+// 校验失败返回false，认证失败时会增加失败计数；返回的负载可能引用buf的内存
+func (e *Engine) DecodeFrame(buf []byte) (Frame, bool) {
+	return e.decodeFrame(buf, true)
+}
+
+// decodeFrame 校验并解码帧，encrypt为false时按只做HMAC认证的帧校验
+func (e *Engine) decodeFrame(buf []byte, encrypt bool) (Frame, bool) {
+	if !e.AuthEnabled() {
+		if len(buf) < SeqLen {
+			return Frame{}, false
+		}
+		return Frame{Seq: string(buf[:SeqLen]), Payload: buf[SeqLen:]}, true
+	}
+
+	if len(buf) < secureHeaderLen+AuthTagLen {
+		e.authFailed()
+		return Frame{}, false
+	}
+
+	header := buf[:secureHeaderLen]
+	frame := Frame{
+		Seq:     string(header[:SeqLen]),
+		Session: binary.BigEndian.Uint32(header[SeqLen:]),
+		Counter: binary.BigEndian.Uint64(header[SeqLen+4:]),
+	}
+
+	keys, pending := e.lookupRecvKeys(frame.Session)
+	if keys == nil {
+		e.authFailed()
+		return Frame{}, false
+	}
+
+	if encrypt && keys.recvAEAD != nil {
+		// 原地解密
+		body := buf[secureHeaderLen:]
+		plain, err := keys.recvAEAD.Open(body[:0], header[SeqLen:], body, header)
+		if err != nil {
+			e.authFailed()
+			return Frame{}, false
+		}
+		frame.Payload = plain
+	} else {
+		authKey := keys.recvAuthKey
+		if !encrypt {
+			authKey = keys.recvControlKey
+		}
+		body := buf[:len(buf)-AuthTagLen]
+		if !hmac.Equal(signTag(authKey, body), buf[len(buf)-AuthTagLen:]) {
+			e.authFailed()
+			return Frame{}, false
+		}
+		frame.Payload = body[secureHeaderLen:]
+	}
+
+	// 对端已经开始使用新会话（握手完成或对端重启），切换到该会话；已被替换的旧会话丢弃
+	if pending {
+		if keys = e.confirmSession(keys); keys == nil {
+			return Frame{}, false
+		}
+	}
+
+	// 重放的帧（过旧或已经收到过）丢弃，认证通过之后才记录，伪造的帧不会影响窗口
+	if !keys.replay.accept(frame.Counter) {
+		return Frame{}, false
+	}
+
+	return frame, true
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 按同一份安全配置创建客户端与服务端
+func newEnginePair(t *testing.T, cfg SecurityConfig) (*Engine, *Engine) {
+	t.Helper()
+	client, err := NewEngine(cfg, false)
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	server, err := NewEngine(cfg, true)
+	if err != nil {
+		t.Fatalf("创建服务端失败: %v", err)
+	}
+	return client, server
+}
+
+// 帧编解码测试使用的安全配置
+var frameConfigs = []struct {
+	name string
+	cfg  SecurityConfig
+}{
+	{"明文", SecurityConfig{}},
+	{"HMAC", SecurityConfig{PSK: "secret"}},
+	{"ChaCha20", SecurityConfig{PSK: "secret", Cipher: CipherChaCha20}},
+	{"AES-GCM", SecurityConfig{PSK: "secret", Cipher: CipherAESGCM}},
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, tt := range frameConfigs {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newEnginePair(t, tt.cfg)
+			payload := []byte("hello bridge")
+
+			// 两个方向都能解码
+			for _, pair := range []struct{ from, to *Engine }{{client, server}, {server, client}} {
+				packet := pair.from.EncodeFrame("abcd", payload)
+				if packet == nil {
+					t.Fatal("EncodeFrame 返回nil")
+				}
+				if len(packet) != len(payload)+pair.from.FrameOverhead() {
+					t.Fatalf("帧长度 %d，应为 %d", len(packet), len(payload)+pair.from.FrameOverhead())
+				}
+				frame, ok := pair.to.DecodeFrame(packet)
+				if !ok {
+					t.Fatal("DecodeFrame 失败")
+				}
+				if frame.Seq != "abcd" || !bytes.Equal(frame.Payload, payload) {
+					t.Fatalf("解码结果 %q %q，应为 %q %q", frame.Seq, frame.Payload, "abcd", payload)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameEncrypted(t *testing.T) {
+	payload := []byte("plain text payload")
+	for _, tt := range frameConfigs {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newEnginePair(t, tt.cfg)
+			packet := client.EncodeFrame("abcd", payload)
+			if got := bytes.Contains(packet, payload); got == client.EncryptEnabled() {
+				t.Fatalf("帧中包含明文负载: %v，启用加密: %v", got, client.EncryptEnabled())
+			}
+		})
+	}
+}
+
+func TestFrameTampered(t *testing.T) {
+	tests := []struct {
+		name  string
+		index func(packet []byte) int
+	}{
+		{"序列号", func([]byte) int { return 0 }},
+		{"会话", func([]byte) int { return SeqLen }},
+		{"计数器", func([]byte) int { return SeqLen + 4 }},
+		{"负载", func([]byte) int { return secureHeaderLen }},
+		{"标签", func(packet []byte) int { return len(packet) - 1 }},
+	}
+
+	for _, cfg := range frameConfigs[1:] {
+		for _, tt := range tests {
+			t.Run(cfg.name+"/"+tt.name, func(t *testing.T) {
+				client, server := newEnginePair(t, cfg.cfg)
+				packet := client.EncodeFrame("abcd", []byte("payload"))
+				packet[tt.index(packet)] ^= 0x01
+
+				if _, ok := server.DecodeFrame(packet); ok {
+					t.Fatalf("篡改%s的帧通过了校验", tt.name)
+				}
+				if server.AuthFailCount() != 1 {
+					t.Fatalf("认证失败计数 %d，应为 1", server.AuthFailCount())
+				}
+			})
+		}
+	}
+}
+
+func TestFrameRejected(t *testing.T) {
+	client, _ := newEnginePair(t, SecurityConfig{PSK: "secret"})
+	tests := []struct {
+		name   string
+		cfg    SecurityConfig
+		packet []byte
+	}{
+		{"密钥不同", SecurityConfig{PSK: "other"}, client.EncodeFrame("abcd", []byte("payload"))},
+		{"加密算法不同", SecurityConfig{PSK: "secret", Cipher: CipherAESGCM}, client.EncodeFrame("abcd", []byte("payload"))},
+		{"过短", SecurityConfig{PSK: "secret"}, make([]byte, secureHeaderLen+AuthTagLen-1)},
+		{"反射回发送方", SecurityConfig{PSK: "secret"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, err := NewEngine(tt.cfg, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet := tt.packet
+			if packet == nil {
+				// 服务端发出的帧不能被服务端自己接受
+				packet = receiver.EncodeFrame("abcd", []byte("payload"))
+			}
+			if _, ok := receiver.DecodeFrame(packet); ok {
+				t.Fatal("帧通过了校验")
+			}
+		})
+	}
+}
+
+func TestFrameReplay(t *testing.T) {
+	for _, tt := range frameConfigs[1:] {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newEnginePair(t, tt.cfg)
+			first := client.EncodeFrame("abcd", []byte("one"))
+			second := client.EncodeFrame("abce", []byte("two"))
+
+			// 乱序到达的帧都接受，重复的帧丢弃
+			steps := []struct {
+				packet []byte
+				want   bool
+			}{
+				{second, true},
+				{first, true},
+				{first, false},
+				{second, false},
+			}
+			for index, step := range steps {
+				// 解码会原地解密，每次使用副本
+				if _, ok := server.DecodeFrame(bytes.Clone(step.packet)); ok != step.want {
+					t.Fatalf("第 %d 步解码结果 %v，应为 %v", index, ok, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	const top = 100000
+	steps := []struct {
+		counter uint64
+		want    bool
+	}{
+		{top, true},
+		{top, false},
+		{top - 1, true},
+		{top - replayWindowSize + 1, true},
+		{top - replayWindowSize, false},
+		{top + 10, true},
+		{top + 5, true},
+		{top + 5, false},
+		{top - 1, false},
+		// 窗口整体前移后，旧的计数器全部视为过旧
+		{top + 10 + replayWindowSize, true},
+		{top + 10, false},
+		{top + 11, true},
+	}
+
+	var window replayWindow
+	for index, step := range steps {
+		if got := window.accept(step.counter); got != step.want {
+			t.Fatalf("第 %d 步 accept(%d) = %v，应为 %v", index, step.counter, got, step.want)
+		}
+	}
+}
+
+func TestFramePSKPeerRestart(t *testing.T) {
+	for _, tt := range frameConfigs[1:] {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newEnginePair(t, tt.cfg)
+
+			var mark FrameMark
+			var captured []byte
+			for range 100 {
+				packet := client.EncodeFrame("abcd", []byte("payload"))
+				captured = bytes.Clone(packet)
+				frame, ok := server.DecodeFrame(packet)
+				if !ok || !server.Newer(mark, frame) {
+					t.Fatal("重启前的帧被拒绝")
+				}
+				mark = frame.Mark()
+			}
+			delayed := client.EncodeFrame("abcd", []byte("payload"))
+
+			// 客户端重启后计数器从头开始，服务端切换到新会话，不会因为计数器更小而丢弃
+			restarted, err := NewEngine(tt.cfg, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame, ok := server.DecodeFrame(restarted.EncodeFrame("abcd", []byte("payload")))
+			if !ok || frame.Counter != 1 {
+				t.Fatalf("重启后的第一个帧 = %v，计数器 %d，应被接受并且计数器为 1", ok, frame.Counter)
+			}
+			if !server.Newer(mark, frame) {
+				t.Fatal("重启后的第一个帧没有被当作更新的帧")
+			}
+			if _, ok := restarted.DecodeFrame(server.EncodeFrame("abcd", []byte("payload"))); !ok {
+				t.Fatal("重启后的客户端无法解码服务端的帧")
+			}
+
+			// 原来的会话已被替换，重放或延迟到达的旧会话的帧都丢弃
+			for name, packet := range map[string][]byte{"重放旧会话的帧": captured, "旧会话中延迟到达的帧": delayed} {
+				if _, ok := server.DecodeFrame(packet); ok {
+					t.Fatalf("%s被接受", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPlainControlKey(t *testing.T) {
+	client, server := newEnginePair(t, SecurityConfig{PSK: "secret"})
+	handshakeClient, handshakeServer := newHandshakePair(t)
+	handshake(t, handshakeClient, handshakeServer)
+
+	pairs := []struct {
+		name           string
+		client, server *Engine
+	}{
+		{"预共享密钥", client, server},
+		{"握手", handshakeClient, handshakeServer},
+	}
+	for _, pair := range pairs {
+		t.Run(pair.name, func(t *testing.T) {
+			// 只认证的控制帧与数据帧使用不同的HMAC密钥，不能被当作对方解码
+			for _, plain := range []bool{false, true} {
+				packet := pair.client.encodeFrame("abcd", []byte("payload"), !plain)
+				if _, ok := pair.server.decodeFrame(bytes.Clone(packet), plain); ok {
+					t.Fatalf("只认证: %v 的帧被当作另一种帧解码", plain)
+				}
+				if _, ok := pair.server.decodeFrame(packet, !plain); !ok {
+					t.Fatalf("只认证: %v 的帧解码失败", plain)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// 握手流程（X25519）
+//
+//	客户端 -> 服务端 握手发起：头(2) | 握手标识(4) | 客户端静态公钥(32) | 客户端临时公钥(32) | 时间戳(8) | 校验(16)
+//	服务端 -> 客户端 握手响应：头(2) | 握手标识(4) | 会话标识(4) | 服务端临时公钥(32) | 校验(16)
+//
+// 发起消息的校验密钥由 DH(客户端临时, 服务端静态) 与 DH(客户端静态, 服务端静态) 派生，
+// 只有持有白名单内私钥的客户端才能构造。服务端记录最近处理过的发起消息，重放的发起消息被拒绝；
+// 时间戳只保证每次的发起消息不同，不参与新旧判断，客户端时钟回拨不影响握手。
+// 更早的发起消息即使在服务端重启后被重放，攻击者也没有客户端临时私钥，无法使用协商出的会话；
+// 握手本身不更新客户端地址，地址只跟随新会话中通过认证的帧。
+// 会话密钥再混入 DH(客户端临时, 服务端临时) 与 DH(客户端静态, 服务端临时)，保证前向安全。
+// 设置了预共享密钥时，预共享密钥作为HKDF的盐参与派生。
+const (
+	handshakeInitLen = controlHeaderLen + 4 + 32 + 32 + 8 + AuthTagLen
+	handshakeRespLen = controlHeaderLen + 4 + 4 + 32 + AuthTagLen
+
+	// 服务端记录的最近处理过的发起消息数量
+	maxRecentInits = 256
+)
+
+// SecurityConfig 帧认证、加密与握手相关配置
+type SecurityConfig struct {
+	// 预共享密钥
+	PSK string
+
+	// 负载加密算法
+	Cipher string
+
+	// 本端静态私钥（base64），设置后启用握手
+	PrivateKey string
+
+	// 服务端静态公钥（base64），客户端使用
+	PeerKey string
+
+	// 允许接入的客户端公钥列表（base64），服务端使用
+	AllowedKeys []string
+
+	// 换钥间隔
+	RekeyInterval time.Duration
+
+	// 单个会话最多发送的字节数，超过后换钥，0表示不限制
+	RekeyBytes uint64
+
+	// 混淆方式，客户端使用第一个发送，服务端接受列表中的全部方式
+	Obfs []string
+
+	// 混淆密钥，为空时由预共享密钥派生
+	ObfsKey string
+
+	// 混淆时随机填充的最大长度
+	ObfsPad int
+}
+
+// clientHandshake 客户端正在进行的握手
+type clientHandshake struct {
+	id        uint32
+	ephemeral *ecdh.PrivateKey
+	message   []byte
+	secret    []byte
+}
+
+// setupSecurity 初始化帧认证、加密与握手
+func (e *Engine) setupSecurity(cfg SecurityConfig, isServer bool) error {
+	if len(cfg.PrivateKey) > 0 {
+		if err := e.setupHandshake(cfg, isServer); err != nil {
+			return err
+		}
+	}
+
+	if err := e.setupAuth(cfg.PSK, cfg.Cipher, isServer); err != nil {
+		return err
+	}
+
+	return e.setupObfuscation(cfg.Obfs, cfg.ObfsKey, cfg.ObfsPad)
+}
+
+// setupHandshake 解析握手所需的密钥
+func (e *Engine) setupHandshake(cfg SecurityConfig, isServer bool) error {
+	e.handshakeMutex.Lock()
+	defer e.handshakeMutex.Unlock()
+
+	var err error
+	if e.staticKey, err = ParsePrivateKey(cfg.PrivateKey); err != nil {
+		return fmt.Errorf("解析本端私钥失败: %v", err)
+	}
+
+	if isServer {
+		e.allowedKeys = make(map[string]bool)
+		for _, key := range cfg.AllowedKeys {
+			if len(key) == 0 {
+				continue
+			}
+			pub, err := ParsePublicKey(key)
+			if err != nil {
+				return fmt.Errorf("解析客户端公钥 %s 失败: %v", key, err)
+			}
+			e.allowedKeys[string(pub.Bytes())] = true
+		}
+		if len(e.allowedKeys) == 0 {
+			return errors.New("启用握手的服务端至少需要一个允许接入的客户端公钥")
+		}
+	} else {
+		if e.peerKey, err = ParsePublicKey(cfg.PeerKey); err != nil {
+			return fmt.Errorf("解析服务端公钥失败: %v", err)
+		}
+	}
+
+	e.handshakeEnabled = true
+	return nil
+}
+
+// HandshakeEnabled 判断是否启用了握手
+func (e *Engine) HandshakeEnabled() bool {
+	return e.handshakeEnabled
+}
+
+// GenerateKeyPair 生成一对X25519密钥，返回base64编码的私钥与公钥
+func GenerateKeyPair() (string, string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ParsePrivateKey 解析base64编码的X25519私钥
+func ParsePrivateKey(key string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// ParsePublicKey 解析base64编码的X25519公钥
+func ParsePublicKey(key string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// handshakeSalt 握手密钥派生使用的盐
+func (e *Engine) handshakeSalt() []byte {
+	if len(e.presharedKey) == 0 {
+		return []byte("UDPRainbowBridge handshake")
+	}
+	sum := sha256.Sum256([]byte("UDPRainbowBridge psk:" + e.presharedKey))
+	return sum[:]
+}
+
+// mixDH 计算一次DH并追加到secret后面
+func mixDH(secret []byte, priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	return append(secret, shared...), nil
+}
+
+// randomUint32 生成非零的随机数
+func randomUint32() uint32 {
+	var b [4]byte
+	for {
+		rand.Read(b[:])
+		if n := binary.BigEndian.Uint32(b[:]); n != 0 {
+			return n
+		}
+	}
+}
+
+// NewHandshakeInit 客户端发起一次新的握手，返回需要在所有链路上发送的握手发起消息
+func (e *Engine) NewHandshakeInit() ([]byte, error) {
+	return e.newHandshakeInit(time.Now())
+}
+
+// newHandshakeInit 使用指定的时间戳发起握手
+func (e *Engine) newHandshakeInit(now time.Time) ([]byte, error) {
+	e.handshakeMutex.Lock()
+	defer e.handshakeMutex.Unlock()
+
+	if !e.handshakeEnabled || e.peerKey == nil {
+		return nil, errors.New("客户端未启用握手")
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := mixDH(nil, ephemeral, e.peerKey)
+	if err != nil {
+		return nil, err
+	}
+	if secret, err = mixDH(secret, e.staticKey, e.peerKey); err != nil {
+		return nil, err
+	}
+
+	hs := &clientHandshake{
+		id:        randomUint32(),
+		ephemeral: ephemeral,
+		secret:    secret,
+	}
+
+	msg := make([]byte, 0, handshakeInitLen)
+	msg = append(msg, ControlMagic, ControlHandshakeInit)
+	msg = binary.BigEndian.AppendUint32(msg, hs.id)
+	msg = append(msg, e.staticKey.PublicKey().Bytes()...)
+	msg = append(msg, ephemeral.PublicKey().Bytes()...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(now.UnixNano()))
+	msg = append(msg, signTag(deriveKey(secret, e.handshakeSalt(), "init"), msg)...)
+
+	hs.message = msg
+	e.pendingHandshake = hs
+	e.rekeyRequested.Store(false)
+
+	return msg, nil
+}
+
+// HandshakePending 判断客户端是否有尚未完成的握手
+func (e *Engine) HandshakePending() bool {
+	e.handshakeMutex.Lock()
+	defer e.handshakeMutex.Unlock()
+	return e.pendingHandshake != nil
+}
+
+// deriveSessionKeys 由握手的全部DH结果与消息记录派生会话密钥，返回响应校验密钥与会话密钥
+func (e *Engine) deriveSessionKeys(secret []byte, initMsg []byte, respHeader []byte, id uint32) ([]byte, *sessionKeys, error) {
+	transcript := sha256.New()
+	transcript.Write(initMsg)
+	transcript.Write(respHeader)
+	hash := string(transcript.Sum(nil))
+
+	salt := e.handshakeSalt()
+	respKey := deriveKey(secret, salt, "resp"+hash)
+	keys, err := e.newSessionKeys(id, func(info string) []byte {
+		return deriveKey(secret, salt, info+hash)
+	})
+	return respKey, keys, err
+}
+
+// HandleHandshakeInit 服务端处理握手发起消息，成功时返回需要回复给客户端的握手响应
+func (e *Engine) HandleHandshakeInit(msg []byte) ([]byte, error) {
+	e.handshakeMutex.Lock()
+	defer e.handshakeMutex.Unlock()
+
+	if !e.handshakeEnabled || e.allowedKeys == nil {
+		return nil, errors.New("服务端未启用握手")
+	}
+
+	if len(msg) != handshakeInitLen {
+		return nil, errors.New("握手发起消息长度错误")
+	}
+
+	// 同一发起消息从多条链路到达，直接重发相同的响应
+	if bytes.Equal(msg, e.lastInitMessage) {
+		return e.lastRespMessage, nil
+	}
+
+	body := msg[:handshakeInitLen-AuthTagLen]
+	clientStatic := body[6:38]
+	if !e.allowedKeys[string(clientStatic)] {
+		return nil, errors.New("客户端公钥不在白名单内")
+	}
+
+	clientStaticKey, err := ecdh.X25519().NewPublicKey(clientStatic)
+	if err != nil {
+		return nil, err
+	}
+	clientEphemeralKey, err := ecdh.X25519().NewPublicKey(body[38:70])
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := mixDH(nil, e.staticKey, clientEphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	if secret, err = mixDH(secret, e.staticKey, clientStaticKey); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(signTag(deriveKey(secret, e.handshakeSalt(), "init"), body), msg[len(body):]) {
+		return nil, errors.New("握手发起消息校验失败")
+	}
+
+	// 防止重放处理过的握手
+	tag := string(msg[len(body):])
+	if slices.Contains(e.recentInitTags, tag) {
+		return nil, errors.New("握手发起消息已经处理过，可能是重放")
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret, err = mixDH(secret, ephemeral, clientEphemeralKey); err != nil {
+		return nil, err
+	}
+	if secret, err = mixDH(secret, ephemeral, clientStaticKey); err != nil {
+		return nil, err
+	}
+
+	id := randomUint32()
+	resp := make([]byte, 0, handshakeRespLen)
+	resp = append(resp, ControlMagic, ControlHandshakeResp)
+	resp = append(resp, body[2:6]...)
+	resp = binary.BigEndian.AppendUint32(resp, id)
+	resp = append(resp, ephemeral.PublicKey().Bytes()...)
+
+	respKey, keys, err := e.deriveSessionKeys(secret, msg, resp, id)
+	if err != nil {
+		return nil, err
+	}
+	resp = append(resp, signTag(respKey, resp)...)
+
+	e.recentInitTags = append(e.recentInitTags, tag)
+	if len(e.recentInitTags) > maxRecentInits {
+		e.recentInitTags = e.recentInitTags[1:]
+	}
+	e.lastInitMessage = append([]byte(nil), msg...)
+	e.lastRespMessage = resp
+
+	// 等客户端使用新会话发来数据后再切换发送密钥
+	e.installSession(keys, false)
+
+	return resp, nil
+}
+
+// HandleHandshakeResponse 客户端处理握手响应
+// 返回true表示建立了新会话，重复的响应（其它链路上到达）返回false
+func (e *Engine) HandleHandshakeResponse(msg []byte) (bool, error) {
+	e.handshakeMutex.Lock()
+	defer e.handshakeMutex.Unlock()
+
+	if len(msg) != handshakeRespLen {
+		return false, errors.New("握手响应长度错误")
+	}
+
+	hs := e.pendingHandshake
+	if hs == nil || binary.BigEndian.Uint32(msg[2:6]) != hs.id {
+		return false, nil
+	}
+
+	serverEphemeralKey, err := ecdh.X25519().NewPublicKey(msg[10:42])
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := mixDH(append([]byte(nil), hs.secret...), hs.ephemeral, serverEphemeralKey)
+	if err != nil {
+		return false, err
+	}
+	if secret, err = mixDH(secret, e.staticKey, serverEphemeralKey); err != nil {
+		return false, err
+	}
+
+	header := msg[:handshakeRespLen-AuthTagLen]
+	respKey, keys, err := e.deriveSessionKeys(secret, hs.message, header, binary.BigEndian.Uint32(msg[6:10]))
+	if err != nil {
+		return false, err
+	}
+
+	if !hmac.Equal(signTag(respKey, header), msg[len(header):]) {
+		return false, errors.New("握手响应校验失败")
+	}
+
+	e.pendingHandshake = nil
+	e.installSession(keys, true)
+
+	return true, nil
+}
+
+// RekeyDue 判断当前会话是否需要换钥
+func (e *Engine) RekeyDue(interval time.Duration, maxBytes uint64) bool {
+	if e.rekeyRequested.Load() {
+		return true
+	}
+
+	keys := e.currentKeys.Load()
+	if keys == nil {
+		return false
+	}
+
+	if interval > 0 && time.Since(keys.created) >= interval {
+		return true
+	}
+
+	return maxBytes > 0 && atomic.LoadUint64(&keys.sentBytes) >= maxBytes
+}
+
+// RequestRekey 要求客户端立即重新握手，服务端关闭会话后使用（服务端重启后原来的会话密钥已经失效）
+func (e *Engine) RequestRekey() {
+	e.rekeyRequested.Store(true)
+}
+
+// 重新握手请求的长度：控制帧头 | 会话标识(4)
+const rekeyRequestLen = controlHeaderLen + 4
+
+// NewRekeyRequest 服务端收到无法认证的数据帧或带认证的控制帧时调用：握手模式下帧头中的会话标识不属于本端的任何会话时，
+// 生成要求客户端重新握手的请求（回显该会话标识），否则返回nil
+// 请求比任何带认证的帧都短，不会被用来放大流量
+func (e *Engine) NewRekeyRequest(packet []byte) []byte {
+	frame := packet
+	if IsControlFrame(packet) {
+		frame = packet[controlHeaderLen:]
+	}
+	if !e.serverRole || !e.HandshakeEnabled() || len(frame) < secureHeaderLen+AuthTagLen {
+		return nil
+	}
+
+	id := binary.BigEndian.Uint32(frame[SeqLen:])
+	if keys, _ := e.lookupRecvKeys(id); keys != nil {
+		// 会话存在，只是认证失败
+		return nil
+	}
+	return binary.BigEndian.AppendUint32([]byte{ControlMagic, ControlRekey}, id)
+}
+
+// AcceptRekeyRequest 客户端处理服务端的重新握手请求，返回是否需要重新握手（多条链路上重复收到时只返回一次true）
+// 请求没有认证，只接受回显了当前会话标识的请求，不在路径上的攻击者无法伪造；握手进行中时忽略
+func (e *Engine) AcceptRekeyRequest(buf []byte) bool {
+	if e.serverRole || !e.HandshakeEnabled() || len(buf) != rekeyRequestLen {
+		return false
+	}
+
+	keys := e.currentKeys.Load()
+	if keys == nil || keys.id != binary.BigEndian.Uint32(buf[controlHeaderLen:]) || e.HandshakePending() {
+		return false
+	}
+	return e.rekeyRequested.CompareAndSwap(false, true)
+}
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// 生成一对密钥
+func newKeyPair(t *testing.T) (string, string) {
+	t.Helper()
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	return priv, pub
+}
+
+// 创建启用握手的客户端与服务端，服务端只允许该客户端接入
+func newHandshakePair(t *testing.T) (*Engine, *Engine) {
+	t.Helper()
+	clientPriv, clientPub := newKeyPair(t)
+	serverPriv, serverPub := newKeyPair(t)
+
+	client, err := NewEngine(SecurityConfig{PrivateKey: clientPriv, PeerKey: serverPub, Cipher: CipherChaCha20}, false)
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	server, err := NewEngine(SecurityConfig{PrivateKey: serverPriv, AllowedKeys: []string{clientPub}, Cipher: CipherChaCha20}, true)
+	if err != nil {
+		t.Fatalf("创建服务端失败: %v", err)
+	}
+	return client, server
+}
+
+// 完成一次握手
+func handshake(t *testing.T, client *Engine, server *Engine) []byte {
+	t.Helper()
+	init, err := client.NewHandshakeInit()
+	if err != nil {
+		t.Fatalf("NewHandshakeInit: %v", err)
+	}
+	resp, err := server.HandleHandshakeInit(init)
+	if err != nil {
+		t.Fatalf("HandleHandshakeInit: %v", err)
+	}
+	if established, err := client.HandleHandshakeResponse(resp); !established || err != nil {
+		t.Fatalf("HandleHandshakeResponse = %v, %v", established, err)
+	}
+	return init
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := newHandshakePair(t)
+	if client.EncodeFrame("abcd", []byte("x")) != nil {
+		t.Fatal("握手完成前客户端编码了数据帧")
+	}
+
+	init := handshake(t, client, server)
+
+	// 其它链路上重复到达的发起消息得到相同的响应，客户端不会重复建立会话
+	resp, err := server.HandleHandshakeInit(init)
+	if err != nil {
+		t.Fatalf("重复的发起消息被拒绝: %v", err)
+	}
+	if established, err := client.HandleHandshakeResponse(resp); established || err != nil {
+		t.Fatalf("重复的响应 = %v, %v，应为 false, nil", established, err)
+	}
+
+	// 客户端先使用新会话发送，服务端确认后两个方向都能解码
+	for _, pair := range []struct{ from, to *Engine }{{client, server}, {server, client}} {
+		frame, ok := pair.to.DecodeFrame(pair.from.EncodeFrame("abcd", []byte("payload")))
+		if !ok || string(frame.Payload) != "payload" {
+			t.Fatalf("会话建立后解码失败: %q, %v", frame.Payload, ok)
+		}
+	}
+}
+
+func TestHandshakeInitRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// 返回发送给服务端的发起消息
+		init func(t *testing.T, client *Engine, server *Engine) []byte
+	}{
+		{"重放旧的发起消息", func(t *testing.T, client *Engine, server *Engine) []byte {
+			old := handshake(t, client, server)
+			handshake(t, client, server)
+			return old
+		}},
+		{"公钥不在白名单内", func(t *testing.T, _ *Engine, server *Engine) []byte {
+			priv, _ := newKeyPair(t)
+			// 使用正确的服务端公钥，只有客户端公钥不在白名单内
+			serverPub := base64.StdEncoding.EncodeToString(server.staticKey.PublicKey().Bytes())
+			stranger, err := NewEngine(SecurityConfig{PrivateKey: priv, PeerKey: serverPub}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			init, _ := stranger.NewHandshakeInit()
+			return init
+		}},
+		{"服务端公钥不同", func(t *testing.T, client *Engine, _ *Engine) []byte {
+			_, other := newKeyPair(t)
+			peer, err := ParsePublicKey(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.peerKey = peer
+			init, _ := client.NewHandshakeInit()
+			return init
+		}},
+		{"篡改时间戳", func(t *testing.T, client *Engine, _ *Engine) []byte {
+			init, _ := client.NewHandshakeInit()
+			init[handshakeInitLen-AuthTagLen-1] ^= 0x01
+			return init
+		}},
+		{"长度错误", func(t *testing.T, client *Engine, _ *Engine) []byte {
+			init, _ := client.NewHandshakeInit()
+			return init[:len(init)-1]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newHandshakePair(t)
+			init := tt.init(t, client, server)
+			if resp, err := server.HandleHandshakeInit(init); err == nil {
+				t.Fatalf("发起消息被接受，响应 %x", resp)
+			}
+		})
+	}
+}
+
+func TestRekeyRequest(t *testing.T) {
+	client, server := newHandshakePair(t)
+	handshake(t, client, server)
+	packet := client.EncodeFrame("abcd", []byte("payload"))
+
+	// 服务端认识该会话时不要求重新握手
+	if request := server.NewRekeyRequest(packet); request != nil {
+		t.Fatalf("会话存在时生成了重新握手请求 %x", request)
+	}
+
+	// 服务端重启后不认识原来的会话
+	_, restarted := newHandshakePair(t)
+	restarted.allowedKeys = server.allowedKeys
+	restarted.staticKey = server.staticKey
+	request := restarted.NewRekeyRequest(packet)
+	if len(request) != rekeyRequestLen || len(request) >= len(packet) {
+		t.Fatalf("重新握手请求长度 %d，应为 %d 并且比收到的帧短", len(request), rekeyRequestLen)
+	}
+
+	forged := bytes.Clone(request)
+	forged[len(forged)-1] ^= 0x01
+	steps := []struct {
+		name    string
+		request []byte
+		want    bool
+	}{
+		{"会话标识不符", forged, false},
+		{"第一次收到", request, true},
+		{"其它链路上重复收到", request, false},
+	}
+	for _, step := range steps {
+		if got := client.AcceptRekeyRequest(step.request); got != step.want {
+			t.Fatalf("%s: AcceptRekeyRequest = %v，应为 %v", step.name, got, step.want)
+		}
+	}
+	if !client.RekeyDue(0, 0) {
+		t.Fatal("接受重新握手请求后RekeyDue返回false")
+	}
+
+	// 重新握手后与重启的服务端恢复通信
+	handshake(t, client, restarted)
+	if client.RekeyDue(0, 0) {
+		t.Fatal("重新握手后RekeyDue仍返回true")
+	}
+	if _, ok := restarted.DecodeFrame(client.EncodeFrame("abcd", []byte("payload"))); !ok {
+		t.Fatal("重新握手后服务端解码失败")
+	}
+}
+
+func TestHandshakeClockBackwards(t *testing.T) {
+	client, server := newHandshakePair(t)
+
+	// 客户端时钟回拨后发起的握手仍然被接受，会话可以使用
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(-time.Hour), now.Add(-2 * time.Hour)} {
+		init, err := client.newHandshakeInit(at)
+		if err != nil {
+			t.Fatalf("newHandshakeInit: %v", err)
+		}
+		resp, err := server.HandleHandshakeInit(init)
+		if err != nil {
+			t.Fatalf("时间戳 %v 的发起消息被拒绝: %v", at, err)
+		}
+		if established, err := client.HandleHandshakeResponse(resp); !established || err != nil {
+			t.Fatalf("HandleHandshakeResponse = %v, %v", established, err)
+		}
+		if _, ok := server.DecodeFrame(client.EncodeFrame("abcd", []byte("payload"))); !ok {
+			t.Fatalf("时间戳 %v 的会话解码失败", at)
+		}
+	}
+}
+
+func TestNewerAfterRestart(t *testing.T) {
+	client, server := newHandshakePair(t)
+	handshake(t, client, server)
+
+	// 按服务端更新客户端地址的方式记录最新的帧，返回帧是否通过认证以及是否更新
+	var mark FrameMark
+	receive := func(packet []byte) (bool, bool) {
+		frame, ok := server.DecodeFrame(packet)
+		if !ok {
+			return false, false
+		}
+		newer := server.Newer(mark, frame)
+		if newer {
+			mark = frame.Mark()
+		}
+		return true, newer
+	}
+
+	var captured [][]byte
+	for range 10 {
+		packet := client.EncodeFrame("abcd", []byte("payload"))
+		captured = append(captured, bytes.Clone(packet))
+		if ok, newer := receive(packet); !ok || !newer {
+			t.Fatalf("同一会话内按顺序到达的帧 = %v, %v，应为 true, true", ok, newer)
+		}
+	}
+	// 旧会话中发出、在客户端重启之后才到达的帧
+	delayed := client.EncodeFrame("abcd", []byte("payload"))
+
+	// 客户端使用相同的密钥重启并重新握手，计数器从头开始
+	restarted, err := NewEngine(SecurityConfig{
+		PrivateKey: base64.StdEncoding.EncodeToString(client.staticKey.Bytes()),
+		PeerKey:    base64.StdEncoding.EncodeToString(client.peerKey.Bytes()),
+		Cipher:     CipherChaCha20,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake(t, restarted, server)
+
+	steps := []struct {
+		name   string
+		packet []byte
+		ok     bool
+		newer  bool
+	}{
+		{"重启后的第一个帧", restarted.EncodeFrame("abcd", []byte("payload")), true, true},
+		{"重放旧会话的帧", captured[len(captured)-1], false, false},
+		{"旧会话中延迟到达的帧", delayed, true, false},
+		{"重启后的第二个帧", restarted.EncodeFrame("abcd", []byte("payload")), true, true},
+	}
+	for _, step := range steps {
+		if ok, newer := receive(step.packet); ok != step.ok || newer != step.newer {
+			t.Fatalf("%s = %v, %v，应为 %v, %v", step.name, ok, newer, step.ok, step.newer)
+		}
+	}
+}
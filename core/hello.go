@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// 当前协议版本
+	ProtocolVersion = 1
+
+	// 能够互通的最低协议版本
+	MinProtocolVersion = 1
+
+	// 两端协商后的最大包体不能小于该值
+	MinMTU = 576
+)
+
+// 可协商的功能
+const (
+	FeatureFEC         = "fec"
+	FeatureCompression = "compression"
+)
+
+// 本程序实现的可协商功能（FEC与压缩尚未实现）
+var supportedFeatures []string
+
+// Hello 一端的能力：协议版本、模式、最大包体、加密算法与可协商的功能
+// Mode是本端发送方向使用的模式（客户端为上行，服务端为默认的下行），DownMode是客户端请求服务端使用的下行模式
+type Hello struct {
+	Version  int      `json:"version"`
+	Mode     string   `json:"mode"`
+	DownMode string   `json:"down_mode,omitempty"`
+	Modes    []string `json:"modes"`
+	MTU      int      `json:"mtu"`
+	Cipher   string   `json:"cipher"`
+	Features []string `json:"features"`
+}
+
+// Agreement 两端协商出的共同参数
+type Agreement struct {
+	Version  int
+	UpMode   string
+	DownMode string
+	MTU      int
+	Features []string
+}
+
+// LocalHello 生成本端的能力
+func (e *Engine) LocalHello(mode string, modes []string, mtu int) Hello {
+	return Hello{
+		Version:  ProtocolVersion,
+		Mode:     mode,
+		Modes:    modes,
+		MTU:      mtu,
+		Cipher:   e.CipherName(),
+		Features: supportedFeatures,
+	}
+}
+
+// Negotiate 按两端的能力协商共同参数，无法互通时返回原因
+// 两端使用相同的规则，各自计算的结果一致
+func (e *Engine) Negotiate(local Hello, peer Hello) (Agreement, error) {
+	// 原因会回复给对端，按服务端、客户端描述两端的值，两端看到的内容一致
+	server, client := local, peer
+	if !e.serverRole {
+		server, client = peer, local
+	}
+
+	// 上行使用客户端的模式，下行使用客户端请求的模式，客户端没有请求时使用服务端的模式
+	agreement := Agreement{
+		Version:  min(local.Version, peer.Version),
+		UpMode:   client.Mode,
+		DownMode: client.DownMode,
+		MTU:      min(local.MTU, peer.MTU),
+	}
+	if len(agreement.DownMode) == 0 {
+		agreement.DownMode = server.Mode
+	}
+
+	// 对端版本更新时由对端判断能否兼容本端
+	if agreement.Version < MinProtocolVersion {
+		return agreement, fmt.Errorf("协议版本不兼容（服务端 %d，客户端 %d）", server.Version, client.Version)
+	}
+
+	// 两个方向的模式只影响发送方，接收方都按序列号去重，只需要发送方支持
+	if !slices.Contains(client.Modes, agreement.UpMode) {
+		return agreement, fmt.Errorf("客户端不支持上行模式 %s", agreement.UpMode)
+	}
+	if !slices.Contains(server.Modes, agreement.DownMode) {
+		return agreement, fmt.Errorf("服务端不支持下行模式 %s（支持 %v）", agreement.DownMode, server.Modes)
+	}
+
+	if local.Cipher != peer.Cipher {
+		return agreement, fmt.Errorf("加密算法不一致（服务端 %s，客户端 %s）", server.Cipher, client.Cipher)
+	}
+
+	if agreement.MTU < MinMTU {
+		return agreement, fmt.Errorf("最大包体过小（服务端 %d，客户端 %d，至少 %d）", server.MTU, client.MTU, MinMTU)
+	}
+
+	for _, feature := range local.Features {
+		if slices.Contains(peer.Features, feature) {
+			agreement.Features = append(agreement.Features, feature)
+		}
+	}
+	return agreement, nil
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 测试用的能力，按需修改
+func testHello(mode string, mutate func(*Hello)) Hello {
+	hello := Hello{
+		Version: ProtocolVersion,
+		Mode:    mode,
+		Modes:   []string{"mode1", "mode2"},
+		MTU:     1492,
+		Cipher:  CipherNone,
+	}
+	if mutate != nil {
+		mutate(&hello)
+	}
+	return hello
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Hello
+		client Hello
+		want   Agreement
+		err    string
+	}{
+		{
+			name:   "使用服务端的下行模式",
+			server: testHello("mode1", nil),
+			client: testHello("mode2", nil),
+			want:   Agreement{Version: ProtocolVersion, UpMode: "mode2", DownMode: "mode1", MTU: 1492},
+		},
+		{
+			name:   "客户端请求下行模式",
+			server: testHello("mode1", nil),
+			client: testHello("mode1", func(h *Hello) { h.DownMode = "mode2" }),
+			want:   Agreement{Version: ProtocolVersion, UpMode: "mode1", DownMode: "mode2", MTU: 1492},
+		},
+		{
+			name:   "最大包体取较小值",
+			server: testHello("mode1", func(h *Hello) { h.MTU = 1400 }),
+			client: testHello("mode1", nil),
+			want:   Agreement{Version: ProtocolVersion, UpMode: "mode1", DownMode: "mode1", MTU: 1400},
+		},
+		{
+			name:   "只启用两端都支持的功能",
+			server: testHello("mode1", func(h *Hello) { h.Features = []string{FeatureFEC, FeatureCompression} }),
+			client: testHello("mode1", func(h *Hello) { h.Features = []string{FeatureCompression} }),
+			want:   Agreement{Version: ProtocolVersion, UpMode: "mode1", DownMode: "mode1", MTU: 1492, Features: []string{FeatureCompression}},
+		},
+		{
+			name:   "协议版本不兼容",
+			server: testHello("mode1", nil),
+			client: testHello("mode1", func(h *Hello) { h.Version = MinProtocolVersion - 1 }),
+			err:    "协议版本不兼容",
+		},
+		{
+			name:   "客户端不支持上行模式",
+			server: testHello("mode1", nil),
+			client: testHello("mode3", nil),
+			err:    "客户端不支持上行模式 mode3",
+		},
+		{
+			name:   "服务端不支持下行模式",
+			server: testHello("mode1", func(h *Hello) { h.Modes = []string{"mode1"} }),
+			client: testHello("mode1", func(h *Hello) { h.DownMode = "mode2" }),
+			err:    "服务端不支持下行模式 mode2",
+		},
+		{
+			name:   "加密算法不一致",
+			server: testHello("mode1", func(h *Hello) { h.Cipher = CipherAESGCM }),
+			client: testHello("mode1", func(h *Hello) { h.Cipher = CipherChaCha20 }),
+			err:    "加密算法不一致（服务端 aes-256-gcm，客户端 chacha20-poly1305）",
+		},
+		{
+			name:   "最大包体过小",
+			server: testHello("mode1", nil),
+			client: testHello("mode1", func(h *Hello) { h.MTU = MinMTU - 1 }),
+			err:    "最大包体过小",
+		},
+	}
+
+	server := &Engine{serverRole: true}
+	client := &Engine{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 两端各自协商，结果与原因一致
+			serverResult, serverErr := server.Negotiate(tt.server, tt.client)
+			clientResult, clientErr := client.Negotiate(tt.client, tt.server)
+			if (serverErr == nil) != (clientErr == nil) || (serverErr != nil && serverErr.Error() != clientErr.Error()) {
+				t.Fatalf("两端的协商错误不一致：服务端 %v，客户端 %v", serverErr, clientErr)
+			}
+
+			if len(tt.err) > 0 {
+				if serverErr == nil || !strings.Contains(serverErr.Error(), tt.err) {
+					t.Fatalf("协商错误 %v，应包含 %q", serverErr, tt.err)
+				}
+				return
+			}
+			if serverErr != nil {
+				t.Fatalf("协商失败: %v", serverErr)
+			}
+			if !reflect.DeepEqual(serverResult, tt.want) || !reflect.DeepEqual(clientResult, tt.want) {
+				t.Fatalf("协商结果：服务端 %+v，客户端 %+v，应为 %+v", serverResult, clientResult, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRequestCipherMismatch(t *testing.T) {
+	// 参数请求只认证不加密，加密算法不一致时服务端仍能读出客户端能力并回复原因
+	client, err := NewEngine(SecurityConfig{PSK: "secret", Cipher: CipherChaCha20}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewEngine(SecurityConfig{PSK: "secret", Cipher: CipherAESGCM}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := client.NewConfigRequest(client.LocalHello("mode1", []string{"mode1"}, 1492))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hello, ok, err := server.OpenConfigRequest(request)
+	if !ok || err != nil {
+		t.Fatalf("OpenConfigRequest = %v, %v", ok, err)
+	}
+
+	local := server.LocalHello("mode1", []string{"mode1"}, 1492)
+	_, negotiateErr := server.Negotiate(local, hello)
+	if negotiateErr == nil || !strings.Contains(negotiateErr.Error(), "加密算法不一致") {
+		t.Fatalf("协商错误 %v，应为加密算法不一致", negotiateErr)
+	}
+
+	response, err := server.NewConfigResponse(&ServerParams{Hello: local, Refused: negotiateErr.Error()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := client.OpenConfigResponse(response)
+	if err != nil {
+		t.Fatalf("OpenConfigResponse: %v", err)
+	}
+	if params.Refused != negotiateErr.Error() {
+		t.Fatalf("拒绝原因 %q，应为 %q", params.Refused, negotiateErr.Error())
+	}
+}
@@ -0,0 +1,287 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// 流量混淆
+//
+// 混淆在帧编码之后、写入套接字之前进行，接收时在解码帧之前还原，对帧格式透明。
+// 内置的混淆方式：
+//
+//	none：不混淆
+//	mask：随机nonce(12) | 掩码(校验(4) | 长度(2) | 帧) | 随机填充
+//	stun：伪装为STUN消息，事务ID作为nonce，掩码后的内容放在DATA属性中
+//
+// 掩码使用由混淆密钥派生的ChaCha20密钥流，整个帧（包括序列号与内层协议头）都被遮盖，
+// 随机长度的填充打乱包长特征。客户端按配置的第一种方式发送，服务端按允许的方式逐一尝试还原，
+// 并在每条链路上使用客户端最近一次使用的方式回复，以此完成协商。
+type Obfuscator interface {
+	// 混淆方式名称
+	Name() string
+
+	// 最大额外开销（字节）
+	Overhead() int
+
+	// 混淆一个待发送的包
+	Wrap(packet []byte) []byte
+
+	// 还原收到的包，不是本方式混淆的包返回false
+	Unwrap(packet []byte) ([]byte, bool)
+}
+
+// ObfuscatorFactory 根据混淆密钥与最大填充长度创建混淆器
+type ObfuscatorFactory func(key []byte, maxPad int) Obfuscator
+
+const (
+	// 掩码内的校验值，用于判断包是否为本方式混淆
+	maskCheck uint32 = 0x52424F42
+
+	maskNonceLen  = chacha20.NonceSize
+	maskHeaderLen = 4 + 2
+
+	// STUN消息头
+	stunHeaderLen   = 20
+	stunMagicCookie = 0x2112A442
+	stunAttrData    = 0x0013
+)
+
+// 已注册的混淆方式
+var obfuscatorFactories = map[string]ObfuscatorFactory{
+	"none": func(key []byte, maxPad int) Obfuscator { return plainObfuscator{} },
+	"mask": func(key []byte, maxPad int) Obfuscator { return &maskObfuscator{key: key, maxPad: maxPad} },
+	"stun": func(key []byte, maxPad int) Obfuscator {
+		return &stunObfuscator{maskObfuscator{key: key, maxPad: maxPad}}
+	},
+}
+
+// RegisterObfuscator 注册自定义的混淆方式
+func RegisterObfuscator(name string, factory ObfuscatorFactory) {
+	obfuscatorFactories[name] = factory
+}
+
+// ObfuscatorRegistered 判断混淆方式是否已注册
+func ObfuscatorRegistered(name string) bool {
+	_, exists := obfuscatorFactories[name]
+	return exists
+}
+
+// setupObfuscation 按名称列表启用混淆方式
+// key为空时使用预共享密钥派生，都为空时使用内置密钥（只能防止特征识别，不能防止针对性分析）
+func (e *Engine) setupObfuscation(names []string, key string, maxPad int) error {
+	if maxPad < 0 || maxPad > 1024 {
+		return fmt.Errorf("混淆填充长度 %d 超出范围（0-1024）", maxPad)
+	}
+
+	if len(key) == 0 {
+		key = e.presharedKey
+	}
+	sum := sha256.Sum256([]byte("UDPRainbowBridge obfs:" + key))
+
+	var list []Obfuscator
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		factory, exists := obfuscatorFactories[name]
+		if !exists {
+			return fmt.Errorf("不支持的混淆方式: %s", name)
+		}
+		list = append(list, factory(sum[:], maxPad))
+	}
+
+	if len(list) == 0 {
+		list = []Obfuscator{plainObfuscator{}}
+	}
+
+	e.obfuscators = list
+	return nil
+}
+
+// ObfsOverhead 返回启用的混淆方式中最大的额外开销
+func (e *Engine) ObfsOverhead() int {
+	overhead := 0
+	for _, obfs := range e.obfuscators {
+		if obfs.Overhead() > overhead {
+			overhead = obfs.Overhead()
+		}
+	}
+	return overhead
+}
+
+// ObfsName 返回第kind种启用的混淆方式的名称
+func (e *Engine) ObfsName(kind int) string {
+	return e.obfuscators[kind].Name()
+}
+
+// Obfuscate 使用第kind种启用的混淆方式混淆待发送的包
+func (e *Engine) Obfuscate(kind int, packet []byte) []byte {
+	return e.obfuscators[kind].Wrap(packet)
+}
+
+// Deobfuscate 还原收到的包，返回还原后的包与对端使用的混淆方式
+// 不混淆的方式总是最后尝试
+func (e *Engine) Deobfuscate(packet []byte) ([]byte, int, bool) {
+	plain := -1
+	for kind, obfs := range e.obfuscators {
+		if _, ok := obfs.(plainObfuscator); ok {
+			plain = kind
+			continue
+		}
+		if inner, ok := obfs.Unwrap(packet); ok {
+			return inner, kind, true
+		}
+	}
+
+	if plain >= 0 {
+		return packet, plain, true
+	}
+	return nil, 0, false
+}
+
+// plainObfuscator 不混淆
+type plainObfuscator struct{}
+
+func (plainObfuscator) Name() string                        { return "none" }
+func (plainObfuscator) Overhead() int                       { return 0 }
+func (plainObfuscator) Wrap(packet []byte) []byte           { return packet }
+func (plainObfuscator) Unwrap(packet []byte) ([]byte, bool) { return packet, true }
+
+// maskObfuscator 密钥流掩码 + 随机填充
+type maskObfuscator struct {
+	key    []byte
+	maxPad int
+}
+
+func (m *maskObfuscator) Name() string {
+	return "mask"
+}
+
+func (m *maskObfuscator) Overhead() int {
+	return maskNonceLen + maskHeaderLen + m.maxPad
+}
+
+// padLen 随机填充长度
+func (m *maskObfuscator) padLen() int {
+	if m.maxPad <= 0 {
+		return 0
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(m.maxPad+1)))
+	return int(n.Int64())
+}
+
+// xorKeyStream 使用nonce对应的密钥流对data做异或
+func (m *maskObfuscator) xorKeyStream(nonce []byte, data []byte) {
+	stream, _ := chacha20.NewUnauthenticatedCipher(m.key, nonce)
+	stream.XORKeyStream(data, data)
+}
+
+// seal 掩码并填充，结果追加到dst后面
+func (m *maskObfuscator) seal(dst []byte, nonce []byte, packet []byte) []byte {
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint32(dst, maskCheck)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(packet)))
+	dst = append(dst, packet...)
+	m.xorKeyStream(nonce, dst[start:])
+
+	pad := make([]byte, m.padLen())
+	rand.Read(pad)
+	return append(dst, pad...)
+}
+
+// open 还原掩码并去掉填充
+func (m *maskObfuscator) open(nonce []byte, body []byte) ([]byte, bool) {
+	if len(body) < maskHeaderLen {
+		return nil, false
+	}
+
+	// 先只还原头部判断是否为本方式混淆，避免对无关的包做完整解码
+	header := append([]byte(nil), body[:maskHeaderLen]...)
+	m.xorKeyStream(nonce, header)
+	if binary.BigEndian.Uint32(header) != maskCheck {
+		return nil, false
+	}
+
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if maskHeaderLen+length > len(body) {
+		return nil, false
+	}
+
+	data := body[:maskHeaderLen+length]
+	m.xorKeyStream(nonce, data)
+	return data[maskHeaderLen:], true
+}
+
+func (m *maskObfuscator) Wrap(packet []byte) []byte {
+	out := make([]byte, maskNonceLen, maskNonceLen+maskHeaderLen+len(packet)+m.maxPad)
+	rand.Read(out)
+	return m.seal(out, out[:maskNonceLen], packet)
+}
+
+func (m *maskObfuscator) Unwrap(packet []byte) ([]byte, bool) {
+	if len(packet) < maskNonceLen {
+		return nil, false
+	}
+	return m.open(packet[:maskNonceLen], packet[maskNonceLen:])
+}
+
+// stunObfuscator 伪装为STUN消息的掩码混淆
+type stunObfuscator struct {
+	maskObfuscator
+}
+
+func (s *stunObfuscator) Name() string {
+	return "stun"
+}
+
+func (s *stunObfuscator) Overhead() int {
+	return stunHeaderLen + 4 + maskHeaderLen + s.maxPad + 3
+}
+
+func (s *stunObfuscator) Wrap(packet []byte) []byte {
+	out := make([]byte, stunHeaderLen+4, stunHeaderLen+4+maskHeaderLen+len(packet)+s.maxPad+3)
+
+	// 消息头：Binding Request | 长度 | Magic Cookie | 事务ID（作为nonce）
+	binary.BigEndian.PutUint16(out[0:], 0x0001)
+	binary.BigEndian.PutUint32(out[4:], stunMagicCookie)
+	rand.Read(out[8:stunHeaderLen])
+
+	out = s.seal(out, out[8:stunHeaderLen], packet)
+
+	// DATA属性长度，属性按4字节对齐
+	attrLen := len(out) - stunHeaderLen - 4
+	for len(out)%4 != 0 {
+		out = append(out, 0)
+	}
+	binary.BigEndian.PutUint16(out[stunHeaderLen:], stunAttrData)
+	binary.BigEndian.PutUint16(out[stunHeaderLen+2:], uint16(attrLen))
+	binary.BigEndian.PutUint16(out[2:], uint16(len(out)-stunHeaderLen))
+
+	return out
+}
+
+func (s *stunObfuscator) Unwrap(packet []byte) ([]byte, bool) {
+	if len(packet) < stunHeaderLen+4 || binary.BigEndian.Uint32(packet[4:]) != stunMagicCookie {
+		return nil, false
+	}
+
+	if binary.BigEndian.Uint16(packet[stunHeaderLen:]) != stunAttrData {
+		return nil, false
+	}
+
+	attrLen := int(binary.BigEndian.Uint16(packet[stunHeaderLen+2:]))
+	if stunHeaderLen+4+attrLen > len(packet) {
+		return nil, false
+	}
+
+	return s.open(packet[8:stunHeaderLen], packet[stunHeaderLen+4:stunHeaderLen+4+attrLen])
+}
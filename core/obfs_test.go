@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestObfuscateRoundTrip(t *testing.T) {
+	// 服务端接受全部方式，按客户端使用的方式还原
+	server, err := NewEngine(SecurityConfig{PSK: "secret", Obfs: []string{"mask", "stun", "none"}, ObfsPad: 32}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"none", "mask", "stun"} {
+		for _, pad := range []int{0, 32} {
+			client, err := NewEngine(SecurityConfig{PSK: "secret", Obfs: []string{name}, ObfsPad: pad}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, size := range []int{0, 1, 3, 100, 1400} {
+				t.Run(fmt.Sprintf("%s/填充%d/%d字节", name, pad, size), func(t *testing.T) {
+					packet := bytes.Repeat([]byte{0xA5}, size)
+					wrapped := client.Obfuscate(0, bytes.Clone(packet))
+					if len(wrapped) > size+client.ObfsOverhead() {
+						t.Fatalf("混淆后 %d 字节，超过最大开销 %d", len(wrapped), size+client.ObfsOverhead())
+					}
+
+					inner, kind, ok := server.Deobfuscate(wrapped)
+					if !ok || !bytes.Equal(inner, packet) {
+						t.Fatalf("还原失败: %v，%x", ok, inner)
+					}
+					if server.ObfsName(kind) != name {
+						t.Fatalf("识别的混淆方式 %s，应为 %s", server.ObfsName(kind), name)
+					}
+
+					// 服务端使用识别出的方式回复，客户端能还原
+					if back, _, ok := client.Deobfuscate(server.Obfuscate(kind, bytes.Clone(packet))); !ok || !bytes.Equal(back, packet) {
+						t.Fatalf("回复还原失败: %v，%x", ok, back)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestDeobfuscateRejected(t *testing.T) {
+	server, err := NewEngine(SecurityConfig{PSK: "secret", Obfs: []string{"mask", "stun"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  SecurityConfig
+	}{
+		{"未混淆", SecurityConfig{PSK: "secret"}},
+		{"mask密钥不同", SecurityConfig{PSK: "secret", Obfs: []string{"mask"}, ObfsKey: "other"}},
+		{"stun密钥不同", SecurityConfig{PSK: "other", Obfs: []string{"stun"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewEngine(tt.cfg, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, ok := server.Deobfuscate(client.Obfuscate(0, []byte("0123456789abcdef"))); ok {
+				t.Fatal("服务端还原了不允许的包")
+			}
+		})
+	}
+}
+
+func TestStunFormat(t *testing.T) {
+	client, err := NewEngine(SecurityConfig{PSK: "secret", Obfs: []string{"stun"}, ObfsPad: 7}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for size := range 8 {
+		packet := client.Obfuscate(0, make([]byte, size))
+		if len(packet)%4 != 0 {
+			t.Fatalf("%d 字节的包混淆后长度 %d，没有按4字节对齐", size, len(packet))
+		}
+		if msgType := binary.BigEndian.Uint16(packet); msgType != 0x0001 {
+			t.Fatalf("消息类型 %#x，应为Binding Request", msgType)
+		}
+		if msgLen := int(binary.BigEndian.Uint16(packet[2:])); msgLen != len(packet)-stunHeaderLen {
+			t.Fatalf("消息长度 %d，应为 %d", msgLen, len(packet)-stunHeaderLen)
+		}
+		if cookie := binary.BigEndian.Uint32(packet[4:]); cookie != stunMagicCookie {
+			t.Fatalf("Magic Cookie %#x，应为 %#x", cookie, stunMagicCookie)
+		}
+		if attr := binary.BigEndian.Uint16(packet[stunHeaderLen:]); attr != stunAttrData {
+			t.Fatalf("属性类型 %#x，应为DATA", attr)
+		}
+	}
+}
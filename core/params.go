@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ServerParams 服务端下发给客户端的参数，是对客户端参数请求（携带客户端能力）的回复
+// 客户端只需要知道服务端的一个地址与认证信息，其余参数在会话建立后向服务端获取
+type ServerParams struct {
+	// 服务端的能力
+	Hello
+
+	// 服务端拒绝客户端的原因，为空表示协商成功
+	Refused string `json:"refused,omitempty"`
+
+	// 服务端的监听地址，与服务端-l顺序一致；主机部分为空时客户端使用连接服务端的地址
+	Endpoints []string `json:"endpoints"`
+
+	// 建议的保活间隔（毫秒），0表示服务端不检查链路超时
+	KeepaliveMs int64 `json:"keepalive_ms"`
+}
+
+// Keepalive 返回建议的保活间隔
+func (params *ServerParams) Keepalive() time.Duration {
+	return time.Duration(params.KeepaliveMs) * time.Millisecond
+}
+
+// NewConfigRequest 生成参数请求（负载为客户端能力的带认证控制帧），会话尚未建立时返回nil
+// 参数请求与下发只认证不加密，两端加密算法不一致时服务端仍然可以读出客户端能力并回复拒绝原因
+func (e *Engine) NewConfigRequest(hello Hello) ([]byte, error) {
+	payload, err := json.Marshal(hello)
+	if err != nil {
+		return nil, err
+	}
+	return e.sealPlainControl(ControlConfigRequest, payload), nil
+}
+
+// OpenConfigRequest 服务端校验参数请求并解析客户端能力
+// 认证失败时ok为false；认证通过但能力无法解析时返回错误
+func (e *Engine) OpenConfigRequest(buf []byte) (frame Frame, hello Hello, ok bool, err error) {
+	if frame, ok = e.openPlainControl(buf); !ok {
+		return
+	}
+	if err = json.Unmarshal(frame.Payload, &hello); err != nil {
+		err = fmt.Errorf("解析客户端能力失败: %v", err)
+	}
+	return
+}
+
+// NewConfigResponse 服务端生成参数下发帧，会话尚未建立时返回nil
+func (e *Engine) NewConfigResponse(params *ServerParams) ([]byte, error) {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return e.sealPlainControl(ControlConfig, payload), nil
+}
+
+// OpenConfigResponse 客户端校验并解析参数下发帧
+func (e *Engine) OpenConfigResponse(buf []byte) (*ServerParams, error) {
+	frame, ok := e.openPlainControl(buf)
+	if !ok {
+		return nil, fmt.Errorf("认证失败")
+	}
+
+	params := &ServerParams{}
+	if err := json.Unmarshal(frame.Payload, params); err != nil {
+		return nil, fmt.Errorf("解析参数失败: %v", err)
+	}
+	return params, nil
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶限速器
+type TokenBucket struct {
+	// 每秒补充的令牌数
+	rate float64
+
+	// 令牌桶容量
+	burst float64
+
+	// 当前令牌数与上次补充时间
+	tokens float64
+	last   time.Time
+
+	mutex sync.Mutex
+}
+
+// NewTokenBucket 创建令牌桶，初始为满
+func NewTokenBucket(rate float64, burst float64) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Allow 尝试取出n个令牌，令牌不足时返回false
+func (b *TokenBucket) Allow(n float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+
+	b.tokens -= n
+	return true
+}
+
+// Has 判断当前是否有n个令牌，不取出
+// 与Allow配合可以在多个令牌桶都满足时才一起取出，调用方需要保证两次调用之间没有其它取出方
+func (b *TokenBucket) Has(n float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	return b.tokens >= n
+}
+
+// SetRate 修改令牌桶的速率与容量，当前令牌数超过新容量时截断
+func (b *TokenBucket) SetRate(rate float64, burst float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.rate = rate
+	b.burst = burst
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+}
@@ -0,0 +1,46 @@
+package core
+
+import "sync"
+
+// 抗重放窗口大小（帧数），需要容纳多条链路之间的时延差造成的乱序
+const replayWindowSize = 8192
+
+// replayWindow 一个会话接收方向的抗重放滑动窗口
+// 记录收到过的最大计数器以及它之前replayWindowSize个计数器是否收到过，
+// 比窗口更旧或已经收到过的帧被丢弃；计数器只在认证通过后记录，伪造的帧无法推动窗口
+type replayWindow struct {
+	mutex sync.Mutex
+
+	// 收到过的最大计数器，0表示还没有收到过
+	top uint64
+
+	// 以计数器对窗口大小取模为下标的位图
+	bitmap [replayWindowSize / 64]uint64
+}
+
+// accept 判断计数器是否为新的帧，是时记录下来
+func (w *replayWindow) accept(counter uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if counter > w.top {
+		// 窗口前移，清除新进入窗口的位置
+		if w.top == 0 || counter-w.top >= replayWindowSize {
+			w.bitmap = [replayWindowSize / 64]uint64{}
+		} else {
+			for next := w.top + 1; next < counter; next++ {
+				w.bitmap[(next%replayWindowSize)/64] &^= 1 << (next % 64)
+			}
+		}
+		w.top = counter
+	} else if w.top-counter >= replayWindowSize {
+		// 比窗口更旧，无法判断是否收到过
+		return false
+	} else if w.bitmap[(counter%replayWindowSize)/64]&(1<<(counter%64)) != 0 {
+		// 已经收到过
+		return false
+	}
+
+	w.bitmap[(counter%replayWindowSize)/64] |= 1 << (counter % 64)
+	return true
+}
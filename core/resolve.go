@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 端口范围最多包含的端口数量
+const MaxPortRange = 1024
+
+// ResolveNetwork 把地址族配置转换为解析与创建套接字使用的网络类型
+// any（或空）表示A与AAAA记录都可以，4只使用A记录，6只使用AAAA记录
+func ResolveNetwork(family string) (string, error) {
+	switch strings.TrimSpace(family) {
+	case "", "any":
+		return "udp", nil
+	case "4", "ipv4":
+		return "udp4", nil
+	case "6", "ipv6":
+		return "udp6", nil
+	}
+	return "", fmt.Errorf("不支持的地址族: %s（可选any、4、6）", family)
+}
+
+// IsHostname 判断地址的主机部分是否为域名，域名需要定期重新解析
+func IsHostname(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return len(host) > 0 && net.ParseIP(host) == nil
+}
+
+// SameUDPAddr 判断两个地址的IP与端口是否相同
+func SameUDPAddr(a *net.UDPAddr, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// ParsePortRange 解析端口或端口范围，例如 9000 或 9000-9009
+func ParsePortRange(port string) (int, int, error) {
+	first, last, isRange := strings.Cut(port, "-")
+	if !isRange {
+		last = first
+	}
+
+	min, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的端口: %s", port)
+	}
+	max, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || max < min {
+		return 0, 0, fmt.Errorf("无效的端口范围: %s", port)
+	}
+
+	if max-min+1 > MaxPortRange || (isRange && min == 0) {
+		return 0, 0, fmt.Errorf("端口范围 %s 无效（不能包含0，最多 %d 个端口）", port, MaxPortRange)
+	}
+	return int(min), int(max), nil
+}
+
+// ParseCIDRList 解析网段列表，不带掩码的地址视为单个主机
+func ParseCIDRList(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的地址: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的网段: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
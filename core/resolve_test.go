@@ -0,0 +1,34 @@
+package core
+
+import "testing"
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		port     string
+		min, max int
+		ok       bool
+	}{
+		{"9000", 9000, 9000, true},
+		{"9000-9009", 9000, 9009, true},
+		{"0", 0, 0, true},
+		{"9000-9000", 9000, 9000, true},
+		{"9009-9000", 0, 0, false},
+		{"0-10", 0, 0, false},
+		{"9000-10023", 9000, 10023, true},
+		{"9000-10024", 0, 0, false},
+		{"65536", 0, 0, false},
+		{"abc", 0, 0, false},
+		{"9000-", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		min, max, err := ParsePortRange(tt.port)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParsePortRange(%q) 返回错误 %v，是否应成功: %v", tt.port, err, tt.ok)
+			continue
+		}
+		if min != tt.min || max != tt.max {
+			t.Errorf("ParsePortRange(%q) = %d-%d，应为 %d-%d", tt.port, min, max, tt.min, tt.max)
+		}
+	}
+}
@@ -1,59 +1,433 @@
 package main
 
 import (
-	"UDPRainbowBridge/client"
-	"UDPRainbowBridge/server"
+	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 )
 
+// 以;或,分割的命令行参数
+type list_flags struct {
+	r             string
+	l             string
+	send          string
+	family        string
+	allowKeys     string
+	allowCIDR     string
+	allowCIDRLink string
+	obfs          string
+	discover      string
+	advertise     string
+}
+
+// 子命令
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"server", "运行服务端：在多个端口上接收客户端的链路，去重后转发到本地服务", func(args []string) error { return run_bridge("server", args) }},
+	{"client", "运行客户端：在本地端口接收应用的包，通过多条链路发送到服务端", func(args []string) error { return run_bridge("client", args) }},
+	{"run", "按配置文件运行，配置文件可以包含多个实例（客户端、服务端都可以），共用一个管理接口", func(args []string) error { return run_bridge("run", args) }},
+	{"ping", "测量经过桥接的往返时延与丢包，-echo 在另一端运行回显服务", run_ping},
+	{"bench", "以固定速率压测经过桥接的吞吐、丢包与时延，需要另一端运行 ping -echo", run_bench},
+	{"status", "通过管理接口查询运行中的实例的链路、会话与协商状态", run_status},
+	{"keygen", "生成一对X25519密钥（握手模式使用）", run_keygen},
+	{"validate", "检查配置并解析全部地址、检查网卡与端口是否可用，不发送任何流量", run_validate},
+}
+
 func main() {
-	var s bool
-	var c bool
-	var m int
-	var mode string
-	var r string
-	var l string
-	var send string
-
-	// 规划参数：将ip与端口统一，且重复类型参数只留一个
-	// 转发地址，参数名称：r 参数值示例：192.168.2.3:8080;192.168.2.110:8080
-	// 监听地址，参数名称: l 参数值示例：0.0.0.0:9000;0.0.0.0:90001:192.168.2.3:9002
-	// 发送地址（客户端用，local地址）， 参数名称：send 参数值192.168.100.1;192.168.99.1  不用带端口！！
-	// -s 服务端模式
-	// -c 客户端模式
-	// -m mtu值设置
-	// -mode 模式选择 mode1: 多倍发包模式，mode2:链路聚合模式
-	flag.BoolVar(&s, "s", false, "服务端模式")
-	flag.BoolVar(&c, "c", false, "客户端模式")
-	flag.StringVar(&mode, "mode", "mode1", "mode1: 多倍发包模式，mode2:链路聚合模式")
-	flag.IntVar(&m, "mtu", 1492, "可选，mtu，最大包体支持，默认：1492")
-
-	flag.StringVar(&r, "r", "", "转发地址 服务端此参数只能有一个地址，客户端多个 参数值示例：192.168.2.3:8080;192.168.2.110:8080")
-	flag.StringVar(&l, "l", "", "监听地址 服务端此参数有多个，客户端单个 参数值示例：0.0.0.0:9000;0.0.0.0:90001:192.168.2.3:9002")
-	flag.StringVar(&send, "send", "", "发送地址 客户端用 参数值192.168.100.1:0;192.168.99.1:0  自动选择发送端口请指定端口为0！！")
-
-	flag.Parse()
-
-	// 解析地址参数 使用;分割地址
-	// 转发地址
-	remote_ip_list := strings.Split(r, ";")
-	// 监听地址
-	listen_ip_list := strings.Split(l, ";")
-
-	if s {
-		// 服务器模式
-		server.Start(remote_ip_list, listen_ip_list, m, mode)
-	} else if c {
-		// 客户端模式
-
-		// 发送地址
-		client_local_ip_list := strings.Split(send, ";")
-
-		client.Start(remote_ip_list, listen_ip_list, client_local_ip_list, m, mode)
-	}
-
-	// 没有输入参数
-	fmt.Println("无效模式")
+	if len(os.Args) < 2 {
+		print_usage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+
+	// 兼容旧的 -s、-c 参数
+	if strings.HasPrefix(name, "-") {
+		name, args = legacy_command(os.Args[1:])
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(args)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if name != "help" && name != "-h" && name != "-help" {
+		fmt.Printf("未知的子命令 %q\n", name)
+	}
+	print_usage()
+	if name != "help" {
+		os.Exit(2)
+	}
+}
+
+// 输出子命令列表
+func print_usage() {
+	fmt.Println("用法：UDPRainbowBridge <子命令> [参数]")
+	fmt.Println()
+	fmt.Println("子命令：")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Println()
+	fmt.Println("使用 UDPRainbowBridge <子命令> -h 查看子命令的参数")
+}
+
+// 旧的调用方式：-s、-c 选择角色，-genkey 生成密钥，其余参数原样交给对应的子命令
+func legacy_command(args []string) (string, []string) {
+	name := ""
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--s":
+			name = "server"
+		case "-c", "--c":
+			name = "client"
+		case "-genkey", "--genkey":
+			name = "keygen"
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if len(name) > 0 {
+		fmt.Printf("提示：-s、-c、-genkey 已改为子命令，请使用 UDPRainbowBridge %s [参数]\n", name)
+	}
+	return name, rest
+}
+
+// 创建子命令的参数集合，-h 时输出子命令说明与参数
+func new_flag_set(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "用法：UDPRainbowBridge %s [参数]\n%s\n\n参数：\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// 定义服务端或客户端的参数，参数先写入一份默认配置，只有显式指定的参数才覆盖配置文件
+func bridge_flags(flags *flag.FlagSet, role string, opt *Config, lists *list_flags, configPath *string) {
+	if role == "run" {
+		flags.StringVar(configPath, "config", "", "JSON配置文件，可以包含多个实例")
+		flags.StringVar(&opt.Observability.Admin, "admin", "", "可选，管理接口监听地址（TCP），覆盖配置文件中的 observability.admin 参数值示例：127.0.0.1:9100")
+		return
+	}
+
+	// 两端共用的参数
+	flags.StringVar(configPath, "config", "", "可选，JSON配置文件，命令行参数会覆盖配置文件中的对应项")
+	flags.IntVar(&opt.MTU, "mtu", opt.MTU, "可选，最大包体")
+	flags.StringVar(&opt.Security.PSK, "psk", "", "可选，预共享密钥，设置后对每个帧进行HMAC认证，两端必须一致")
+	flags.StringVar(&opt.Security.Cipher, "cipher", opt.Security.Cipher, "可选，负载加密算法：none、chacha20-poly1305、aes-256-gcm，需要配合-psk或握手使用")
+	flags.StringVar(&opt.Security.Key, "key", "", "可选，本端X25519私钥（base64），设置后启用握手协商会话密钥")
+	flags.StringVar(&opt.Security.ObfsKey, "obfs-key", "", "可选，混淆密钥，两端必须一致，为空时由-psk派生")
+	flags.IntVar(&opt.Security.ObfsPad, "obfs-pad", opt.Security.ObfsPad, "可选，混淆时随机填充的最大字节数")
+	flags.DurationVar((*time.Duration)(&opt.Observability.StatsInterval), "stats-interval", time.Duration(opt.Observability.StatsInterval), "可选，统计日志的输出间隔，0表示不输出")
+	flags.StringVar(&opt.Observability.Admin, "admin", "", "可选，管理接口监听地址（TCP，建议只监听本机），支持 reload（重新加载配置文件）与 status 参数值示例：127.0.0.1:9100")
+
+	if role == "server" {
+		flags.StringVar(&opt.Mode, "mode", opt.Mode, "默认的下行模式，mode1：多倍发包模式，mode2：链路聚合模式，客户端可以在协商时请求其他模式")
+		flags.StringVar(&lists.l, "l", "", "监听地址，多个用;分割，端口可以写成范围 参数值示例：0.0.0.0:9000;[::]:9001;0.0.0.0:9002-9009")
+		flags.StringVar(&lists.r, "r", "", "转发地址，只能有一个 参数值示例：127.0.0.1:51820")
+		flags.StringVar(&lists.family, "family", "", "可选，转发地址解析使用的地址族，any：A与AAAA记录都可以，4：只用A记录，6：只用AAAA记录")
+		flags.DurationVar((*time.Duration)(&opt.ResolveInterval), "resolve-interval", time.Duration(opt.ResolveInterval), "可选，转发地址为域名时重新解析的间隔，解析结果变化时自动重建套接字，0表示只在启动时解析")
+		flags.StringVar(&lists.advertise, "advertise", "", "可选，下发给客户端的监听地址，多个用;分割并与-l顺序一致，为空时使用-l（监听所有地址时客户端使用连接服务端的主机） 参数值示例：bridge.example.com:9000;203.0.113.7:9001-9009")
+		flags.StringVar(&lists.allowKeys, "allow-keys", "", "握手模式下允许接入的客户端公钥（base64），多个用;分割")
+		flags.StringVar(&lists.obfs, "obfs", "none", "可选，允许的流量混淆方式：none、mask、stun，多个用,分割")
+		flags.Float64Var(&opt.Admission.UnvalidatedPPS, "unvalidated-pps", opt.Admission.UnvalidatedPPS, "客户端地址通过验证前每个监听端口每秒最多发送的包数")
+		flags.StringVar(&lists.allowCIDR, "allow-cidr", "", "可选，全局允许的来源网段，多个用,分割 参数值示例：10.0.0.0/8,192.168.1.5")
+		flags.StringVar(&lists.allowCIDRLink, "allow-cidr-link", "", "可选，每个监听端口允许的来源网段，端口间用;分割并与-l顺序一致，端口内用,分割 参数值示例：10.0.0.0/8;;192.168.0.0/16")
+		flags.Float64Var(&opt.Admission.RatePPS, "rate-pps", 0, "可选，每个来源IP每秒最多接收的包数，0表示不限制")
+		flags.Float64Var(&opt.Admission.RateBPS, "rate-bps", 0, "可选，每个来源IP每秒最多接收的字节数，0表示不限制")
+		flags.DurationVar((*time.Duration)(&opt.LinkTimeout), "link-timeout", time.Duration(opt.LinkTimeout), "超过该时间没有收到某条链路的包就认为NAT映射已失效，暂停在该链路上发送，0表示不检查")
+		return
+	}
+
+	flags.StringVar(&opt.Mode, "mode", opt.Mode, "上行模式，mode1：多倍发包模式，mode2：链路聚合模式")
+	flags.StringVar(&opt.DownMode, "down-mode", "", "可选，请求服务端在本会话中使用的下行模式（mode1、mode2），为空时使用服务端的-mode")
+	flags.StringVar(&lists.l, "l", "", "本地监听地址，应用程序把数据发到这里 参数值示例：127.0.0.1:8000")
+	flags.StringVar(&lists.r, "r", "", "服务端地址，多个用;分割，与-send按顺序配对成链路 参数值示例：192.168.2.3:9000;[2001:db8::1]:9001")
+	flags.StringVar(&lists.send, "send", "", "发送地址，多个用;分割 参数值示例：192.168.100.1:0;[2001:db8::2]:0;[fe80::1%wwan0]:0 或网卡名称eth0;wwan0:5000，自动选择发送端口请指定端口为0")
+	flags.StringVar(&lists.family, "family", "", "可选，每个服务端地址解析使用的地址族，any：A与AAAA记录都可以，4：只用A记录，6：只用AAAA记录，多个用;分割并与链路顺序一致 参数值示例：4;6")
+	flags.StringVar(&opt.Server, "server", "", "可选，服务端地址，设置后从服务端获取监听地址、MTU与保活间隔等参数，不再需要-r 参数值示例：bridge.example.com:9000")
+	flags.StringVar(&opt.Security.PeerKey, "peer-key", "", "握手模式下服务端的公钥（base64）")
+	flags.DurationVar((*time.Duration)(&opt.Security.Rekey), "rekey", time.Duration(opt.Security.Rekey), "握手模式下的换钥间隔")
+	flags.Uint64Var(&opt.Security.RekeyBytes, "rekey-bytes", opt.Security.RekeyBytes, "握手模式下单个会话最多发送的字节数，超过后换钥，0表示不限制")
+	flags.StringVar(&lists.obfs, "obfs", "none", "可选，流量混淆方式：none、mask、stun")
+	flags.DurationVar((*time.Duration)(&opt.LinkCheck), "link-check", time.Duration(opt.LinkCheck), "链路检查间隔，本地地址消失或变化时自动重建链路，0表示不检查")
+	flags.StringVar(&lists.discover, "discover", "", "可选，自动发现已启用的非回环网卡并为每个网卡创建链路，值为网卡名称匹配模式，多个用,分割 参数值示例：*、wwan*,usb*")
+	flags.DurationVar((*time.Duration)(&opt.ResolveInterval), "resolve-interval", time.Duration(opt.ResolveInterval), "可选，服务端地址为域名时重新解析的间隔，解析结果变化时自动重建链路，0表示只在创建与重建链路时解析")
+	flags.DurationVar((*time.Duration)(&opt.Hop), "hop", 0, "可选，端口跳变间隔，定期更换链路的源端口与目标端口（在-r与-send的端口范围内选择，服务端-l需要监听相同范围），0表示不跳变")
+	flags.DurationVar((*time.Duration)(&opt.Keepalive), "keepalive", time.Duration(opt.Keepalive), "每条链路空闲超过该时间时发送保活帧，维持运营商NAT映射，0表示不发送")
+}
+
+// 解析服务端或客户端的参数，返回生成配置需要的输入
+func parse_bridge_flags(role string, args []string) (config_source, error) {
+	var configPath string
+	var lists list_flags
+	opt := default_config()
+
+	usage := "运行服务端"
+	if role == "client" {
+		usage = "运行客户端"
+	} else if role == "run" {
+		usage = "按配置文件运行，运行角色由配置文件决定，配置文件可以包含多个实例"
+	}
+	flags := new_flag_set(role, usage)
+	bridge_flags(flags, role, opt, &lists, &configPath)
+	if err := flags.Parse(args); err != nil {
+		return config_source{}, err
+	}
+	if flags.NArg() > 0 {
+		return config_source{}, fmt.Errorf("多余的参数：%v", flags.Args())
+	}
+
+	// 显式指定的命令行参数覆盖配置文件，运行角色由子命令决定（run 由配置文件决定）
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if role == "run" {
+		if len(configPath) == 0 {
+			flags.Usage()
+			return config_source{}, errors.New("run 需要 -config")
+		}
+	} else {
+		set["role"] = true
+		opt.Role = role
+	}
+
+	return config_source{path: configPath, opt: opt, lists: lists, set: set}, nil
+}
+
+// 运行服务端或客户端
+func run_bridge(role string, args []string) error {
+	source, err := parse_bridge_flags(role, args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := source.load()
+	if err != nil {
+		return err
+	}
+
+	// 逐个启动实例，任意一个启动失败时退出
+	process := &bridge_process{source: source, running: cfg}
+	for _, instance_cfg := range cfg.instance_configs() {
+		instance, err := start_instance(instance_cfg)
+		if err != nil {
+			if len(instance_cfg.Name) > 0 {
+				return fmt.Errorf("启动实例 %s 失败: %v", instance_cfg.Name, err)
+			}
+			return err
+		}
+		process.instances = append(process.instances, instance)
+	}
+
+	// 收到SIGHUP或管理命令时重新加载配置，管理接口覆盖全部实例
+	go watch_reload_signal(process)
+	if len(cfg.Observability.Admin) > 0 {
+		if err := start_admin(cfg.Observability.Admin, process); err != nil {
+			return fmt.Errorf("启动管理接口失败: %v", err)
+		}
+	}
+
+	// 收到SIGINT或SIGTERM时平滑停止全部实例后退出
+	wait_shutdown_signal(process)
+	return nil
+}
+
+// 生成配置需要的输入：配置文件路径与显式指定的命令行参数，重新加载时使用相同的输入
+type config_source struct {
+	path  string
+	opt   *Config
+	lists list_flags
+	set   map[string]bool
+}
+
+// 读取配置文件（没有配置文件时从默认配置开始），用命令行参数覆盖后检查全部配置
+func (source config_source) load() (*Config, error) {
+	cfg := default_config()
+	if len(source.path) > 0 {
+		var err error
+		if cfg, err = load_config(source.path); err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %v", err)
+		}
+	}
+
+	// 运行角色由子命令决定，配置文件写了不同的角色时多半是用错了文件
+	if source.set["role"] {
+		if len(cfg.Instances) > 0 {
+			return nil, fmt.Errorf("配置文件包含多个实例，请使用 run 子命令运行")
+		}
+		if len(cfg.Role) > 0 && cfg.Role != source.opt.Role {
+			return nil, fmt.Errorf("配置文件的 role 为 %s，与子命令 %s 不一致", cfg.Role, source.opt.Role)
+		}
+		cfg.Role = source.opt.Role
+	}
+	if err := override_config(cfg, source.opt, source.lists, source.set); err != nil {
+		return nil, fmt.Errorf("参数错误: %v", err)
+	}
+
+	// 启动之前检查全部配置
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("配置错误:\n%v", err)
+	}
+	return cfg, nil
+}
+
+// 按分隔符拆分列表参数，去掉空白项
+func split_list(value string, sep string) []string {
+	var list []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 用显式指定的命令行参数覆盖配置
+func override_config(cfg *Config, opt *Config, lists list_flags, set map[string]bool) error {
+	// 单值参数直接覆盖
+	overrides := map[string]func(){
+		"mode":             func() { cfg.Mode = opt.Mode },
+		"down-mode":        func() { cfg.DownMode = opt.DownMode },
+		"mtu":              func() { cfg.MTU = opt.MTU },
+		"server":           func() { cfg.Server = opt.Server },
+		"psk":              func() { cfg.Security.PSK = opt.Security.PSK },
+		"cipher":           func() { cfg.Security.Cipher = opt.Security.Cipher },
+		"key":              func() { cfg.Security.Key = opt.Security.Key },
+		"peer-key":         func() { cfg.Security.PeerKey = opt.Security.PeerKey },
+		"rekey":            func() { cfg.Security.Rekey = opt.Security.Rekey },
+		"rekey-bytes":      func() { cfg.Security.RekeyBytes = opt.Security.RekeyBytes },
+		"obfs-key":         func() { cfg.Security.ObfsKey = opt.Security.ObfsKey },
+		"obfs-pad":         func() { cfg.Security.ObfsPad = opt.Security.ObfsPad },
+		"unvalidated-pps":  func() { cfg.Admission.UnvalidatedPPS = opt.Admission.UnvalidatedPPS },
+		"rate-pps":         func() { cfg.Admission.RatePPS = opt.Admission.RatePPS },
+		"rate-bps":         func() { cfg.Admission.RateBPS = opt.Admission.RateBPS },
+		"link-check":       func() { cfg.LinkCheck = opt.LinkCheck },
+		"resolve-interval": func() { cfg.ResolveInterval = opt.ResolveInterval },
+		"hop":              func() { cfg.Hop = opt.Hop },
+		"keepalive":        func() { cfg.Keepalive = opt.Keepalive },
+		"link-timeout":     func() { cfg.LinkTimeout = opt.LinkTimeout },
+		"stats-interval":   func() { cfg.Observability.StatsInterval = opt.Observability.StatsInterval },
+		"admin":            func() { cfg.Observability.Admin = opt.Observability.Admin },
+		"allow-keys":       func() { cfg.Security.AllowKeys = split_list(lists.allowKeys, ";") },
+		"obfs":             func() { cfg.Security.Obfs = split_list(lists.obfs, ",") },
+		"allow-cidr":       func() { cfg.Admission.AllowCIDR = split_list(lists.allowCIDR, ",") },
+		"discover":         func() { cfg.Discover = split_list(lists.discover, ",") },
+	}
+	for name, override := range overrides {
+		if set[name] {
+			override()
+		}
+	}
+
+	// 只有一个隧道，-l（客户端）与-r（服务端）修改它
+	if len(cfg.Tunnels) == 0 && (set["l"] || set["r"]) {
+		cfg.Tunnels = []TunnelConfig{{}}
+	}
+
+	if cfg.Role == "server" {
+		return override_server_links(cfg, lists, set)
+	}
+	return override_client_links(cfg, lists, set)
+}
+
+// 客户端：-r与-send按顺序配对生成链路，-family按顺序对应链路
+func override_client_links(cfg *Config, lists list_flags, set map[string]bool) error {
+	if set["l"] {
+		cfg.Tunnels[0].Listen = strings.TrimSpace(lists.l)
+	}
+
+	if set["r"] || set["send"] {
+		remotes := split_list(lists.r, ";")
+		locals := split_list(lists.send, ";")
+
+		// 数量相同时按顺序配对，一方只有一个地址时与另一方的每个地址配对
+		count := max(len(remotes), len(locals))
+		if len(remotes) > 1 && len(locals) > 1 && len(remotes) != len(locals) {
+			return fmt.Errorf("-r 有 %d 个地址，-send 有 %d 个地址，数量需要相同（或其中一个只有一个地址）", len(remotes), len(locals))
+		}
+
+		cfg.Links = make([]LinkConfig, count)
+		for index := range cfg.Links {
+			if len(remotes) > 0 {
+				cfg.Links[index].Remote = remotes[min(index, len(remotes)-1)]
+			}
+			if len(locals) > 0 {
+				cfg.Links[index].Local = locals[min(index, len(locals)-1)]
+			}
+		}
+	}
+
+	if set["family"] {
+		families := strings.Split(lists.family, ";")
+		if len(families) > 1 && len(families) != len(cfg.Links) {
+			return fmt.Errorf("-family 有 %d 项，链路有 %d 条，数量需要相同（或只写一项）", len(families), len(cfg.Links))
+		}
+		for index := range cfg.Links {
+			cfg.Links[index].Family = strings.TrimSpace(families[min(index, len(families)-1)])
+		}
+	}
+	return nil
+}
+
+// 服务端：-l生成监听端口，-advertise与-allow-cidr-link按顺序对应监听端口，-r与-family修改转发地址
+func override_server_links(cfg *Config, lists list_flags, set map[string]bool) error {
+	if set["r"] {
+		cfg.Tunnels[0].Forward = strings.TrimSpace(lists.r)
+	}
+	if set["family"] && len(cfg.Tunnels) > 0 {
+		cfg.Tunnels[0].ForwardFamily = strings.TrimSpace(lists.family)
+	}
+
+	if set["l"] {
+		cfg.Links = nil
+		for _, listen := range split_list(lists.l, ";") {
+			cfg.Links = append(cfg.Links, LinkConfig{Listen: listen})
+		}
+	}
+
+	if set["advertise"] {
+		endpoints := split_list(lists.advertise, ";")
+		if len(endpoints) > 0 && len(endpoints) != len(cfg.Links) {
+			return fmt.Errorf("-advertise 有 %d 个地址，监听地址有 %d 个，数量需要相同", len(endpoints), len(cfg.Links))
+		}
+		for index := range cfg.Links {
+			cfg.Links[index].Advertise = ""
+			if len(endpoints) > 0 {
+				cfg.Links[index].Advertise = endpoints[index]
+			}
+		}
+	}
+
+	if set["allow-cidr-link"] {
+		groups := strings.Split(lists.allowCIDRLink, ";")
+		if len(groups) > len(cfg.Links) {
+			return fmt.Errorf("-allow-cidr-link 有 %d 组，监听地址只有 %d 个", len(groups), len(cfg.Links))
+		}
+		for index, group := range groups {
+			cfg.Links[index].AllowCIDR = split_list(group, ",")
+		}
+	}
+	return nil
 }
@@ -0,0 +1,74 @@
+// Package queue 链路发送队列：有界的多生产者、单消费者队列
+//
+// 生产者（编码线程，嵌入运行时可能有多个）放入数据包，每条链路一个发送线程取出发送。
+// 队列为空时发送线程阻塞等待，队列满时生产者阻塞等待，都由放入或取出立即唤醒，不需要轮询；
+// 关闭后不再接受新的数据包，阻塞的生产者立即返回，发送线程取完剩余的数据包后返回。
+package queue
+
+import "sync"
+
+// Queue 有界的数据包队列，可以被多个线程同时使用
+type Queue struct {
+	// 排队中的数据包
+	packets chan []byte
+
+	// 关闭信号
+	closed chan struct{}
+
+	// 保证只关闭一次
+	closeOnce sync.Once
+}
+
+// New 创建最多容纳capacity个数据包的队列
+func New(capacity int) *Queue {
+	return &Queue{
+		packets: make(chan []byte, capacity),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Push 放入一个数据包，队列满时等待发送线程取出；队列已关闭时丢弃并返回false
+func (q *Queue) Push(packet []byte) bool {
+	// 已关闭时不再放入，即使队列还有空位
+	select {
+	case <-q.closed:
+		return false
+	default:
+	}
+
+	select {
+	case q.packets <- packet:
+		return true
+	case <-q.closed:
+		return false
+	}
+}
+
+// Pop 取出一个数据包，队列为空时等待；队列关闭后继续取出剩余的数据包，取完后返回false
+func (q *Queue) Pop() ([]byte, bool) {
+	select {
+	case packet := <-q.packets:
+		return packet, true
+	case <-q.closed:
+	}
+
+	// 已关闭：生产者不会再放入，只取剩余的数据包
+	select {
+	case packet := <-q.packets:
+		return packet, true
+	default:
+		return nil, false
+	}
+}
+
+// Len 返回排队中的数据包数量
+func (q *Queue) Len() int {
+	return len(q.packets)
+}
+
+// Close 关闭队列：之后的Push返回false，唤醒阻塞中的生产者与发送线程，已放入的数据包仍可以取出；可以重复调用
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.closed)
+	})
+}
@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 判断阻塞调用在等待时间内没有返回
+const blockedWait = 50 * time.Millisecond
+
+// 判断被唤醒的调用在等待时间内返回
+const wakeWait = time.Second
+
+func TestPushBlocksUntilPop(t *testing.T) {
+	q := New(1)
+	if !q.Push([]byte{1}) {
+		t.Fatal("队列未满时Push失败")
+	}
+
+	pushed := make(chan bool)
+	go func() { pushed <- q.Push([]byte{2}) }()
+
+	select {
+	case <-pushed:
+		t.Fatal("队列已满时Push没有阻塞")
+	case <-time.After(blockedWait):
+	}
+
+	if packet, ok := q.Pop(); !ok || packet[0] != 1 {
+		t.Fatalf("Pop = %v, %v，应为 [1], true", packet, ok)
+	}
+	select {
+	case ok := <-pushed:
+		if !ok {
+			t.Fatal("被Pop唤醒的Push返回false")
+		}
+	case <-time.After(wakeWait):
+		t.Fatal("Pop之后阻塞的Push没有被唤醒")
+	}
+	if packet, ok := q.Pop(); !ok || packet[0] != 2 {
+		t.Fatalf("Pop = %v, %v，应为 [2], true", packet, ok)
+	}
+}
+
+func TestPopBlocksUntilPush(t *testing.T) {
+	q := New(4)
+
+	popped := make(chan []byte)
+	go func() {
+		packet, _ := q.Pop()
+		popped <- packet
+	}()
+
+	select {
+	case <-popped:
+		t.Fatal("队列为空时Pop没有阻塞")
+	case <-time.After(blockedWait):
+	}
+
+	q.Push([]byte{7})
+	select {
+	case packet := <-popped:
+		if len(packet) != 1 || packet[0] != 7 {
+			t.Fatalf("Pop = %v，应为 [7]", packet)
+		}
+	case <-time.After(wakeWait):
+		t.Fatal("Push之后阻塞的Pop没有被唤醒")
+	}
+}
+
+func TestCloseWakesBlocked(t *testing.T) {
+	tests := []struct {
+		name string
+		// 放入数据包使调用阻塞，返回阻塞调用的结果
+		blocked func(q *Queue) <-chan bool
+	}{
+		{"Push", func(q *Queue) <-chan bool {
+			q.Push([]byte{1})
+			result := make(chan bool)
+			go func() { result <- q.Push([]byte{2}) }()
+			return result
+		}},
+		{"Pop", func(q *Queue) <-chan bool {
+			result := make(chan bool)
+			go func() {
+				_, ok := q.Pop()
+				result <- ok
+			}()
+			return result
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := New(1)
+			result := tt.blocked(q)
+
+			select {
+			case <-result:
+				t.Fatalf("关闭前%s没有阻塞", tt.name)
+			case <-time.After(blockedWait):
+			}
+
+			q.Close()
+			select {
+			case ok := <-result:
+				if ok {
+					t.Fatalf("关闭后阻塞的%s返回true", tt.name)
+				}
+			case <-time.After(wakeWait):
+				t.Fatalf("关闭后阻塞的%s没有被唤醒", tt.name)
+			}
+		})
+	}
+}
+
+func TestCloseKeepsBufferedPackets(t *testing.T) {
+	q := New(4)
+	for i := range 3 {
+		q.Push([]byte{byte(i)})
+	}
+	q.Close()
+	q.Close()
+
+	if q.Push([]byte{9}) {
+		t.Fatal("关闭后Push返回true")
+	}
+	for i := range 3 {
+		packet, ok := q.Pop()
+		if !ok || packet[0] != byte(i) {
+			t.Fatalf("关闭后第 %d 次Pop = %v, %v，应为 [%d], true", i, packet, ok, i)
+		}
+	}
+	if _, ok := q.Pop(); ok {
+		t.Fatal("关闭且取完后Pop返回true")
+	}
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len = %d，应为 0", n)
+	}
+}
+
+func TestFIFOWithProducers(t *testing.T) {
+	const producers, count = 4, 1000
+	q := New(8)
+
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range count {
+				q.Push([]byte(fmt.Sprintf("%d:%d", p, i)))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		q.Close()
+	}()
+
+	// 不同生产者之间交错，同一个生产者的数据包保持放入的顺序
+	next := make([]int, producers)
+	for {
+		packet, ok := q.Pop()
+		if !ok {
+			break
+		}
+		var p, i int
+		if _, err := fmt.Sscanf(string(packet), "%d:%d", &p, &i); err != nil {
+			t.Fatalf("无法解析数据包 %q: %v", packet, err)
+		}
+		if i != next[p] {
+			t.Fatalf("生产者 %d 的第 %d 个包先于第 %d 个取出", p, i, next[p])
+		}
+		next[p]++
+	}
+	for p, n := range next {
+		if n != count {
+			t.Fatalf("生产者 %d 取出 %d 个包，应为 %d", p, n, count)
+		}
+	}
+}
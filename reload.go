@@ -0,0 +1,242 @@
+package main
+
+import (
+	"UDPRainbowBridge/client"
+	"UDPRainbowBridge/server"
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 重新加载锁，SIGHUP与管理命令同时到达时逐个处理
+var reload_mutex = sync.Mutex{}
+
+// 运行中的一个实例
+type instance struct {
+	name   string
+	role   string
+	server *server.Server
+	client *client.Client
+}
+
+// 按配置启动一个实例
+func start_instance(cfg *Config) (*instance, error) {
+	inst := &instance{name: cfg.Name, role: cfg.Role}
+
+	var err error
+	if cfg.Role == "server" {
+		// 服务器模式
+		inst.server, err = server.Start(cfg.Name, cfg.server_config())
+	} else {
+		// 客户端模式
+		inst.client, err = client.Start(cfg.Name, cfg.client_config())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// 把新配置交给实例原地应用，返回需要重启才能生效的配置项
+func (inst *instance) reload(cfg *Config) ([]string, error) {
+	if inst.server != nil {
+		return inst.server.Reload(cfg.server_config())
+	}
+	return inst.client.Reload(cfg.client_config())
+}
+
+// 实例的链路、会话与协商状态
+func (inst *instance) status() string {
+	if inst.server != nil {
+		return inst.server.Status()
+	}
+	return inst.client.Status()
+}
+
+// 进程内运行的全部实例，以及生成配置的输入（重新加载时使用）
+type bridge_process struct {
+	source    config_source
+	running   *Config
+	instances []*instance
+}
+
+// 为新配置中的每个实例找到运行中的实例：按名称对应，两边都只有一个实例时直接对应
+// 新增或删除实例需要重启才能生效，返回对应的提示
+func (process *bridge_process) match(configs []*Config) (map[*instance]*Config, []string) {
+	matched := make(map[*instance]*Config)
+	if len(process.instances) == 1 && len(configs) == 1 {
+		matched[process.instances[0]] = configs[0]
+		return matched, nil
+	}
+
+	by_name := make(map[string]*Config)
+	for _, cfg := range configs {
+		by_name[cfg.Name] = cfg
+	}
+	var notes []string
+	running := make(map[string]bool)
+	for _, inst := range process.instances {
+		running[inst.name] = true
+		if cfg, exists := by_name[inst.name]; exists {
+			matched[inst] = cfg
+		} else {
+			notes = append(notes, fmt.Sprintf("实例 %s 已从配置文件中删除，需要重启才能停止，继续使用原来的配置", inst.name))
+		}
+	}
+	for _, cfg := range configs {
+		if !running[cfg.Name] {
+			notes = append(notes, fmt.Sprintf("新增的实例 %s 需要重启才能启动", cfg.Name))
+		}
+	}
+	return matched, notes
+}
+
+// 重新加载配置：按启动时的配置文件与命令行参数重新生成配置，检查通过后交给各个实例原地应用
+// 运行角色与管理接口地址不能在运行中修改；返回没有生效、需要重启的配置变化，同时输出到日志
+func (process *bridge_process) reload() ([]string, error) {
+	reload_mutex.Lock()
+	defer reload_mutex.Unlock()
+
+	if len(process.source.path) == 0 {
+		return nil, errors.New("启动时没有指定 -config，没有可以重新加载的配置文件")
+	}
+
+	cfg, err := process.source.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// 先检查全部实例的运行角色，有错误时不修改任何实例
+	matched, notes := process.match(cfg.instance_configs())
+	if cfg.Observability.Admin != process.running.Observability.Admin {
+		notes = append(notes, "管理接口地址需要重启才能生效，继续使用原来的地址")
+	}
+	for _, note := range notes {
+		fmt.Println(note)
+	}
+	for _, inst := range process.instances {
+		if instance_cfg, exists := matched[inst]; exists && instance_cfg.Role != inst.role {
+			return nil, fmt.Errorf("%s运行角色不能在运行中修改（当前为 %s，配置为 %s）", instance_label(inst.name), inst.role, instance_cfg.Role)
+		}
+	}
+
+	var errs []error
+	for _, inst := range process.instances {
+		if instance_cfg, exists := matched[inst]; exists {
+			restart, err := inst.reload(instance_cfg)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%v", instance_label(inst.name), err))
+			} else if len(restart) > 0 {
+				notes = append(notes, fmt.Sprintf("%s以下配置需要重启才能生效，继续使用原来的值：%s", instance_label(inst.name), strings.Join(restart, "、")))
+			}
+		}
+	}
+	return notes, errors.Join(errs...)
+}
+
+// 查询实例状态，name为空时返回全部实例；运行多个实例时每个实例前面输出名称与角色
+func (process *bridge_process) status(name string) (string, error) {
+	var b strings.Builder
+	for _, inst := range process.instances {
+		if len(name) > 0 && inst.name != name {
+			continue
+		}
+		if len(process.instances) > 1 || len(name) > 0 {
+			fmt.Fprintf(&b, "== 实例 %s（%s）==\n", inst.name, inst.role)
+		}
+		b.WriteString(inst.status())
+	}
+
+	if b.Len() == 0 && len(name) > 0 {
+		return "", fmt.Errorf("没有名为 %s 的实例", name)
+	}
+	return b.String(), nil
+}
+
+// 错误信息中的实例名称，没有名称时为空
+func instance_label(name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	return "实例 " + name + "："
+}
+
+// 收到SIGHUP时重新加载配置
+func watch_reload_signal(process *bridge_process) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		fmt.Printf("[%s] 收到SIGHUP，重新加载配置\n", time.Now().Format("2006-01-02 15:04:05"))
+		if _, err := process.reload(); err != nil {
+			fmt.Println("重新加载配置失败，继续使用原来的配置:", err)
+		}
+	}
+}
+
+// 启动管理接口：每个连接发送一行命令，返回结果后关闭连接
+// 支持的命令：reload 重新加载配置，status [实例名称] 查询链路、会话与协商状态
+func start_admin(addr string, process *bridge_process) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("管理接口：%s\n", listener.Addr().String())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				fmt.Println("管理接口接受连接失败:", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go handle_admin_conn(conn, process)
+		}
+	}()
+	return nil
+}
+
+// 处理一个管理连接
+// reload 成功时第一行返回 ok，随后每条没有生效、需要重启的配置变化返回一行 warning
+func handle_admin_conn(conn net.Conn, process *bridge_process) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+
+	command, name, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch command {
+	case "reload":
+		fmt.Printf("[%s] 收到管理命令 reload（来自 %s），重新加载配置\n", time.Now().Format("2006-01-02 15:04:05"), conn.RemoteAddr().String())
+		notes, err := process.reload()
+		if err != nil {
+			fmt.Println("重新加载配置失败，继续使用原来的配置:", err)
+			fmt.Fprintf(conn, "error: %v\n", strings.ReplaceAll(err.Error(), "\n", "; "))
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+		for _, note := range notes {
+			fmt.Fprintf(conn, "warning: %s\n", note)
+		}
+	case "status":
+		status, err := process.status(strings.TrimSpace(name))
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			return
+		}
+		fmt.Fprint(conn, status)
+	default:
+		fmt.Fprintf(conn, "error: 未知命令 %q（支持 reload、status [实例名称]）\n", command)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		running []string
+		configs []string
+		// 按运行中的实例顺序对应的配置名称，空字符串表示没有对应的配置
+		want  []string
+		notes int
+	}{
+		{"单个实例改名", []string{"a"}, []string{"b"}, []string{"b"}, 0},
+		{"按名称对应", []string{"a", "b"}, []string{"b", "a"}, []string{"a", "b"}, 0},
+		{"删除实例", []string{"a", "b"}, []string{"a"}, []string{"a", ""}, 1},
+		{"新增实例", []string{"a"}, []string{"a", "b"}, []string{"a"}, 1},
+		{"全部改名", []string{"a", "b"}, []string{"c", "d"}, []string{"", ""}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			process := &bridge_process{}
+			for _, name := range tt.running {
+				process.instances = append(process.instances, &instance{name: name})
+			}
+			var configs []*Config
+			for _, name := range tt.configs {
+				configs = append(configs, &Config{Name: name})
+			}
+
+			matched, notes := process.match(configs)
+			var got []string
+			for _, inst := range process.instances {
+				name := ""
+				if cfg, exists := matched[inst]; exists {
+					name = cfg.Name
+				}
+				got = append(got, name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("对应的配置 %v，应为 %v", got, tt.want)
+			}
+			if len(notes) != tt.notes {
+				t.Fatalf("提示 %v，应有 %d 条", notes, tt.notes)
+			}
+		})
+	}
+}
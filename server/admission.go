@@ -0,0 +1,239 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"container/list"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 来源限速表最多记录的来源IP数量，防止伪造大量源地址耗尽内存
+// 表满时淘汰最久没有流量的来源，伪造源地址的洪水不能把新来源（例如漫游到新地址的客户端）挡在外面
+const source_limiter_max_len = 65536
+
+// AdmissionConfig 监听端口的准入过滤配置
+type AdmissionConfig struct {
+	// 全局允许的来源网段，为空表示不限制
+	AllowCIDRs []string
+
+	// 每个监听端口（与-l顺序一致）额外允许的来源网段，为空表示该端口只受全局网段限制
+	LinkAllowCIDRs [][]string
+
+	// 每个来源IP每秒最多的包数与字节数，0表示不限制
+	RatePPS float64
+	RateBPS float64
+}
+
+// 单个来源IP的限速器
+type source_limiter struct {
+	key     string
+	packets *core.TokenBucket
+	bytes   *core.TokenBucket
+	last    time.Time
+}
+
+// 来源限速表：按最近一次收到包的时间排序，最前面的最新（source_limiter_mutex保护）
+type source_limiter_table struct {
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// 创建空的来源限速表
+func new_source_limiter_table() *source_limiter_table {
+	return &source_limiter_table{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// 删除一个来源
+func (table *source_limiter_table) remove(element *list.Element) {
+	delete(table.entries, element.Value.(*source_limiter).key)
+	table.order.Remove(element)
+}
+
+// 准入规则，重新加载配置时整体替换
+type admission_rules struct {
+	// 全局允许的来源网段
+	global_allow_nets []*net.IPNet
+
+	// 每个监听端口允许的来源网段
+	link_allow_nets [][]*net.IPNet
+
+	// 来源限速配置
+	source_rate_pps float64
+	source_rate_bps float64
+}
+
+// 初始化准入过滤
+func (s *Server) setup_admission(cfg AdmissionConfig, link_count int) error {
+	rules, err := build_admission(cfg, link_count)
+	if err != nil {
+		return err
+	}
+	s.admission.Store(rules)
+
+	s.reject_cidr_counts = make([]int64, link_count)
+	s.reject_rate_counts = make([]int64, link_count)
+	return nil
+}
+
+// 按配置生成准入规则
+func build_admission(cfg AdmissionConfig, link_count int) (*admission_rules, error) {
+	rules := &admission_rules{
+		link_allow_nets: make([][]*net.IPNet, link_count),
+		source_rate_pps: cfg.RatePPS,
+		source_rate_bps: cfg.RateBPS,
+	}
+
+	var err error
+	if rules.global_allow_nets, err = core.ParseCIDRList(cfg.AllowCIDRs); err != nil {
+		return nil, err
+	}
+
+	for index, list := range cfg.LinkAllowCIDRs {
+		if index >= link_count {
+			return nil, fmt.Errorf("监听端口网段配置数量 %d 多于监听地址数量 %d", len(cfg.LinkAllowCIDRs), link_count)
+		}
+		if rules.link_allow_nets[index], err = core.ParseCIDRList(list); err != nil {
+			return nil, fmt.Errorf("监听端口 %d 网段配置错误: %v", index, err)
+		}
+	}
+	return rules, nil
+}
+
+// 替换准入规则，来源限速变化时清空来源限速表，之后的包按新的限速重新计算
+func (s *Server) update_admission(cfg AdmissionConfig, link_count int) error {
+	rules, err := build_admission(cfg, link_count)
+	if err != nil {
+		return err
+	}
+
+	previous := s.admission.Swap(rules)
+	if previous.source_rate_pps != rules.source_rate_pps || previous.source_rate_bps != rules.source_rate_bps {
+		s.source_limiter_mutex.Lock()
+		s.source_limiters = new_source_limiter_table()
+		s.source_limiter_mutex.Unlock()
+	}
+	return nil
+}
+
+// 判断地址是否在网段列表内
+func ip_in_nets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 准入判断，在处理任何包内容之前调用
+// 来源需要同时满足全局网段与该监听端口网段（配置了才检查），并且没有超出来源限速
+func (s *Server) admit(index int, addr *net.UDPAddr, n int) bool {
+	rules := s.admission.Load()
+	if (len(rules.global_allow_nets) > 0 && !ip_in_nets(addr.IP, rules.global_allow_nets)) ||
+		(len(rules.link_allow_nets[index]) > 0 && !ip_in_nets(addr.IP, rules.link_allow_nets[index])) {
+		atomic.AddInt64(&s.reject_cidr_counts[index], 1)
+		s.log_reject("套接字 %d 拒绝不在允许网段内的来源：%s\n", index, addr.String())
+		return false
+	}
+
+	if rules.source_rate_pps <= 0 && rules.source_rate_bps <= 0 {
+		return true
+	}
+
+	if !s.source_allow(rules, addr.IP, n) {
+		atomic.AddInt64(&s.reject_rate_counts[index], 1)
+		s.log_reject("套接字 %d 来源超出限速：%s\n", index, addr.String())
+		return false
+	}
+
+	return true
+}
+
+// 来源IP限速判断
+// 包数与字节数限速都满足时才取出令牌，被其中一个拒绝的包不会消耗另一个的令牌
+func (s *Server) source_allow(rules *admission_rules, ip net.IP, n int) bool {
+	key := ip.String()
+
+	s.source_limiter_mutex.Lock()
+	table := s.source_limiters
+	var limiter *source_limiter
+	if element, exists := table.entries[key]; exists {
+		limiter = element.Value.(*source_limiter)
+		table.order.MoveToFront(element)
+	} else {
+		if len(table.entries) >= source_limiter_max_len {
+			// 限速表已满（可能正在被伪造源地址攻击），淘汰最久没有流量的来源
+			table.remove(table.order.Back())
+		}
+
+		limiter = &source_limiter{key: key}
+		if rules.source_rate_pps > 0 {
+			limiter.packets = core.NewTokenBucket(rules.source_rate_pps, rules.source_rate_pps)
+		}
+		if rules.source_rate_bps > 0 {
+			// 容量至少为一个最大的包，字节数限速低于最大包体时大包也不会被永远拒绝
+			limiter.bytes = core.NewTokenBucket(rules.source_rate_bps, max(rules.source_rate_bps, float64(s.max_packet)))
+		}
+		table.entries[key] = table.order.PushFront(limiter)
+	}
+	limiter.last = time.Now()
+
+	// 令牌桶只在持有限速表锁时使用，判断与取出之间不会被其它线程插入
+	defer s.source_limiter_mutex.Unlock()
+	if (limiter.packets != nil && !limiter.packets.Has(1)) || (limiter.bytes != nil && !limiter.bytes.Has(float64(n))) {
+		return false
+	}
+	if limiter.packets != nil {
+		limiter.packets.Allow(1)
+	}
+	if limiter.bytes != nil {
+		limiter.bytes.Allow(float64(n))
+	}
+	return true
+}
+
+// 定期清理长时间没有流量的来源限速器
+func (s *Server) clean_source_limiters() {
+	for s.sleep(1 * time.Minute) {
+		// 从最久没有流量的一端开始删除，遇到仍然活跃的来源即停止
+		s.source_limiter_mutex.Lock()
+		table := s.source_limiters
+		for element := table.order.Back(); element != nil; element = table.order.Back() {
+			if time.Since(element.Value.(*source_limiter).last) <= 2*time.Minute {
+				break
+			}
+			table.remove(element)
+		}
+		s.source_limiter_mutex.Unlock()
+	}
+}
+
+// 限速输出拒绝日志
+func (s *Server) log_reject(format string, args ...interface{}) {
+	if s.reject_log_limiter.Allow(1) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// 限速输出能力不兼容的拒绝日志（客户端被拒绝后每10秒在每条链路上重试一次）
+func (s *Server) log_refuse(format string, args ...interface{}) {
+	if s.refuse_log_limiter.Allow(1) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// 输出并清零准入过滤统计
+func (s *Server) print_reject_counts() {
+	for index := range s.reject_cidr_counts {
+		cidr := atomic.SwapInt64(&s.reject_cidr_counts[index], 0)
+		rate := atomic.SwapInt64(&s.reject_rate_counts[index], 0)
+		if cidr > 0 || rate > 0 {
+			fmt.Printf("套接字 %d 拒绝包数量：网段不允许 %d，超出限速 %d\n", index, cidr, rate)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// 创建只初始化准入过滤的服务端，max_packet为监听端口上收到的包的最大长度
+func new_admission_server(t *testing.T, cfg AdmissionConfig, link_count int, max_packet int) *Server {
+	t.Helper()
+	s := &Server{
+		source_limiters:    new_source_limiter_table(),
+		reject_log_limiter: core.NewTokenBucket(1, 5),
+		max_packet:         max_packet,
+	}
+	if err := s.setup_admission(cfg, link_count); err != nil {
+		t.Fatalf("初始化准入过滤失败: %v", err)
+	}
+	return s
+}
+
+func source_addr(ip string) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: 9000}
+}
+
+func TestAdmitCIDR(t *testing.T) {
+	s := new_admission_server(t, AdmissionConfig{
+		AllowCIDRs:     []string{"10.0.0.0/8", "192.168.1.5"},
+		LinkAllowCIDRs: [][]string{nil, {"10.1.0.0/16"}},
+	}, 2, 1500)
+
+	tests := []struct {
+		name  string
+		index int
+		ip    string
+		want  bool
+	}{
+		{"全局网段内", 0, "10.2.3.4", true},
+		{"全局单个地址", 0, "192.168.1.5", true},
+		{"全局网段外", 0, "192.168.1.6", false},
+		{"同时在端口网段内", 1, "10.1.2.3", true},
+		{"不在端口网段内", 1, "10.2.3.4", false},
+		{"不在全局网段内", 1, "192.168.1.5", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.admit(tt.index, source_addr(tt.ip), 100); got != tt.want {
+				t.Fatalf("admit(%d, %s) = %v，应为 %v", tt.index, tt.ip, got, tt.want)
+			}
+		})
+	}
+	if s.reject_cidr_counts[0] != 1 || s.reject_cidr_counts[1] != 2 {
+		t.Fatalf("网段拒绝计数 %v，应为 [1 2]", s.reject_cidr_counts)
+	}
+}
+
+func TestAdmitRate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AdmissionConfig
+		// 同一来源依次发送的包长度与是否应被接受
+		sizes []int
+		want  []bool
+	}{
+		{"包数限速", AdmissionConfig{RatePPS: 2}, []int{100, 100, 100}, []bool{true, true, false}},
+		{"字节数限速", AdmissionConfig{RateBPS: 3000}, []int{1400, 1400, 1400}, []bool{true, true, false}},
+		// 字节数限速低于最大包体时容量仍为一个最大的包
+		{"字节数限速低于最大包体", AdmissionConfig{RateBPS: 100}, []int{1500, 1}, []bool{true, false}},
+		// 超出包数限速的包不消耗字节令牌，超出字节数限速的包不消耗包数令牌
+		{"超出包数限速不消耗字节数", AdmissionConfig{RatePPS: 1, RateBPS: 2000}, []int{1000, 1000}, []bool{true, false}},
+		{"超出字节数限速不消耗包数", AdmissionConfig{RatePPS: 2, RateBPS: 1500}, []int{1000, 1000, 500, 1}, []bool{true, false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := new_admission_server(t, tt.cfg, 1, 1500)
+			for index, size := range tt.sizes {
+				if got := s.admit(0, source_addr("203.0.113.1"), size); got != tt.want[index] {
+					t.Fatalf("第 %d 个包（%d 字节）admit = %v，应为 %v", index, size, got, tt.want[index])
+				}
+			}
+
+			// 其它来源有自己的限速器
+			if !s.admit(0, source_addr("203.0.113.2"), tt.sizes[0]) {
+				t.Fatal("另一个来源的第一个包被拒绝")
+			}
+		})
+	}
+}
+
+func TestSourceLimiterEviction(t *testing.T) {
+	s := new_admission_server(t, AdmissionConfig{RatePPS: 1}, 1, 1500)
+	first := source_addr("10.0.0.1")
+	if !s.admit(0, first, 100) {
+		t.Fatal("第一个来源被拒绝")
+	}
+
+	// 填满限速表，期间第一个来源保持活跃，第二个来源之后不再有流量
+	second := source_addr("10.0.0.2")
+	s.admit(0, second, 100)
+	for i := 2; i < source_limiter_max_len; i++ {
+		s.admit(0, source_addr(fmt.Sprintf("10.1.%d.%d", i/256, i%256)), 100)
+		if i == source_limiter_max_len/2 {
+			s.admit(0, first, 100)
+		}
+	}
+	if len(s.source_limiters.entries) != source_limiter_max_len {
+		t.Fatalf("限速表长度 %d，应为 %d", len(s.source_limiters.entries), source_limiter_max_len)
+	}
+
+	// 表满时新来源仍然可以接入，淘汰的是最久没有流量的来源
+	if !s.admit(0, source_addr("10.2.0.1"), 100) {
+		t.Fatal("限速表满时新来源被拒绝")
+	}
+	if len(s.source_limiters.entries) != source_limiter_max_len {
+		t.Fatalf("淘汰后限速表长度 %d，应为 %d", len(s.source_limiters.entries), source_limiter_max_len)
+	}
+	if _, exists := s.source_limiters.entries[second.IP.String()]; exists {
+		t.Fatal("最久没有流量的来源没有被淘汰")
+	}
+	if _, exists := s.source_limiters.entries[first.IP.String()]; !exists {
+		t.Fatal("仍然活跃的来源被淘汰")
+	}
+
+	// 仍在表中的来源继续受限速约束
+	if s.admit(0, first, 100) {
+		t.Fatal("活跃来源的限速状态被重置")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import "time"
+
+// 启动一个后台线程，Close时等待它退出
+func (s *Server) spawn(thread func()) {
+	s.threads.Add(1)
+	go func() {
+		defer s.threads.Done()
+		thread()
+	}()
+}
+
+// 判断实例是否已停止
+func (s *Server) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// 等待一段时间，实例停止时提前返回false，线程随之退出
+func (s *Server) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// Close 停止服务端实例：关闭全部监听端口、转发接口与发送队列，等待所有线程退出，可以重复调用
+func (s *Server) Close() {
+	s.close_once.Do(func() {
+		close(s.done)
+
+		// 先关闭发送队列，不再放入新的包；发送线程在套接字关闭后丢弃剩余的包并退出
+		for _, sendQueue := range s.listen_record_send_queue {
+			sendQueue.Close()
+		}
+
+		for _, recordSocket := range s.listen_record_sockets {
+			if recordSocket == nil {
+				continue
+			}
+			for _, conn := range recordSocket.Sockets {
+				conn.Close()
+			}
+		}
+
+		if remoteSocket := s.remote_con_socket.Load(); remoteSocket != nil {
+			remoteSocket.Close()
+		}
+	})
+	s.threads.Wait()
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"time"
+)
+
+// Config 服务端配置
+type Config struct {
+	// 监听地址，端口可以写成范围（例如 0.0.0.0:9000-9009）
+	Listen []string
+
+	// 下发给客户端的监听地址，与Listen顺序一致，为空时使用Listen
+	Advertise []string
+
+	// 转发地址与解析它使用的地址族（any、4、6）
+	Forward       string
+	ForwardFamily string
+
+	// 设置后去重之后的包交给它，不创建转发接口（嵌入运行时使用，应用通过Server.Send发送）
+	// 在监听端口的读取线程中调用，payload在返回后会被复用，需要保留时复制
+	Deliver func(payload []byte)
+
+	// 最大包体
+	MTU int
+
+	// 默认的下行模式，客户端可以在能力协商时请求其他模式
+	Mode string
+
+	// 帧认证、加密、握手与混淆
+	Security core.SecurityConfig
+
+	// 客户端地址通过验证前每个监听端口每秒最多发送的包数
+	UnvalidatedPPS float64
+
+	// 准入过滤
+	Admission AdmissionConfig
+
+	// 转发地址为域名时的重新解析间隔、链路超时与统计日志间隔，0表示不启用
+	ResolveInterval time.Duration
+	LinkTimeout     time.Duration
+	StatsInterval   time.Duration
+}
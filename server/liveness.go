@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// 判断链路当前是否可以用于下行发送：已经记录了客户端地址，并且在超时时间内收到过客户端的包
+// 客户端按保活间隔在每条链路上发送保活帧，超时说明运营商的NAT映射很可能已失效，下行包发出去也会丢失
+func (s *Server) link_usable(index int) bool {
+	recordSocket := s.listen_record_sockets[index]
+	if recordSocket == nil {
+		return false
+	}
+
+	s.listen_record_add_mutex[index].Lock()
+	defer s.listen_record_add_mutex[index].Unlock()
+
+	if len(recordSocket.Addr) == 0 {
+		return false
+	}
+	timeout := time.Duration(s.link_timeout.Load())
+	return timeout <= 0 || time.Since(s.listen_record_last_heard[index]) < timeout
+}
+
+// 链路超时检查线程，只负责输出链路超时与恢复的事件日志
+func (s *Server) watch_stale_links() {
+	for s.sleep(1 * time.Second) {
+		timeout := time.Duration(s.link_timeout.Load())
+		for index, recordSocket := range s.listen_record_sockets {
+			if recordSocket == nil {
+				continue
+			}
+
+			s.listen_record_add_mutex[index].Lock()
+			addr := recordSocket.Addr
+			heard := s.listen_record_last_heard[index]
+			s.listen_record_add_mutex[index].Unlock()
+
+			if len(addr) == 0 {
+				continue
+			}
+
+			stale := timeout > 0 && time.Since(heard) >= timeout
+			if stale == s.listen_record_stale[index] {
+				continue
+			}
+			s.listen_record_stale[index] = stale
+
+			if stale {
+				fmt.Printf("[%s] 套接字 %d 超过 %v 没有收到客户端 %s 的包，暂停使用该链路\n", time.Now().Format("2006-01-02 15:04:05"), index, timeout, addr)
+			} else {
+				fmt.Printf("[%s] 套接字 %d 重新收到客户端 %s 的包，恢复使用该链路\n", time.Now().Format("2006-01-02 15:04:05"), index, addr)
+			}
+		}
+	}
+}
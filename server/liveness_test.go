@@ -0,0 +1,93 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 创建只有一条链路、启用预共享密钥认证的服务端，只初始化地址更新用到的状态
+func new_liveness_server(t *testing.T) (*Server, *core.RecordSocket) {
+	t.Helper()
+	engine, err := core.NewEngine(core.SecurityConfig{PSK: "secret"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSocket := &core.RecordSocket{}
+	s := &Server{
+		engine:                   engine,
+		listen_record_sockets:    []*core.RecordSocket{recordSocket},
+		listen_record_add_mutex:  []*sync.Mutex{{}},
+		listen_record_last_mark:  make([]core.FrameMark, 1),
+		listen_record_last_heard: make([]time.Time, 1),
+		listen_record_obfs:       make([]int, 1),
+	}
+	return s, recordSocket
+}
+
+// 创建与服务端使用相同预共享密钥的客户端
+func new_psk_client(t *testing.T) *core.Engine {
+	t.Helper()
+	client, err := core.NewEngine(core.SecurityConfig{PSK: "secret"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// 客户端按顺序编码count个帧，由服务端解码
+func client_frames(t *testing.T, s *Server, client *core.Engine, count int) []core.Frame {
+	t.Helper()
+	frames := make([]core.Frame, count)
+	for i := range frames {
+		frame, ok := s.engine.DecodeFrame(client.EncodeFrame("abcd", nil))
+		if !ok {
+			t.Fatal("服务端解码客户端的帧失败")
+		}
+		frames[i] = frame
+	}
+	return frames
+}
+
+func TestUpdateClientAddrRefreshesLastHeard(t *testing.T) {
+	s, recordSocket := new_liveness_server(t)
+	frames := client_frames(t, s, new_psk_client(t), 2)
+	s.link_timeout.Store(int64(time.Second))
+	first := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9000}
+	second := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 2), Port: 9000}
+
+	s.update_client_addr(recordSocket, nil, s.listen_record_add_mutex[0], 0, first, frames[1], 0)
+	if recordSocket.Addr != first.String() || !s.link_usable(0) {
+		t.Fatalf("客户端地址 %q，可用 %v，应为 %q 并且可用", recordSocket.Addr, s.link_usable(0), first)
+	}
+
+	// 映射即将超时，之后收到的是乱序到达的较旧的帧
+	s.listen_record_last_heard[0] = time.Now().Add(-2 * time.Second)
+	s.update_client_addr(recordSocket, nil, s.listen_record_add_mutex[0], 0, second, frames[0], 0)
+
+	if recordSocket.Addr != first.String() {
+		t.Fatalf("较旧的帧改变了客户端地址: %q", recordSocket.Addr)
+	}
+	if !s.link_usable(0) {
+		t.Fatal("通过认证的较旧的帧没有刷新最近收到的时间，链路被当作超时")
+	}
+}
+
+func TestUpdateClientAddrAfterClientRestart(t *testing.T) {
+	s, recordSocket := new_liveness_server(t)
+	before := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9000}
+	after := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 9001}
+
+	for _, frame := range client_frames(t, s, new_psk_client(t), 100) {
+		s.update_client_addr(recordSocket, nil, s.listen_record_add_mutex[0], 0, before, frame, 0)
+	}
+
+	// 客户端重启后从新的端口发送，计数器从头开始，地址仍然跟随新会话更新
+	restarted := client_frames(t, s, new_psk_client(t), 1)[0]
+	s.update_client_addr(recordSocket, nil, s.listen_record_add_mutex[0], 0, after, restarted, 0)
+	if recordSocket.Addr != after.String() {
+		t.Fatalf("客户端重启后地址 %q，应为 %q", recordSocket.Addr, after)
+	}
+}
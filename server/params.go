@@ -0,0 +1,112 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 服务端支持的模式
+var server_modes = []string{"mode1", "mode2"}
+
+// 生成下发给客户端的参数
+// advertise为空时使用监听地址，监听所有地址（0.0.0.0、[::]）时只下发端口，由客户端补上连接服务端使用的主机
+func (s *Server) build_server_params(cfg Config) (*core.ServerParams, error) {
+	listen_ip_list, advertise := cfg.Listen, cfg.Advertise
+	params := &core.ServerParams{
+		Hello: s.engine.LocalHello(cfg.Mode, server_modes, cfg.MTU),
+	}
+
+	if len(advertise) > 0 {
+		if len(advertise) != len(listen_ip_list) {
+			return nil, fmt.Errorf("下发地址数量（%d）与监听地址数量（%d）不一致", len(advertise), len(listen_ip_list))
+		}
+		params.Endpoints = advertise
+	} else {
+		for _, listen_addr := range listen_ip_list {
+			host, port, err := net.SplitHostPort(listen_addr)
+			if err != nil {
+				return nil, fmt.Errorf("监听地址 %s 格式错误: %v", listen_addr, err)
+			}
+			if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+				host = ""
+			}
+			params.Endpoints = append(params.Endpoints, net.JoinHostPort(host, port))
+		}
+	}
+
+	// 建议客户端在超时时间内至少发送三次保活帧
+	if cfg.LinkTimeout > 0 {
+		params.KeepaliveMs = (cfg.LinkTimeout / 3).Milliseconds()
+	}
+	return params, nil
+}
+
+// 处理客户端的参数请求：按客户端携带的能力协商，不兼容时拒绝并在回复中说明原因
+// 参数比请求长，只回复已通过地址验证的地址，避免被伪造来源地址用来放大流量；未验证时客户端会重试
+func (s *Server) handle_config_request(recordSocket *core.RecordSocket, conn *net.UDPConn, addMutex *sync.Mutex, index int, buf []byte, addr *net.UDPAddr, obfs int) {
+	frame, hello, ok, err := s.engine.OpenConfigRequest(buf)
+	if !ok {
+		s.auth_failed(index)
+		return
+	}
+
+	s.update_client_addr(recordSocket, conn, addMutex, index, addr, frame, obfs)
+	s.challenge_client_addr(recordSocket, addMutex, index, len(buf))
+
+	addMutex.Lock()
+	validated := s.listen_record_validated_addr[index] == addr.String()
+	addMutex.Unlock()
+	if !validated {
+		return
+	}
+
+	params := *s.server_params.Load()
+	if err == nil {
+		err = s.negotiate_client(hello)
+	}
+	if err != nil {
+		s.log_refuse("[%s] 套接字 %d 拒绝客户端 %s：%v\n", time.Now().Format("2006-01-02 15:04:05"), index, addr.String(), err)
+		params.Refused = err.Error()
+	}
+
+	resp, err := s.engine.NewConfigResponse(&params)
+	if err != nil || resp == nil {
+		return
+	}
+	if _, err := conn.WriteToUDP(s.engine.Obfuscate(obfs, resp), addr); err != nil {
+		fmt.Printf("套接字 %d 发送参数失败：%v\n", index, err)
+	}
+}
+
+// 按客户端的能力协商，协商结果变化时输出日志
+func (s *Server) negotiate_client(hello core.Hello) error {
+	agreement, err := s.engine.Negotiate(s.server_params.Load().Hello, hello)
+	if err != nil {
+		s.client_refused.Store(true)
+		return err
+	}
+
+	s.client_refused.Store(false)
+	if previous := s.client_agreement.Swap(&agreement); previous == nil || !reflect.DeepEqual(*previous, agreement) {
+		fmt.Printf("[%s] 与客户端协商完成：协议版本 %d，上行模式 %s，下行模式 %s，MTU %d，功能 %v\n", time.Now().Format("2006-01-02 15:04:05"), agreement.Version, agreement.UpMode, agreement.DownMode, agreement.MTU, agreement.Features)
+	}
+	return nil
+}
+
+// 判断下行包是否超过协商出的最大包体，超过的包客户端无法接收
+func (s *Server) exceeds_agreed_mtu(n int) bool {
+	agreement := s.client_agreement.Load()
+	return agreement != nil && n > agreement.MTU
+}
+
+// 当前的下行模式：使用与客户端协商出的模式，尚未协商时使用本端配置的模式
+func (s *Server) down_mode() string {
+	if agreement := s.client_agreement.Load(); agreement != nil {
+		return agreement.DownMode
+	}
+	return s.mode.Load().(string)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 返回新旧配置之间需要重启才能生效的变化（监听地址、转发地址、最大包体与加密认证配置）
+func restart_changes(cfg Config, previous Config) []string {
+	var restart []string
+	if !slices.Equal(cfg.Listen, previous.Listen) {
+		restart = append(restart, "监听地址")
+	}
+	if cfg.Forward != previous.Forward || cfg.ForwardFamily != previous.ForwardFamily {
+		restart = append(restart, "转发地址")
+	}
+	if cfg.MTU != previous.MTU {
+		restart = append(restart, "最大包体")
+	}
+	if !reflect.DeepEqual(cfg.Security, previous.Security) {
+		restart = append(restart, "加密与认证")
+	}
+	return restart
+}
+
+// Reload 重新加载配置：原地更新默认下行模式、下发参数、准入过滤、限速与各项间隔，客户端地址与会话不受影响
+// 监听地址、转发地址、最大包体与加密认证配置需要重启才能生效，变化时继续使用原来的值，返回这些配置项的名称
+// 不支持运行中增删监听套接字：客户端按下发的监听地址顺序建立链路，链路序号与各项按监听地址配置的规则都依赖这个顺序
+func (s *Server) Reload(cfg Config) ([]string, error) {
+	s.reload_mutex.Lock()
+	defer s.reload_mutex.Unlock()
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	previous := s.running_config
+
+	restart := restart_changes(cfg, previous)
+	if !slices.Equal(cfg.Listen, previous.Listen) {
+		// 下发地址与每个监听端口的网段按监听地址的顺序对应，监听地址没有生效时也继续使用原来的
+		cfg.Advertise, cfg.Admission.LinkAllowCIDRs = previous.Advertise, previous.Admission.LinkAllowCIDRs
+	}
+	if len(restart) > 0 {
+		fmt.Printf("[%s] 以下配置需要重启才能生效，继续使用原来的值：%s\n", now, strings.Join(restart, "、"))
+		cfg.Listen, cfg.Forward, cfg.ForwardFamily = previous.Listen, previous.Forward, previous.ForwardFamily
+		cfg.MTU, cfg.Security = previous.MTU, previous.Security
+	}
+
+	// 先生成下发参数与准入规则，有错误时不做任何修改
+	params, err := s.build_server_params(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("下发参数配置错误: %v", err)
+	}
+	if err := s.update_admission(cfg.Admission, len(cfg.Listen)); err != nil {
+		return nil, fmt.Errorf("准入过滤配置错误: %v", err)
+	}
+
+	// 已协商的客户端继续使用协商出的下行模式，下次协商时使用新的默认模式与下发参数
+	s.mode.Store(cfg.Mode)
+	s.server_params.Store(params)
+	if cfg.Mode != previous.Mode {
+		fmt.Printf("[%s] 默认下行模式 %s，客户端下次协商时生效\n", now, cfg.Mode)
+	}
+
+	if cfg.UnvalidatedPPS != previous.UnvalidatedPPS {
+		for _, limiter := range s.unvalidated_limiters {
+			if limiter != nil {
+				limiter.SetRate(cfg.UnvalidatedPPS, cfg.UnvalidatedPPS)
+			}
+		}
+	}
+
+	s.link_timeout.Store(int64(cfg.LinkTimeout))
+	s.resolve_interval.Store(int64(cfg.ResolveInterval))
+	s.stats_interval.Store(int64(cfg.StatsInterval))
+
+	s.running_config = cfg
+	fmt.Printf("[%s] %s配置已重新加载\n", now, s.tag())
+	return restart, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"UDPRainbowBridge/core"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestartChanges(t *testing.T) {
+	base := Config{
+		Listen:   []string{"0.0.0.0:9000", "0.0.0.0:9001"},
+		Forward:  "127.0.0.1:51820",
+		MTU:      1400,
+		Mode:     "mode1",
+		Security: core.SecurityConfig{PSK: "secret"},
+	}
+
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   []string
+	}{
+		{"不变", func(cfg *Config) {}, nil},
+		{"模式切换", func(cfg *Config) { cfg.Mode = "mode2" }, nil},
+		{"准入过滤", func(cfg *Config) {
+			cfg.Admission = AdmissionConfig{AllowCIDRs: []string{"10.0.0.0/8"}, LinkAllowCIDRs: [][]string{nil, {"10.1.0.0/16"}}, RatePPS: 100}
+		}, nil},
+		{"下发地址", func(cfg *Config) { cfg.Advertise = []string{"203.0.113.1:9000", "203.0.113.1:9001"} }, nil},
+		{"限速与间隔", func(cfg *Config) {
+			cfg.UnvalidatedPPS, cfg.LinkTimeout, cfg.StatsInterval = 10, time.Minute, time.Minute
+		}, nil},
+		{"增加监听地址", func(cfg *Config) { cfg.Listen = append(cfg.Listen, "0.0.0.0:9002") }, []string{"监听地址"}},
+		{"删除监听地址", func(cfg *Config) { cfg.Listen = cfg.Listen[:1] }, []string{"监听地址"}},
+		{"转发地址", func(cfg *Config) { cfg.Forward = "127.0.0.1:51821" }, []string{"转发地址"}},
+		{"转发地址族", func(cfg *Config) { cfg.ForwardFamily = "6" }, []string{"转发地址"}},
+		{"最大包体", func(cfg *Config) { cfg.MTU = 1500 }, []string{"最大包体"}},
+		{"加密与认证", func(cfg *Config) { cfg.Security.AllowedKeys = []string{"key"} }, []string{"加密与认证"}},
+		{"同时修改", func(cfg *Config) {
+			cfg.Listen, cfg.Mode, cfg.Security.PSK = []string{"0.0.0.0:9100"}, "mode2", "other"
+		}, []string{"监听地址", "加密与认证"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Listen = slices.Clone(base.Listen)
+			tt.change(&cfg)
+			if got := restart_changes(cfg, base); !slices.Equal(got, tt.want) {
+				t.Fatalf("需要重启的配置 %v，应为 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	listen := []string{free_addr(t), free_addr(t)}
+	cfg := Config{
+		Listen:    listen,
+		Deliver:   func([]byte) {},
+		MTU:       1400,
+		Mode:      "mode1",
+		Admission: AdmissionConfig{AllowCIDRs: []string{"10.0.0.0/8"}},
+	}
+	s, err := Start("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.admit(0, source_addr("127.0.0.1"), 100) {
+		t.Fatal("不在允许网段内的来源被接受")
+	}
+
+	// 修改准入网段与默认下行模式，同时修改需要重启的监听地址与跟随它的每端口网段
+	reloaded := cfg
+	reloaded.Admission = AdmissionConfig{AllowCIDRs: []string{"127.0.0.0/8"}, LinkAllowCIDRs: [][]string{{"192.168.0.0/16"}}}
+	reloaded.Mode = "mode2"
+	reloaded.Listen = append(slices.Clone(listen), free_addr(t))
+	restart, err := s.Reload(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restart, []string{"监听地址"}) {
+		t.Fatalf("需要重启的配置 %v，应为 [监听地址]", restart)
+	}
+	if !slices.Equal(s.running_config.Listen, listen) || s.running_config.Admission.LinkAllowCIDRs != nil {
+		t.Fatalf("没有生效的监听地址被修改: %v，每端口网段 %v", s.running_config.Listen, s.running_config.Admission.LinkAllowCIDRs)
+	}
+	if !s.admit(0, source_addr("127.0.0.1"), 100) {
+		t.Fatal("新的允许网段没有生效")
+	}
+	if s.admit(0, source_addr("10.0.0.1"), 100) {
+		t.Fatal("原来的允许网段仍然生效")
+	}
+	if mode := s.mode.Load().(string); mode != "mode2" {
+		t.Fatalf("默认下行模式 %s，应为 mode2", mode)
+	}
+
+	// 准入规则有错误时不做任何修改
+	broken := reloaded
+	broken.Admission = AdmissionConfig{AllowCIDRs: []string{"not a cidr"}}
+	broken.Mode = "mode1"
+	if _, err := s.Reload(broken); err == nil {
+		t.Fatal("准入网段错误时重新加载成功")
+	}
+	if !s.admit(0, source_addr("127.0.0.1"), 100) || s.mode.Load().(string) != "mode2" {
+		t.Fatal("重新加载失败后配置被修改")
+	}
+}
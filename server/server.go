@@ -2,137 +2,374 @@ package server
 
 import (
 	"UDPRainbowBridge/core"
+	"UDPRainbowBridge/queue"
+	"errors"
 	"fmt"
 	"net"
-	"os"
+	"net/netip"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// 每个监听端口发送队列的长度
 const send_queue_max_len = 1024
 
-var (
+// 连续转发失败多少次后重建本地转发端口
+const remote_write_failure_limit = 3
+
+// Server 一个服务端实例，同一进程内可以运行多个，去重、会话、客户端地址与统计都互不影响
+type Server struct {
+	// 实例名称，日志与管理接口中区分实例
+	name string
+
+	// 去重、帧认证/加密、握手、地址验证与混淆状态
+	engine *core.Engine
+
 	// 监听端口组
 	listen_record_sockets []*core.RecordSocket
 
 	// 监听端口组对应的地址锁
 	listen_record_add_mutex []*sync.Mutex
 
+	// 监听端口组对应的最后一次接受地址更新的帧的会话与计数器（由地址锁保护）
+	listen_record_last_mark []core.FrameMark
+
+	// 监听端口组对应的已通过地址验证的客户端地址与上次发送质询的时间（由地址锁保护）
+	listen_record_validated_addr []string
+	listen_record_challenge_time []time.Time
+
+	// 监听端口组对应的上次要求客户端重新握手的时间（由地址锁保护）
+	listen_record_rekey_time []time.Time
+
+	// 监听端口组对应的客户端最近使用的混淆方式，回复时使用相同方式（由地址锁保护）
+	listen_record_obfs []int
+
+	// 未通过地址验证时的下行限速器
+	unvalidated_limiters []*core.TokenBucket
+
+	// 未通过地址验证时每秒最多发送的包数（重新加载配置时修改各个限速器）
+	unvalidated_pps float64
+
 	// 监听端口组对应的index锁
-	listen_record_index_mutex = sync.Mutex{}
+	listen_record_index_mutex sync.Mutex
+
+	// 本地连接端口，远程域名解析结果变化时会被替换
+	remote_con_socket atomic.Pointer[net.UDPConn]
 
-	// 本地连接端口
-	remote_con_socket *net.UDPConn
+	// 转发到本地连接端口连续失败的次数（原子操作）
+	remote_write_failures int32
 
 	// 命中包统计
 	hit_counts []int
 
 	// 命中统计锁
-	hit_mutex = sync.Mutex{}
+	hit_mutex sync.Mutex
 
-	// 监听端口发送队列组()
-	listen_record_send_queue [][send_queue_max_len][]byte
+	// 认证失败包统计（原子操作）
+	auth_fail_counts []int64
 
-	// 监听顿口发送队列指针列表，第一位代表当前数据位置，第二位代表当前已经发送数据位置
-	send_queue_point_list [][2]int64
+	// 地址未验证被限速丢弃的下行包统计（原子操作）
+	unvalidated_drop_counts []int64
 
-	// 运行模式
-	mode string
-)
+	// 每条链路最近一次收到客户端通过认证的包的时间（地址锁保护）
+	listen_record_last_heard []time.Time
+
+	// 每条链路是否已超时（只在超时检查线程中读写）
+	listen_record_stale []bool
+
+	// 超过多久没有收到客户端的包就认为NAT映射已失效，0表示不检查（原子操作）
+	link_timeout atomic.Int64
+
+	// 链路不可用（没有客户端或映射超时）被丢弃的下行包统计（原子操作）
+	stale_drop_counts []int64
+
+	// 监听端口对应的发送队列，Close时关闭
+	listen_record_send_queue []*queue.Queue
+
+	// 去重之后的包交给调用方，不转发到转发地址（嵌入运行时使用，为nil时不启用）
+	deliver func(payload []byte)
+
+	// 发送锁，下行包的编码与入队只有一个写入方
+	send_mutex sync.Mutex
+
+	// 链路聚合模式下一个使用的监听端口（发送锁保护）
+	send_index int
+
+	// 默认的下行模式（string），客户端可以在能力协商时请求其他模式
+	mode atomic.Value
+
+	// 转发地址为域名时的重新解析间隔，0表示不重新解析（原子操作）
+	resolve_interval atomic.Int64
+
+	// 统计日志间隔，0表示不输出（原子操作）
+	stats_interval atomic.Int64
+
+	// 当前的准入规则
+	admission atomic.Pointer[admission_rules]
+
+	// 来源IP限速表与锁
+	source_limiters      *source_limiter_table
+	source_limiter_mutex sync.Mutex
+
+	// 监听端口上收到的包的最大长度（最大包体加上帧与混淆的开销），来源字节数限速的容量不小于它
+	max_packet int
+
+	// 因网段不允许、超出限速被拒绝的包统计（原子操作）
+	reject_cidr_counts []int64
+	reject_rate_counts []int64
+
+	// 拒绝日志限速，避免被攻击时刷屏
+	reject_log_limiter *core.TokenBucket
+
+	// 能力不兼容的拒绝日志单独限速，不会被攻击流量的拒绝日志挤掉
+	refuse_log_limiter *core.TokenBucket
+
+	// 下发给客户端的参数，启动时生成，重新加载配置时整体替换
+	server_params atomic.Pointer[core.ServerParams]
+
+	// 与客户端协商出的参数，尚未协商时为nil
+	client_agreement atomic.Pointer[core.Agreement]
 
-// 创建监听端口列表
-func create_cluster_listen_socket(listen_ip_list []string) {
+	// 客户端是否因能力不兼容被拒绝，被拒绝时不转发客户端的数据
+	client_refused atomic.Bool
+
+	// 平滑停止中，不再接收转发地址的包
+	input_closed atomic.Bool
+
+	// 当前生效的配置，重新加载时与新配置比较（reload_mutex保护）
+	running_config Config
+
+	// 重新加载配置锁，同时收到多个重新加载请求时逐个处理
+	reload_mutex sync.Mutex
+
+	// 累计统计（原子操作），统计日志输出后不清零：
+	// 每个监听端口发送成功、命中与认证失败的包，从转发地址收到的包与转发到转发地址的包
+	sent_totals      []int64
+	hit_totals       []int64
+	auth_fail_totals []int64
+	input_total      int64
+	output_total     int64
+
+	// 实例停止信号，Close时关闭
+	done chan struct{}
+
+	// 保证只停止一次
+	close_once sync.Once
+
+	// 后台线程，Close时等待全部退出
+	threads sync.WaitGroup
+
+	// 各监听端口的发送线程，停止时用来等待队列中的包发完
+	senders sync.WaitGroup
+}
+
+// 创建监听端口列表，任意一个地址监听失败时关闭已经打开的套接字并返回错误
+func (s *Server) create_cluster_listen_socket(listen_ip_list []string) error {
 	// 初始化数组
-	listen_record_sockets = make([]*core.RecordSocket, len(listen_ip_list))
-	listen_record_add_mutex = make([]*sync.Mutex, len(listen_ip_list))
+	s.listen_record_sockets = make([]*core.RecordSocket, len(listen_ip_list))
+	s.listen_record_add_mutex = make([]*sync.Mutex, len(listen_ip_list))
+	s.listen_record_last_mark = make([]core.FrameMark, len(listen_ip_list))
+	s.listen_record_validated_addr = make([]string, len(listen_ip_list))
+	s.listen_record_challenge_time = make([]time.Time, len(listen_ip_list))
+	s.listen_record_rekey_time = make([]time.Time, len(listen_ip_list))
+	s.listen_record_obfs = make([]int, len(listen_ip_list))
+	s.listen_record_last_heard = make([]time.Time, len(listen_ip_list))
+	s.listen_record_stale = make([]bool, len(listen_ip_list))
+	s.unvalidated_limiters = make([]*core.TokenBucket, len(listen_ip_list))
 
 	// 循环最大监听数量次数，监听对应端口
 	for i := 0; i < len(listen_ip_list); i++ {
+		sockets, err := listen_port_range(listen_ip_list[i])
+		if err != nil {
+			s.close_listen_sockets()
+			return fmt.Errorf("监听 %s 失败: %v", listen_ip_list[i], err)
+		}
+
+		s.listen_record_sockets[i] = &core.RecordSocket{
+			Socket:  sockets[0],
+			Addr:    "",
+			Sockets: sockets,
+		}
 
-		addr, err_resolve := net.ResolveUDPAddr("udp", listen_ip_list[i])
+		s.listen_record_add_mutex[i] = &sync.Mutex{}
+		s.unvalidated_limiters[i] = core.NewTokenBucket(s.unvalidated_pps, s.unvalidated_pps)
+	}
+	return nil
+}
 
-		if err_resolve != nil {
-			fmt.Printf("解析地址 %s 失败: %v\n", listen_ip_list[i], err_resolve)
+// 关闭已经打开的监听套接字（启动失败时使用）
+func (s *Server) close_listen_sockets() {
+	for _, recordSocket := range s.listen_record_sockets {
+		if recordSocket == nil {
 			continue
 		}
+		for _, conn := range recordSocket.Sockets {
+			conn.Close()
+		}
+	}
+}
+
+// 监听一个地址，端口为范围时（例如 0.0.0.0:9000-9009，用于客户端端口跳变）监听范围内的每个端口
+// 任意一个端口监听失败时关闭范围内已经打开的端口并返回错误
+func listen_port_range(listen_addr string) ([]*net.UDPConn, error) {
+	host, port, err := net.SplitHostPort(listen_addr)
+	if err != nil {
+		return nil, err
+	}
+
+	min, max, err := core.ParsePortRange(port)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []*net.UDPConn
+	for p := min; p <= max; p++ {
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(p)))
 
 		// 监听
-		conn, err_listen := net.ListenUDP("udp", addr)
-		if err_listen != nil {
-			fmt.Printf("监听UDP套接字 %s 失败: %v\n", addr.String(), err_listen)
-			continue
+		var conn *net.UDPConn
+		if err == nil {
+			conn, err = net.ListenUDP("udp", addr)
 		}
-
-		listen_record_sockets[i] = &core.RecordSocket{
-			Socket: conn,
-			Addr:   "",
+		if err != nil {
+			// 关闭范围内已经打开的端口
+			for _, opened := range sockets {
+				opened.Close()
+			}
+			return nil, err
 		}
 
-		listen_record_add_mutex[i] = &sync.Mutex{}
+		if min == max {
+			fmt.Printf("创建UDP监听：%s\n", addr.String())
+		}
+		sockets = append(sockets, conn)
+	}
 
-		fmt.Printf("创建UDP监听：%s\n", addr.String())
+	if min != max {
+		fmt.Printf("创建UDP监听：%s（%d个端口）\n", listen_addr, len(sockets))
 	}
+	return sockets, nil
 }
 
-func handle_cluster_socket_info(recordSocket *core.RecordSocket, addMutex *sync.Mutex, index int, mtu int) {
-	defer recordSocket.Socket.Close()
+// 监听端口接收线程，端口为范围时每个端口一个线程，收到的包都属于同一条链路
+func (s *Server) handle_cluster_socket_info(recordSocket *core.RecordSocket, conn *net.UDPConn, addMutex *sync.Mutex, index int, mtu int) {
+	defer conn.Close()
 
-	// 缓存
-	buf := make([]byte, mtu)
+	// 缓存（需要额外容纳帧头、认证标签与混淆开销）
+	buf := make([]byte, mtu+s.engine.FrameOverhead()+s.engine.ObfsOverhead())
 
 	// 循环读取数据
 	for {
-		n, addr, err := recordSocket.Socket.ReadFromUDP(buf)
+		n, addr, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			// 套接字已关闭时退出，不能一直输出读取错误
+			if s.stopped() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("读取本地监听套接字数据失败:", err)
 			continue
 		}
 
-		// 记录地址
-		addMutex.Lock()
-		recordSocket.Addr = addr.String()
-		addMutex.Unlock()
+		// 准入过滤：来源网段与来源限速
+		if !s.admit(index, addr, n) {
+			continue
+		}
+
+		// 还原混淆，不是允许的混淆方式的包直接丢弃
+		packet, obfs, ok := s.engine.Deobfuscate(buf[:n])
+		if !ok {
+			s.auth_failed(index)
+			continue
+		}
 
 		// 判断长度
-		if n < 4 {
+		if len(packet) < core.SeqLen {
 			fmt.Println("数据包长度小于4字节，丢弃。")
 			continue
 		}
 
-		// 取前四个字节解析序列号
-		seq := string(buf[:4])
+		// 保活帧只更新客户端地址与最近收到的时间，不转发
+		if core.IsControlFrame(packet) && core.ControlType(packet) == core.ControlKeepalive {
+			frame, ok := s.engine.OpenKeepalive(packet)
+			if !ok {
+				s.auth_failed(index)
+				s.request_rekey(addMutex, index, conn, addr, packet, obfs)
+				continue
+			}
+			s.update_client_addr(recordSocket, conn, addMutex, index, addr, frame, obfs)
+			s.challenge_client_addr(recordSocket, addMutex, index, len(packet))
+			continue
+		}
+
+		// 控制帧单独处理
+		if core.IsControlFrame(packet) {
+			s.handle_control_frame(recordSocket, conn, addMutex, index, packet, addr, obfs)
+			continue
+		}
+
+		// 认证失败的包直接丢弃，不能改变任何状态
+		frame, ok := s.engine.DecodeFrame(packet)
+		if !ok {
+			s.auth_failed(index)
+			s.request_rekey(addMutex, index, conn, addr, packet, obfs)
+			continue
+		}
+
+		// 记录地址，新地址需要先通过地址验证
+		s.update_client_addr(recordSocket, conn, addMutex, index, addr, frame, obfs)
+		s.challenge_client_addr(recordSocket, addMutex, index, len(packet))
+
+		// 能力不兼容的客户端的数据不转发
+		if s.client_refused.Load() {
+			continue
+		}
+
+		// 帧头中的序列号
+		seq := frame.Seq
 
 		// 判断序列号是否有效
-		listen_record_index_mutex.Lock()
+		s.listen_record_index_mutex.Lock()
 		// fmt.Println("测试序列号:", seq)
-		if !core.IndexIsValid(seq) {
+		if !s.engine.IndexIsValid(seq) {
 			// fmt.Println("序列号无效:", seq)
-			listen_record_index_mutex.Unlock()
+			s.listen_record_index_mutex.Unlock()
 			continue
 		}
 
 		// fmt.Println("！！有效序列号:", seq)
 
 		// 记录包序号
-		core.RecordIndex(seq)
+		s.engine.RecordIndex(seq)
 
 		// 释放锁
-		listen_record_index_mutex.Unlock()
+		s.listen_record_index_mutex.Unlock()
 
 		// 增加命中统计
-		hit_mutex.Lock()
-		hit_counts[index]++
-		hit_mutex.Unlock()
+		s.hit_mutex.Lock()
+		s.hit_counts[index]++
+		s.hit_mutex.Unlock()
+		atomic.AddInt64(&s.hit_totals[index], 1)
+
+		// 嵌入运行时直接交给调用方，不经过转发地址
+		if s.deliver != nil {
+			s.deliver(frame.Payload)
+			atomic.AddInt64(&s.output_total, 1)
+			continue
+		}
 
 		// 转发到远程端口中
-		if remote_con_socket != nil {
+		if remoteSocket := s.remote_con_socket.Load(); remoteSocket != nil {
 			// 发送数据
-			_, sendErr := remote_con_socket.Write([]byte(buf[4:n]))
+			_, sendErr := remoteSocket.Write(frame.Payload)
 			if sendErr != nil {
+				atomic.AddInt32(&s.remote_write_failures, 1)
 				fmt.Println("转发数据包失败", sendErr)
 			} else {
-				// fmt.Printf("转发数据包->%s\n", remote_con_socket.RemoteAddr().String())
+				atomic.StoreInt32(&s.remote_write_failures, 0)
+				atomic.AddInt64(&s.output_total, 1)
+				// fmt.Printf("转发数据包->%s\n", remoteSocket.RemoteAddr().String())
 			}
 		} else {
 			fmt.Println("连接未建立，转发失败")
@@ -140,225 +377,567 @@ func handle_cluster_socket_info(recordSocket *core.RecordSocket, addMutex *sync.
 	}
 }
 
+// 更新监听端口对应的客户端地址
+// 每个通过认证的帧都会刷新最近收到的时间（多条链路乱序到达的帧也说明映射仍然有效），
+// 但启用认证时只接受比上一次更新更新的帧（同一会话内计数器更大，或者来自新建立的会话）带来的地址变更，
+// 伪造的包无法通过认证，重放的旧包不够新，都无法把下行流量劫持走
+// 同时记录客户端使用的混淆方式与收到包的监听端口，之后的回复使用相同方式、从同一个端口发出
+func (s *Server) update_client_addr(recordSocket *core.RecordSocket, conn *net.UDPConn, addMutex *sync.Mutex, index int, addr *net.UDPAddr, frame core.Frame, obfs int) {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+
+	// 记录最近一次收到客户端的时间，用于判断NAT映射是否还有效
+	s.listen_record_last_heard[index] = time.Now()
+
+	if !s.engine.Newer(s.listen_record_last_mark[index], frame) {
+		return
+	}
+	s.listen_record_last_mark[index] = frame.Mark()
+
+	if s.listen_record_obfs[index] != obfs {
+		fmt.Printf("套接字 %d 客户端混淆方式：%s\n", index, s.engine.ObfsName(obfs))
+		s.listen_record_obfs[index] = obfs
+	}
+
+	// 客户端端口跳变时切换到它正在使用的监听端口
+	recordSocket.Socket = conn
+
+	newAddr := addr.String()
+	if recordSocket.Addr == newAddr {
+		return
+	}
+
+	// 地址变更事件日志
+	if len(recordSocket.Addr) == 0 {
+		fmt.Printf("[%s] 套接字 %d 客户端接入：%s\n", time.Now().Format("2006-01-02 15:04:05"), index, newAddr)
+	} else {
+		fmt.Printf("[%s] 套接字 %d 客户端地址变更：%s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), index, recordSocket.Addr, newAddr)
+	}
+
+	recordSocket.Addr = newAddr
+}
+
+// 向尚未验证的客户端地址发送地址验证质询，每秒最多一次
+// received为触发质询的包（还原混淆后）的长度，比质询短时不发送：未启用认证时最短的帧只有4字节，
+// 伪造源地址的短包不能换来更长的质询；客户端的保活帧不短于质询，空闲的链路也能通过验证
+func (s *Server) challenge_client_addr(recordSocket *core.RecordSocket, addMutex *sync.Mutex, index int, received int) {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+
+	addr := recordSocket.Addr
+	if len(addr) == 0 || s.listen_record_validated_addr[index] == addr {
+		return
+	}
+
+	challenge := s.engine.NewCookieChallenge(addr)
+	if received < len(challenge) {
+		return
+	}
+
+	if time.Since(s.listen_record_challenge_time[index]) < time.Second {
+		return
+	}
+	s.listen_record_challenge_time[index] = time.Now()
+
+	addrOb, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+
+	challenge = s.engine.Obfuscate(s.listen_record_obfs[index], challenge)
+	if _, err := recordSocket.Socket.WriteToUDP(challenge, addrOb); err != nil {
+		fmt.Printf("套接字 %d 发送地址验证质询失败：%v\n", index, err)
+	}
+}
+
+// 握手模式下收到会话标识未知的帧时（例如服务端重启后客户端仍在使用原来的会话），要求客户端立即重新握手，
+// 不必等到下一次换钥；每条链路每秒最多回复一次
+func (s *Server) request_rekey(addMutex *sync.Mutex, index int, conn *net.UDPConn, addr *net.UDPAddr, packet []byte, obfs int) {
+	request := s.engine.NewRekeyRequest(packet)
+	if request == nil {
+		return
+	}
+
+	addMutex.Lock()
+	if time.Since(s.listen_record_rekey_time[index]) < time.Second {
+		addMutex.Unlock()
+		return
+	}
+	s.listen_record_rekey_time[index] = time.Now()
+	addMutex.Unlock()
+
+	if _, err := conn.WriteToUDP(s.engine.Obfuscate(obfs, request), addr); err != nil {
+		fmt.Printf("套接字 %d 要求客户端重新握手失败：%v\n", index, err)
+	}
+}
+
+// 处理客户端发来的控制帧
+func (s *Server) handle_control_frame(recordSocket *core.RecordSocket, conn *net.UDPConn, addMutex *sync.Mutex, index int, buf []byte, addr *net.UDPAddr, obfs int) {
+	switch core.ControlType(buf) {
+	case core.ControlHandshakeInit:
+		resp, err := s.engine.HandleHandshakeInit(buf)
+		if err != nil {
+			s.auth_failed(index)
+			s.log_reject("套接字 %d 处理握手失败，来源：%s，原因：%v\n", index, addr.String(), err)
+			return
+		}
+
+		// 握手响应直接回复，不经过发送队列（响应比发起消息短，不会被用来放大流量）
+		// 发起消息可能是重放的，不更新客户端地址，地址由之后新会话中的帧更新
+		if _, err := conn.WriteToUDP(s.engine.Obfuscate(obfs, resp), addr); err != nil {
+			fmt.Printf("套接字 %d 发送握手响应失败：%v\n", index, err)
+		}
+	case core.ControlCookieEcho:
+		if !s.engine.VerifyCookieEcho(buf, addr.String()) {
+			s.auth_failed(index)
+			return
+		}
+
+		// 应答只能证明该地址可达，只有它正是当前记录的客户端地址时才标记为已验证
+		addMutex.Lock()
+		if recordSocket.Addr == addr.String() && s.listen_record_validated_addr[index] != recordSocket.Addr {
+			s.listen_record_validated_addr[index] = recordSocket.Addr
+			fmt.Printf("[%s] 套接字 %d 客户端地址验证通过：%s\n", time.Now().Format("2006-01-02 15:04:05"), index, recordSocket.Addr)
+		}
+		addMutex.Unlock()
+	case core.ControlConfigRequest:
+		s.handle_config_request(recordSocket, conn, addMutex, index, buf, addr, obfs)
+	case core.ControlClose:
+		s.handle_client_close(index, buf, addr)
+	default:
+		s.auth_failed(index)
+	}
+}
+
 // 创建本地转发端口
-func create_remote_socket(addr string) {
-	remoteAddr, err_resolve := net.ResolveUDPAddr("udp", addr)
+func (s *Server) create_remote_socket(addr string, network string) error {
+	remoteAddr, err_resolve := net.ResolveUDPAddr(network, addr)
 
 	if err_resolve != nil {
-		fmt.Printf("解析地址 %s 失败: %v\n", addr, err_resolve)
-		os.Exit(1)
+		return fmt.Errorf("解析地址 %s 失败: %v", addr, err_resolve)
 	}
 
-	_remote_con_socket, err := net.DialUDP("udp", nil, remoteAddr)
-	remote_con_socket = _remote_con_socket
+	_remote_con_socket, err := net.DialUDP(network, nil, remoteAddr)
 
 	if err != nil {
-		fmt.Printf("无法连接到服务器 %s: %v\n", remoteAddr, err)
-		os.Exit(1)
-	} else {
-		fmt.Printf("创建转发接口：%s", remoteAddr.String())
+		return fmt.Errorf("无法连接到服务器 %s: %v", remoteAddr, err)
 	}
 
+	s.remote_con_socket.Store(_remote_con_socket)
+	fmt.Printf("创建转发接口：%s\n", remoteAddr.String())
+	return nil
+}
+
+// 转发地址为域名时定期重新解析，解析结果变化或连续转发失败时重建本地转发端口
+func (s *Server) watch_remote_socket(addr string, network string) {
+	resolved_at := time.Now()
+	for s.sleep(1 * time.Second) {
+
+		interval := time.Duration(s.resolve_interval.Load())
+		if interval <= 0 {
+			continue
+		}
+
+		failed := atomic.LoadInt32(&s.remote_write_failures) >= remote_write_failure_limit
+		if !failed && time.Since(resolved_at) < interval {
+			continue
+		}
+		resolved_at = time.Now()
+
+		remoteAddr, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			fmt.Printf("[%s] 重新解析转发地址 %s 失败：%v\n", time.Now().Format("2006-01-02 15:04:05"), addr, err)
+			continue
+		}
+
+		old := s.remote_con_socket.Load()
+		if !failed && core.SameUDPAddr(remoteAddr, old.RemoteAddr().(*net.UDPAddr)) {
+			continue
+		}
+
+		conn, err := net.DialUDP(network, nil, remoteAddr)
+		if err != nil {
+			fmt.Printf("[%s] 重建转发接口 %s 失败：%v\n", time.Now().Format("2006-01-02 15:04:05"), remoteAddr.String(), err)
+			continue
+		}
+
+		// 先替换再关闭旧套接字，读取线程会自动切换到新套接字
+		s.remote_con_socket.Store(conn)
+		atomic.StoreInt32(&s.remote_write_failures, 0)
+		old.Close()
+		if s.stopped() {
+			// 替换时实例正好停止，新套接字也要关闭
+			conn.Close()
+			return
+		}
+		fmt.Printf("[%s] 转发接口已重建：%s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), old.RemoteAddr().String(), remoteAddr.String())
+	}
 }
 
 // 监听远端输入
-func handle_remote_socket_info(mtu int) {
+func (s *Server) handle_remote_socket_info(mtu int) {
 	buffer := make([]byte, mtu)
 
-	sendIndex := 0
-
 	for {
-		n, _, err := remote_con_socket.ReadFromUDP(buffer)
+		remoteSocket := s.remote_con_socket.Load()
+		n, _, err := remoteSocket.ReadFromUDP(buffer)
 
 		if err != nil {
+			if remoteSocket != s.remote_con_socket.Load() {
+				// 转发接口已被重建，切换到新套接字
+				continue
+			}
+			if s.stopped() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Printf("接收消息出错: %v\n", err)
 			continue
 		}
 
 		// fmt.Printf("开始处理消息，消息长度：%d\n", n)
 
-		// 生成序号
-		listen_record_index_mutex.Lock()
-		seq := core.GetIndex()
-		listen_record_index_mutex.Unlock()
+		s.send_remote(buffer[:n])
+	}
+}
+
+// Send 发送一个包到客户端，与从转发地址收到的包相同处理（嵌入运行时使用）
+// 与UDP一样不保证送达：客户端被拒绝、会话尚未建立或没有可用的链路时丢弃；payload可以在返回后复用
+func (s *Server) Send(payload []byte) error {
+	if s.stopped() || s.input_closed.Load() {
+		return net.ErrClosed
+	}
+	s.send_remote(payload)
+	return nil
+}
+
+// 编码一个下行包，按下行模式放入监听端口的发送队列
+// 可能被多个线程同时调用（嵌入运行时），发送锁保证轮询位置只有一个写入方，包按序列号的顺序入队
+func (s *Server) send_remote(payload []byte) {
+	s.send_mutex.Lock()
+	defer s.send_mutex.Unlock()
+
+	// 平滑停止中不再接收转发地址的包
+	if s.input_closed.Load() {
+		return
+	}
+
+	atomic.AddInt64(&s.input_total, 1)
+
+	// 客户端被拒绝或包超过协商出的最大包体时丢弃
+	if s.client_refused.Load() || s.exceeds_agreed_mtu(len(payload)) {
+		return
+	}
+
+	// 生成序号
+	s.listen_record_index_mutex.Lock()
+	seq := s.engine.GetIndex()
+	s.listen_record_index_mutex.Unlock()
 
-		// 添加序列号
-		packet := append([]byte(seq), buffer[:n]...)
+	// 会话尚未建立，丢弃
+	if !s.engine.SessionEstablished() {
+		return
+	}
 
-		if mode == "mode1" {
-			// 多倍发包模式
-			// 发送数据包到所有已记录的客户端
-			for index := range listen_record_sockets {
+	// 下行模式可以由客户端按会话请求
+	policy := s.down_mode()
 
+	if policy == "mode1" {
+		// 多倍发包模式
+		// 发送数据包到所有已记录的客户端（跳过没有客户端或映射已超时的链路）
+		// 每个副本分别编码（添加序列号，按配置认证或加密），认证模式下各副本的计数器不同，不会被客户端的抗重放窗口丢弃
+		for index := range s.listen_record_sockets {
+			if s.link_usable(index) {
 				// 放入发送队列中
-				// 先获取位置
-				next_index := atomic.LoadInt64(&send_queue_point_list[index][0])
-
-				// 判断是否满了（下一个位置是发送位置）(理论上不应该发生)
-				waitCount := 0
-				for {
-					if next_index+1 == atomic.LoadInt64(&send_queue_point_list[index][1]) {
-						// 等待写入
-						time.Sleep(1 * time.Millisecond)
-
-						fmt.Println("发送队列满了，等待写入完成", waitCount)
-						waitCount++
-					} else {
-						break
-					}
+				if packet := s.engine.EncodeFrame(seq, payload); packet != nil {
+					s.enqueue_packet(index, packet)
 				}
-
-				// 写入数据
-				listen_record_send_queue[index][next_index] = packet
-				// 坐标后移
-				atomic.StoreInt64(&send_queue_point_list[index][0], (next_index+1)%send_queue_max_len)
 			}
-		} else if mode == "mode2" {
-			// 链路聚合模式
-			// 按顺序进行发包
-			// 先获取位置
-			next_index := atomic.LoadInt64(&send_queue_point_list[sendIndex][0])
-
-			// 判断是否满了（下一个位置是发送位置）(理论上不应该发生)
-			waitCount := 0
-			for {
-				if next_index+1 == atomic.LoadInt64(&send_queue_point_list[sendIndex][1]) {
-					// 等待写入
-					time.Sleep(1 * time.Millisecond)
-
-					fmt.Println("发送队列满了，等待写入完成", waitCount)
-					waitCount++
-				} else {
-					break
+		}
+	} else if policy == "mode2" {
+		// 链路聚合模式
+		// 按顺序进行发包，跳过不可用的链路
+		for range s.listen_record_sockets {
+			index := s.send_index
+			s.send_index = (s.send_index + 1) % len(s.listen_record_sockets)
+			if s.link_usable(index) {
+				if packet := s.engine.EncodeFrame(seq, payload); packet != nil {
+					s.enqueue_packet(index, packet)
 				}
+				break
 			}
+		}
+	}
+}
 
-			// 写入数据
-			listen_record_send_queue[sendIndex][next_index] = packet
-			// 坐标后移
-			atomic.StoreInt64(&send_queue_point_list[sendIndex][0], (next_index+1)%send_queue_max_len)
+// 将数据包放入监听端口的发送队列，队列满时等待发送线程取出，实例已停止时丢弃
+func (s *Server) enqueue_packet(index int, packet []byte) {
+	s.listen_record_send_queue[index].Push(packet)
+}
 
-			// sendIndex++
-			sendIndex = (sendIndex + 1) % len(listen_record_sockets)
-		}
+// 返回套接字当前客户端地址的地址族标签，没有客户端时返回"-"
+func (s *Server) client_family(index int) string {
+	if s.listen_record_sockets[index] == nil {
+		return "-"
+	}
+
+	s.listen_record_add_mutex[index].Lock()
+	addr := s.listen_record_sockets[index].Addr
+	s.listen_record_add_mutex[index].Unlock()
 
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return "-"
+	}
+	if addrPort.Addr().Unmap().Is4() {
+		return "IPv4"
 	}
+	return "IPv6"
 }
 
-func print_hit_counts() {
+func (s *Server) print_hit_counts() {
 	for {
-		// 间隔五秒输出一次
-		time.Sleep(5 * time.Second)
+		// 未启用时等待重新加载配置
+		interval := time.Duration(s.stats_interval.Load())
+		if interval <= 0 {
+			if !s.sleep(1 * time.Second) {
+				return
+			}
+			continue
+		}
+		if !s.sleep(interval) {
+			return
+		}
 
 		// 获取总数
 		total := 0
-		for _, count := range hit_counts {
+		for _, count := range s.hit_counts {
 			total += count
 		}
 
+		// 输出认证失败统计
+		for index := range s.auth_fail_counts {
+			failed := atomic.SwapInt64(&s.auth_fail_counts[index], 0)
+			if failed > 0 {
+				fmt.Printf("套接字 %d 认证失败包数量: %d\n", index, failed)
+			}
+		}
+
+		// 输出准入过滤统计
+		s.print_reject_counts()
+
+		// 输出地址未验证被限速丢弃的统计
+		for index := range s.unvalidated_drop_counts {
+			dropped := atomic.SwapInt64(&s.unvalidated_drop_counts[index], 0)
+			if dropped > 0 {
+				fmt.Printf("套接字 %d 地址未验证被限速丢弃包数量: %d\n", index, dropped)
+			}
+		}
+
+		// 输出链路不可用被丢弃的统计
+		for index := range s.stale_drop_counts {
+			dropped := atomic.SwapInt64(&s.stale_drop_counts[index], 0)
+			if dropped > 0 {
+				fmt.Printf("套接字 %d 链路不可用丢弃包数量: %d\n", index, dropped)
+			}
+		}
+
 		if total == 0 {
 			continue
 		}
 
-		fmt.Printf("---------------总命中包数量: %d----------------------\n", total)
+		fmt.Printf("---------------%s总命中包数量: %d----------------------\n", s.tag(), total)
+
+		// 每个套接字当前客户端地址的地址族
+		families := make([]string, len(s.hit_counts))
+		for index := range families {
+			families[index] = s.client_family(index)
+		}
 
 		// 输出统计信息
-		hit_mutex.Lock()
-		for index, count := range hit_counts {
-			fmt.Printf("套接字 %d 命中包数量: %d%%\n", index, count * 100 / total )
-			hit_counts[index] = 0
+		s.hit_mutex.Lock()
+		for index, count := range s.hit_counts {
+			fmt.Printf("套接字 %d（%s）命中包数量: %d%%\n", index, families[index], count*100/total)
+			s.hit_counts[index] = 0
 		}
-		hit_mutex.Unlock()
+		s.hit_mutex.Unlock()
 
 	}
 }
 
-// 发送数据包的线程
-func send_packet_thread(index int) {
+// 发送数据包的线程，发送队列关闭并取完后退出
+func (s *Server) send_packet_thread(index int) {
 	for {
-		// 判断是否有数据
-		if atomic.LoadInt64(&send_queue_point_list[index][0]) == atomic.LoadInt64(&send_queue_point_list[index][1]) {
-			// 没有数据，等待
-			time.Sleep(1 * time.Millisecond)
-			continue
+		// 等待数据，队列关闭并取完后退出
+		packet, ok := s.listen_record_send_queue[index].Pop()
+		if !ok {
+			return
 		}
 
-		// 判断地址是否存在
-		listen_record_add_mutex[index].Lock()
-		if len(listen_record_sockets[index].Addr) == 0 {
-			// 地址不存在，跳过
-			listen_record_add_mutex[index].Unlock()
+		// 判断地址是否存在、映射是否超时，不可用时丢弃（不能占着队列空转）
+		if !s.link_usable(index) {
+			atomic.AddInt64(&s.stale_drop_counts[index], 1)
 			continue
 		}
 
-		addr := listen_record_sockets[index].Addr
-		listen_record_add_mutex[index].Unlock()
+		s.listen_record_add_mutex[index].Lock()
+		addr := s.listen_record_sockets[index].Addr
+		socket := s.listen_record_sockets[index].Socket
+		validated := s.listen_record_validated_addr[index] == addr
+		obfs := s.listen_record_obfs[index]
+		s.listen_record_add_mutex[index].Unlock()
 
-		// 获取需要发送的数据
-		packet := listen_record_send_queue[index][atomic.LoadInt64(&send_queue_point_list[index][1])]
-
-		// 指向下一个数据
-		atomic.StoreInt64(&send_queue_point_list[index][1], (send_queue_point_list[index][1]+1)%send_queue_max_len)
+		// 地址尚未通过验证，限速发送，防止服务端被用作反射/放大流量的工具
+		if !validated && !s.unvalidated_limiters[index].Allow(1) {
+			atomic.AddInt64(&s.unvalidated_drop_counts[index], 1)
+			continue
+		}
 
 		addrOb, _ := net.ResolveUDPAddr("udp", addr)
 
 		// 发送数据
-		_, sendErr := listen_record_sockets[index].Socket.WriteToUDP(packet, addrOb)
+		_, sendErr := socket.WriteToUDP(s.engine.Obfuscate(obfs, packet), addrOb)
 
-		if sendErr != nil {
+		if errors.Is(sendErr, net.ErrClosed) {
+			// 实例已停止，剩余的包不再发送
+			return
+		} else if sendErr != nil {
 			fmt.Printf("数据表转发失败，客户端地址：%s\n", addr)
 		} else {
+			atomic.AddInt64(&s.sent_totals[index], 1)
 			// fmt.Printf("数据转发成功，客户端地址：%s\n", addr)
 		}
 	}
 }
 
-func Start(remote_ip_list []string, listen_ip_list []string, mtu int, m string) {
-	mode = m
+// Start 按配置创建并启动一个服务端实例，启动后立即返回，各线程在后台运行，Close停止
+// name为实例名称，只运行一个实例时可以为空
+func Start(name string, cfg Config) (*Server, error) {
+	s := &Server{
+		name:               name,
+		source_limiters:    new_source_limiter_table(),
+		reject_log_limiter: core.NewTokenBucket(1, 5),
+		refuse_log_limiter: core.NewTokenBucket(1, 5),
+		done:               make(chan struct{}),
+	}
+
+	s.running_config = cfg
+	s.mode.Store(cfg.Mode)
+	s.unvalidated_pps = cfg.UnvalidatedPPS
+	s.link_timeout.Store(int64(cfg.LinkTimeout))
+	s.resolve_interval.Store(int64(cfg.ResolveInterval))
+	s.stats_interval.Store(int64(cfg.StatsInterval))
+	listen_ip_list := cfg.Listen
+	mtu := cfg.MTU
+
+	// 转发地址的地址族
+	remote_network, err := core.ResolveNetwork(cfg.ForwardFamily)
+	if err != nil {
+		return nil, fmt.Errorf("转发地址的地址族配置错误: %v", err)
+	}
+
+	// 设置帧认证、加密与握手
+	if s.engine, err = core.NewEngine(cfg.Security, true); err != nil {
+		return nil, fmt.Errorf("初始化帧认证失败: %v", err)
+	}
+
+	// 初始化准入过滤
+	s.max_packet = mtu + s.engine.FrameOverhead() + s.engine.ObfsOverhead()
+	if err := s.setup_admission(cfg.Admission, len(listen_ip_list)); err != nil {
+		return nil, fmt.Errorf("初始化准入过滤失败: %v", err)
+	}
+
+	// 下发给客户端的参数（包含本端能力，需要在设置加密之后生成）
+	params, err := s.build_server_params(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("下发参数配置错误: %v", err)
+	}
+	s.server_params.Store(params)
+
+	if !s.engine.AuthEnabled() {
+		fmt.Println("警告：未启用帧认证（-psk或-key），任何来源的包都能改变客户端地址并被转发")
+	}
 
-	// 创建本地监听端口套接字群
-	create_cluster_listen_socket(listen_ip_list)
+	// 创建本地转发端口，嵌入运行时不需要
+	if s.deliver = cfg.Deliver; s.deliver == nil {
+		if err := s.create_remote_socket(cfg.Forward, remote_network); err != nil {
+			return nil, err
+		}
+	}
+
+	// 创建本地监听端口套接字群，失败时关闭已经创建的转发端口
+	if err := s.create_cluster_listen_socket(listen_ip_list); err != nil {
+		if remoteSocket := s.remote_con_socket.Load(); remoteSocket != nil {
+			remoteSocket.Close()
+		}
+		return nil, err
+	}
 
-	hit_counts = make([]int, len(listen_ip_list))
+	s.hit_counts = make([]int, len(listen_ip_list))
+	s.auth_fail_counts = make([]int64, len(listen_ip_list))
+	s.unvalidated_drop_counts = make([]int64, len(listen_ip_list))
+	s.stale_drop_counts = make([]int64, len(listen_ip_list))
+	s.sent_totals = make([]int64, len(listen_ip_list))
+	s.hit_totals = make([]int64, len(listen_ip_list))
+	s.auth_fail_totals = make([]int64, len(listen_ip_list))
 
 	// 初始化发送队列数组
-	listen_record_send_queue = make([][send_queue_max_len][]byte, len(listen_ip_list))
-	send_queue_point_list = make([][2]int64, len(listen_ip_list))
+	s.listen_record_send_queue = make([]*queue.Queue, len(listen_ip_list))
 
-	for index := range hit_counts {
-		hit_counts[index] = 0
-		listen_record_send_queue[index] = [send_queue_max_len][]byte{}
-		send_queue_point_list[index] = [2]int64{0, 0}
+	for index := range s.hit_counts {
+		s.hit_counts[index] = 0
+		s.listen_record_send_queue[index] = queue.New(send_queue_max_len)
 	}
 
 	// 本地监听端口监听信息
 	for i := 0; i < len(listen_ip_list); i++ {
-		recordSocket := listen_record_sockets[i]
-		addMutex := listen_record_add_mutex[i]
+		recordSocket := s.listen_record_sockets[i]
+		addMutex := s.listen_record_add_mutex[i]
 
 		// 判断值是否有效
 		if recordSocket == nil {
 			continue
 		}
 
-		// 启动监听线程
-		go handle_cluster_socket_info(recordSocket, addMutex, i, mtu)
+		// 启动监听线程，端口范围内的每个端口一个
+		for _, conn := range recordSocket.Sockets {
+			s.spawn(func() { s.handle_cluster_socket_info(recordSocket, conn, addMutex, i, mtu) })
+		}
 
 		// 启动写回线程
-		go send_packet_thread(i)
+		s.senders.Add(1)
+		s.spawn(func() {
+			defer s.senders.Done()
+			s.send_packet_thread(i)
+		})
 	}
 
-	// 创建本地转发端口
-	create_remote_socket(remote_ip_list[0])
+	if s.deliver == nil {
+		// 转发地址为域名时定期重新解析
+		if core.IsHostname(cfg.Forward) {
+			s.spawn(func() { s.watch_remote_socket(cfg.Forward, remote_network) })
+		}
+
+		// 监听本地端口输入
+		s.spawn(func() { s.handle_remote_socket_info(mtu) })
+	}
 
-	// 监听本地端口输入
-	go handle_remote_socket_info(mtu)
+	// 链路超时检查（未启用时也运行，重新加载配置后可能启用）
+	s.spawn(func() { s.watch_stale_links() })
+
+	// 清理来源限速表
+	s.spawn(func() { s.clean_source_limiters() })
 
 	// 输出命中统计
-	go print_hit_counts()
+	s.spawn(func() { s.print_hit_counts() })
 
-	fmt.Println("程序启动，等待客户端接入")
+	fmt.Printf("%s程序启动，等待客户端接入\n", s.tag())
+	return s, nil
+}
 
-	// 阻止主函数退出
-	select {}
+// 统计日志中区分实例的前缀，只运行一个实例（没有名称）时为空
+func (s *Server) tag() string {
+	if len(s.name) == 0 {
+		return ""
+	}
+	return "实例 " + s.name + " "
 }
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// 占用一个本机UDP端口
+func occupy_port(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// 返回一个当前空闲的本机UDP地址
+func free_addr(t *testing.T) string {
+	t.Helper()
+	conn := occupy_port(t)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func TestStartListenError(t *testing.T) {
+	free := free_addr(t)
+	busy := occupy_port(t).LocalAddr().String()
+
+	s, err := Start("", Config{
+		Listen:  []string{free, busy},
+		Forward: "127.0.0.1:9",
+		MTU:     1400,
+		Mode:    "mode1",
+	})
+	if err == nil {
+		s.Close()
+		t.Fatal("监听地址被占用时启动成功")
+	}
+	if !strings.Contains(err.Error(), "监听 "+busy+" 失败") {
+		t.Fatalf("错误信息 %q 中没有监听失败的地址 %s", err, busy)
+	}
+
+	// 启动失败时已经打开的监听端口被关闭
+	conn, err := net.ListenPacket("udp", free)
+	if err != nil {
+		t.Fatalf("启动失败后 %s 仍被占用: %v", free, err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Shutdown 平滑停止服务端实例：不再接收转发地址的包，等待各监听端口的发送队列发完（最多timeout），
+// 通知客户端会话关闭，然后Close并输出最终统计；可以重复调用，之后的调用等待停止完成
+func (s *Server) Shutdown(timeout time.Duration) {
+	if !s.input_closed.CompareAndSwap(false, true) || s.stopped() {
+		// 已经在停止中或已停止，等待停止完成
+		<-s.done
+		s.Close()
+		return
+	}
+
+	// 等待正在编码的包放入发送队列，之后send_remote不会再放入新的包
+	s.send_mutex.Lock()
+	s.send_mutex.Unlock()
+
+	// 关闭发送队列，发送线程发完剩余的包后退出；之后Close关闭套接字时不会有发送中的包
+
+	if remaining := s.drain(timeout); remaining > 0 {
+		fmt.Printf("%s发送队列在 %v 内没有发完，丢弃剩余的 %d 个包\n", s.tag(), timeout, remaining)
+	}
+
+	s.notify_close()
+
+	stats := s.Stats()
+	s.Close()
+	s.print_final_stats(stats)
+}
+
+// 关闭各监听端口的发送队列并等待发送线程退出（最多timeout），返回超时时仍未发送的包数
+func (s *Server) drain(timeout time.Duration) int {
+	for _, sendQueue := range s.listen_record_send_queue {
+		sendQueue.Close()
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		s.senders.Wait()
+		close(exited)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-exited:
+		return 0
+	case <-timer.C:
+		remaining := 0
+		for _, sendQueue := range s.listen_record_send_queue {
+			remaining += sendQueue.Len()
+		}
+		return remaining
+	}
+}
+
+// 向每条链路上记录的客户端地址发送会话关闭帧，使用客户端的混淆方式
+// 停止时每个地址只发送一次，不是对收到的包的回复，不会被用来放大流量
+func (s *Server) notify_close() {
+	for index, recordSocket := range s.listen_record_sockets {
+		if recordSocket == nil {
+			continue
+		}
+
+		s.listen_record_add_mutex[index].Lock()
+		addr := recordSocket.Addr
+		socket := recordSocket.Socket
+		obfs := s.listen_record_obfs[index]
+		s.listen_record_add_mutex[index].Unlock()
+
+		if len(addr) == 0 {
+			continue
+		}
+		addrOb, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			continue
+		}
+		// 每条链路分别生成，计数器不同，不会被客户端当作重放丢弃
+		if packet := s.engine.NewClose(); packet != nil {
+			socket.WriteToUDP(s.engine.Obfuscate(obfs, packet), addrOb)
+		}
+	}
+}
+
+// 客户端关闭会话：清除全部链路的客户端地址与协商结果，客户端重新启动后重新接入与协商
+// 启用认证时与地址更新一样只接受更新的帧，重放的旧关闭帧不能把客户端踢下线
+func (s *Server) handle_client_close(index int, buf []byte, addr *net.UDPAddr) {
+	frame, ok := s.engine.OpenClose(buf)
+	if !ok {
+		s.auth_failed(index)
+		return
+	}
+
+	s.listen_record_add_mutex[index].Lock()
+	fresh := s.engine.Newer(s.listen_record_last_mark[index], frame)
+	s.listen_record_add_mutex[index].Unlock()
+	if !fresh {
+		return
+	}
+
+	// 客户端在每条链路上都会发送，只在第一次清除时输出日志
+	cleared := false
+	for i, recordSocket := range s.listen_record_sockets {
+		if recordSocket == nil {
+			continue
+		}
+
+		s.listen_record_add_mutex[i].Lock()
+		if s.engine.Newer(s.listen_record_last_mark[i], frame) {
+			s.listen_record_last_mark[i] = frame.Mark()
+		}
+		if len(recordSocket.Addr) > 0 {
+			recordSocket.Addr = ""
+			s.listen_record_validated_addr[i] = ""
+			s.listen_record_last_heard[i] = time.Time{}
+			cleared = true
+		}
+		s.listen_record_add_mutex[i].Unlock()
+	}
+	s.client_agreement.Store(nil)
+	s.client_refused.Store(false)
+
+	if cleared {
+		fmt.Printf("[%s] %s客户端关闭会话（套接字 %d，来源：%s）\n", time.Now().Format("2006-01-02 15:04:05"), s.tag(), index, addr.String())
+	}
+}
+
+// 输出停止时的累计统计
+func (s *Server) print_final_stats(stats Stats) {
+	fmt.Printf("[%s] %s服务端已停止，从转发地址收到 %d 个包，转发到转发地址 %d 个包\n", time.Now().Format("2006-01-02 15:04:05"), s.tag(), stats.Input, stats.Output)
+	for index, link := range stats.Links {
+		if len(link.Listen) == 0 {
+			continue
+		}
+		fmt.Printf("套接字 %d（%s）发送 %d，命中 %d，认证失败 %d\n", index, link.Listen, link.Sent, link.Hits, link.AuthFailures)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import "sync/atomic"
+
+// Stats 服务端实例的累计统计，统计日志输出后清零的计数不影响这里
+type Stats struct {
+	// 从转发地址收到的包数
+	Input uint64
+
+	// 去重之后转发到转发地址的包数
+	Output uint64
+
+	// 是否已与客户端协商成功
+	Negotiated bool
+
+	// 每个监听端口（一条链路）的统计
+	Links []LinkStats
+}
+
+// LinkStats 一个监听端口的累计统计
+type LinkStats struct {
+	// 监听地址，监听失败时为空
+	Listen string
+
+	// 当前的客户端地址，没有客户端时为空
+	Client string
+
+	// 客户端地址是否已通过验证
+	Validated bool
+
+	// 链路当前是否可以用于下行发送
+	Up bool
+
+	// 发送成功的下行包数
+	Sent uint64
+
+	// 首先到达、被转发的包数
+	Hits uint64
+
+	// 认证失败的包数
+	AuthFailures uint64
+}
+
+// 记录一个认证失败的包
+func (s *Server) auth_failed(index int) {
+	atomic.AddInt64(&s.auth_fail_counts[index], 1)
+	atomic.AddInt64(&s.auth_fail_totals[index], 1)
+}
+
+// Stats 返回实例的累计统计
+func (s *Server) Stats() Stats {
+	stats := Stats{
+		Input:      uint64(atomic.LoadInt64(&s.input_total)),
+		Output:     uint64(atomic.LoadInt64(&s.output_total)),
+		Negotiated: s.client_agreement.Load() != nil && !s.client_refused.Load(),
+	}
+
+	for index, recordSocket := range s.listen_record_sockets {
+		item := LinkStats{
+			Sent:         uint64(atomic.LoadInt64(&s.sent_totals[index])),
+			Hits:         uint64(atomic.LoadInt64(&s.hit_totals[index])),
+			AuthFailures: uint64(atomic.LoadInt64(&s.auth_fail_totals[index])),
+		}
+		if recordSocket != nil {
+			s.listen_record_add_mutex[index].Lock()
+			item.Listen = recordSocket.Sockets[0].LocalAddr().String()
+			item.Client = recordSocket.Addr
+			item.Validated = len(recordSocket.Addr) > 0 && s.listen_record_validated_addr[index] == recordSocket.Addr
+			s.listen_record_add_mutex[index].Unlock()
+			item.Up = s.link_usable(index)
+		}
+		stats.Links = append(stats.Links, item)
+	}
+	return stats
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status 返回当前的协商结果与每个监听端口的客户端状态，供管理接口的 status 命令使用
+func (s *Server) Status() string {
+	var b strings.Builder
+
+	if agreement := s.client_agreement.Load(); s.client_refused.Load() {
+		fmt.Fprintf(&b, "协商：已拒绝客户端\n")
+	} else if agreement != nil {
+		fmt.Fprintf(&b, "协商：协议版本 %d，上行模式 %s，下行模式 %s，MTU %d，功能 %v\n", agreement.Version, agreement.UpMode, agreement.DownMode, agreement.MTU, agreement.Features)
+	} else {
+		fmt.Fprintf(&b, "协商：等待客户端请求，默认下行模式 %s\n", s.mode.Load().(string))
+	}
+
+	for index, recordSocket := range s.listen_record_sockets {
+		if recordSocket == nil {
+			fmt.Fprintf(&b, "套接字 %d：监听失败\n", index)
+			continue
+		}
+
+		s.listen_record_add_mutex[index].Lock()
+		addr := recordSocket.Addr
+		validated := len(addr) > 0 && s.listen_record_validated_addr[index] == addr
+		heard := s.listen_record_last_heard[index]
+		s.listen_record_add_mutex[index].Unlock()
+
+		listen := recordSocket.Socket.LocalAddr().String()
+		if len(recordSocket.Sockets) > 1 {
+			listen = fmt.Sprintf("%s（%d个端口）", listen, len(recordSocket.Sockets))
+		}
+
+		if len(addr) == 0 {
+			fmt.Fprintf(&b, "套接字 %d（%s）：没有客户端\n", index, listen)
+			continue
+		}
+
+		state := "可用"
+		if !s.link_usable(index) {
+			state = "超时"
+		}
+		validation := "已验证"
+		if !validated {
+			validation = "未验证"
+		}
+		fmt.Fprintf(&b, "套接字 %d（%s）：客户端 %s，%s，%s，%v前收到\n", index, listen, addr, validation, state, time.Since(heard).Round(time.Millisecond))
+	}
+	return b.String()
+}
+
+// Connected 判断是否至少有一条链路可以用于下行发送（有客户端并且没有超时）
+func (s *Server) Connected() bool {
+	for index := range s.listen_record_sockets {
+		if s.link_usable(index) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 平滑停止时等待发送队列发完的最长时间
+const shutdown_timeout = 3 * time.Second
+
+// 平滑停止一个实例
+func (inst *instance) shutdown(timeout time.Duration) {
+	if inst.server != nil {
+		inst.server.Shutdown(timeout)
+		return
+	}
+	inst.client.Shutdown(timeout)
+}
+
+// 同时平滑停止全部实例，全部停止后返回
+// 持有重新加载锁，停止过程中不会再应用新配置
+func (process *bridge_process) shutdown(timeout time.Duration) {
+	reload_mutex.Lock()
+	defer reload_mutex.Unlock()
+
+	var wait sync.WaitGroup
+	for _, inst := range process.instances {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			inst.shutdown(timeout)
+		}()
+	}
+	wait.Wait()
+}
+
+// 等待SIGINT或SIGTERM，收到后平滑停止全部实例；停止过程中再次收到信号时立即退出
+func wait_shutdown_signal(process *bridge_process) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	fmt.Printf("[%s] 收到%v，停止接收新的数据包，最多等待 %v 发完发送队列\n", time.Now().Format("2006-01-02 15:04:05"), sig, shutdown_timeout)
+
+	go func() {
+		sig := <-signals
+		fmt.Printf("[%s] 再次收到%v，立即退出\n", time.Now().Format("2006-01-02 15:04:05"), sig)
+		os.Exit(1)
+	}()
+
+	process.shutdown(shutdown_timeout)
+}
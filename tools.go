@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 测试包头：序号与发送时间（UnixNano），其余部分为填充
+const probe_header_len = 16
+
+// 测试包的发送与回复记录
+type probe_stats struct {
+	mutex    sync.Mutex
+	sent     map[uint64]time.Time
+	received map[uint64]bool
+	rtts     []time.Duration
+}
+
+func new_probe_stats() *probe_stats {
+	return &probe_stats{sent: make(map[uint64]time.Time), received: make(map[uint64]bool)}
+}
+
+// 生成一个测试包
+func new_probe(seq uint64, size int) []byte {
+	packet := make([]byte, max(size, probe_header_len))
+	binary.BigEndian.PutUint64(packet[0:8], seq)
+	binary.BigEndian.PutUint64(packet[8:16], uint64(time.Now().UnixNano()))
+	return packet
+}
+
+// 记录一个回复，返回序号与往返时延；重复的回复与不认识的包返回false
+func (stats *probe_stats) record(packet []byte) (uint64, time.Duration, bool) {
+	if len(packet) < probe_header_len {
+		return 0, 0, false
+	}
+	seq := binary.BigEndian.Uint64(packet[0:8])
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(packet[8:16])))
+
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	if _, exists := stats.sent[seq]; !exists || stats.received[seq] {
+		return seq, 0, false
+	}
+	rtt := time.Since(sent)
+	stats.received[seq] = true
+	stats.rtts = append(stats.rtts, rtt)
+	return seq, rtt, true
+}
+
+// 输出汇总：发送数、收到数、丢包率与时延分布
+func (stats *probe_stats) summary() {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	sent, received := len(stats.sent), len(stats.received)
+	if sent == 0 {
+		fmt.Println("没有发送任何包")
+		return
+	}
+	fmt.Printf("发送 %d，收到 %d，丢包 %.1f%%\n", sent, received, float64(sent-received)*100/float64(sent))
+	if received == 0 {
+		return
+	}
+
+	rtts := slices.Clone(stats.rtts)
+	slices.Sort(rtts)
+	var total time.Duration
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	fmt.Printf("往返时延 最小 %v，平均 %v，中位 %v，P99 %v，最大 %v\n", rtts[0], total/time.Duration(len(rtts)), rtts[len(rtts)/2], rtts[len(rtts)*99/100], rtts[len(rtts)-1])
+}
+
+// 回显服务：把收到的包原样发回，放在服务端转发地址上，与 ping、bench 配合使用
+func run_echo(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Printf("回显服务：%s\n", conn.LocalAddr().String())
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		conn.WriteToUDP(buf[:n], from)
+	}
+}
+
+// 连接目标地址，并启动接收回复的线程
+func dial_probe(target string, stats *probe_stats, onReply func(seq uint64, rtt time.Duration)) (*net.UDPConn, error) {
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			if seq, rtt, ok := stats.record(buf[:n]); ok && onReply != nil {
+				onReply(seq, rtt)
+			}
+		}
+	}()
+	return conn, nil
+}
+
+// ping：按间隔发送测试包到客户端的本地监听地址，经过桥接到达服务端转发地址上的回显服务后返回
+func run_ping(args []string) error {
+	flags := new_flag_set("ping", "测量经过桥接的往返时延与丢包：-target 为客户端的本地监听地址，服务端的转发地址上运行 ping -echo")
+	echo := flags.String("echo", "", "运行回显服务并监听该地址（放在服务端的转发地址上） 参数值示例：127.0.0.1:51820")
+	target := flags.String("target", "127.0.0.1:8000", "发送目标，一般为客户端的本地监听地址")
+	interval := flags.Duration("interval", time.Second, "发送间隔")
+	count := flags.Int("count", 10, "发送个数，0表示一直发送直到Ctrl+C")
+	size := flags.Int("size", 1000, "测试包大小（字节）")
+	timeout := flags.Duration("timeout", 2*time.Second, "等待回复的时间，超过后计为丢包")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*echo) > 0 {
+		return run_echo(*echo)
+	}
+
+	stats := new_probe_stats()
+	conn, err := dial_probe(*target, stats, func(seq uint64, rtt time.Duration) {
+		fmt.Printf("序号 %d：往返 %v\n", seq, rtt)
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	fmt.Printf("向 %s 发送 %d 字节的测试包，间隔 %v\n", *target, *size, *interval)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for seq := uint64(1); *count <= 0 || seq <= uint64(*count); seq++ {
+		stats.mutex.Lock()
+		stats.sent[seq] = time.Now()
+		stats.mutex.Unlock()
+		if _, err := conn.Write(new_probe(seq, *size)); err != nil {
+			fmt.Printf("序号 %d：发送失败：%v\n", seq, err)
+		}
+
+		// 超过等待时间仍未回复的包计为超时
+		go func(seq uint64) {
+			time.Sleep(*timeout)
+			stats.mutex.Lock()
+			lost := !stats.received[seq]
+			stats.mutex.Unlock()
+			if lost {
+				fmt.Printf("序号 %d：超时\n", seq)
+			}
+		}(seq)
+
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			stats.summary()
+			return nil
+		}
+	}
+
+	// 等待最后一个包的回复
+	time.Sleep(*timeout)
+	stats.summary()
+	return nil
+}
+
+// bench：以固定速率发送测试包，每秒输出一次吞吐与丢包，结束时输出时延分布
+func run_bench(args []string) error {
+	flags := new_flag_set("bench", "以固定速率压测经过桥接的吞吐、丢包与时延：-target 为客户端的本地监听地址，服务端的转发地址上运行 ping -echo")
+	target := flags.String("target", "127.0.0.1:8000", "发送目标，一般为客户端的本地监听地址")
+	rate := flags.Int("rate", 1000, "每秒发送的包数")
+	size := flags.Int("size", 1200, "测试包大小（字节）")
+	duration := flags.Duration("duration", 10*time.Second, "压测时长")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return errors.New("-rate 需要大于0")
+	}
+
+	stats := new_probe_stats()
+	conn, err := dial_probe(*target, stats, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Printf("向 %s 以每秒 %d 个、每个 %d 字节的速率发送 %v\n", *target, *rate, *size, *duration)
+	start := time.Now()
+	gap := time.Second / time.Duration(*rate)
+	next_report := start.Add(time.Second)
+	last_sent, last_received := 0, 0
+
+	for seq := uint64(1); time.Since(start) < *duration; seq++ {
+		// 按发送计划补齐，避免定时器精度拉低速率
+		if wait := time.Until(start.Add(time.Duration(seq-1) * gap)); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		stats.mutex.Lock()
+		stats.sent[seq] = time.Now()
+		stats.mutex.Unlock()
+		conn.Write(new_probe(seq, *size))
+
+		if time.Now().After(next_report) {
+			next_report = next_report.Add(time.Second)
+			stats.mutex.Lock()
+			sent, received := len(stats.sent), len(stats.received)
+			stats.mutex.Unlock()
+			fmt.Printf("[%v] 发送 %d 包/秒，收到 %d 包/秒，%.2f Mbps\n", time.Since(start).Round(time.Second), sent-last_sent, received-last_received, float64((received-last_received)*(*size)*8)/1e6)
+			last_sent, last_received = sent, received
+		}
+	}
+
+	// 等待在途的回复
+	time.Sleep(1 * time.Second)
+	stats.summary()
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"UDPRainbowBridge/core"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 运行环境检查结果的输出
+type env_checker struct {
+	out    io.Writer
+	failed int
+}
+
+// 记录一项检查结果
+func (c *env_checker) check(item string, detail string, err error) {
+	if err != nil {
+		c.failed++
+		fmt.Fprintf(c.out, "  失败  %s：%v\n", item, err)
+		return
+	}
+	fmt.Fprintf(c.out, "  通过  %s：%s\n", item, detail)
+}
+
+// 输出一条不影响结果的提示
+func (c *env_checker) note(item string, detail string) {
+	fmt.Fprintf(c.out, "  提示  %s：%s\n", item, detail)
+}
+
+// 检查运行环境：解析全部地址、检查网卡与端口能否绑定，不发送任何数据，返回失败的项数
+func check_environment(cfg *Config, out io.Writer) int {
+	c := &env_checker{out: out}
+
+	if cfg.Role == "server" {
+		check_server_environment(cfg, c)
+	} else {
+		check_client_environment(cfg, c)
+	}
+
+	check_admin_environment(cfg, c)
+	return c.failed
+}
+
+// 检查管理接口地址能否监听，配置文件包含多个实例时在全部实例检查完之后单独检查
+func check_admin_environment(cfg *Config, c *env_checker) {
+	if len(cfg.Observability.Admin) > 0 {
+		listener, err := net.Listen("tcp", cfg.Observability.Admin)
+		if err == nil {
+			listener.Close()
+		}
+		c.check("observability.admin", cfg.Observability.Admin+" 可以监听", err)
+	}
+}
+
+// 检查客户端：本地监听地址能否绑定，服务端地址能否解析，发送地址（网卡或IP）是否可用
+func check_client_environment(cfg *Config, c *env_checker) {
+	listen := cfg.Tunnels[0].Listen
+	c.check("tunnels[0].listen", listen+" 可以绑定", bind_udp(listen))
+
+	if len(cfg.Server) > 0 {
+		resolved, err := resolve_remote(cfg.Server, "")
+		c.check("server", cfg.Server+" -> "+resolved, err)
+	}
+
+	for index, link := range cfg.Links {
+		if len(link.Remote) > 0 {
+			resolved, err := resolve_remote(link.Remote, link.Family)
+			c.check(link_field(index, link, "remote"), link.Remote+" -> "+resolved, err)
+		}
+
+		if len(link.Local) > 0 {
+			detail, err := check_local_available(link.Local)
+			c.check(link_field(index, link, "local"), detail, err)
+		}
+	}
+
+	if len(cfg.Discover) > 0 {
+		names := discover_candidates(cfg.Discover)
+		if len(names) == 0 {
+			c.note("discover", "当前没有匹配的网卡，网卡出现后会自动创建链路")
+		} else {
+			c.note("discover", "当前匹配的网卡："+strings.Join(names, "、"))
+		}
+	}
+}
+
+// 检查服务端：每个监听端口能否绑定，转发地址与下发地址能否解析
+func check_server_environment(cfg *Config, c *env_checker) {
+	for index, link := range cfg.Links {
+		detail, err := bind_udp_range(link.Listen)
+		c.check(link_field(index, link, "listen"), detail, err)
+
+		if host, _, _ := net.SplitHostPort(link.Advertise); len(host) > 0 {
+			resolved, err := resolve_remote(link.Advertise, "")
+			c.check(link_field(index, link, "advertise"), link.Advertise+" -> "+resolved, err)
+		}
+	}
+
+	forward := cfg.Tunnels[0].Forward
+	resolved, err := resolve_remote(forward, cfg.Tunnels[0].ForwardFamily)
+	c.check("tunnels[0].forward", forward+" -> "+resolved, err)
+}
+
+// 按地址族解析远程地址，端口为范围时使用第一个端口
+func resolve_remote(addr string, family string) (string, error) {
+	network, err := core.ResolveNetwork(family)
+	if err != nil {
+		return "", err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	min, _, err := core.ParsePortRange(port)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := net.ResolveUDPAddr(network, net.JoinHostPort(host, strconv.Itoa(min)))
+	if err != nil {
+		return "", err
+	}
+	return resolved.String(), nil
+}
+
+// 尝试绑定一个UDP地址后立即关闭
+func bind_udp(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// 尝试绑定端口范围内的每个端口
+func bind_udp_range(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	min, max, err := core.ParsePortRange(port)
+	if err != nil {
+		return "", err
+	}
+
+	for p := min; p <= max; p++ {
+		if err := bind_udp(net.JoinHostPort(host, strconv.Itoa(p))); err != nil {
+			return "", err
+		}
+	}
+
+	if min == max {
+		return addr + " 可以绑定", nil
+	}
+	return fmt.Sprintf("%s 的 %d 个端口都可以绑定", addr, max-min+1), nil
+}
+
+// 检查发送地址：网卡名称需要存在、已启用并有地址，IP需要可以绑定（端口为范围时检查第一个端口）
+func check_local_available(local string) (string, error) {
+	host, port, err := net.SplitHostPort(local)
+	if err != nil {
+		host, port = local, "0"
+	}
+	min, _, err := core.ParsePortRange(port)
+	if err != nil {
+		return "", err
+	}
+
+	if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip != nil || len(host) == 0 {
+		addr := net.JoinHostPort(host, strconv.Itoa(min))
+		return addr + " 可以绑定", bind_udp(addr)
+	}
+
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return "", fmt.Errorf("网卡 %s 不存在", host)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "", fmt.Errorf("网卡 %s 未启用", host)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	var ips []string
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("网卡 %s 没有地址", host)
+	}
+	return fmt.Sprintf("网卡 %s 可用（%s）", host, strings.Join(ips, "、")), nil
+}
+
+// 当前匹配自动发现模式的网卡：已启用、非回环
+func discover_candidates(patterns []string) []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, iface.Name); matched {
+				names = append(names, iface.Name)
+				break
+			}
+		}
+	}
+	return names
+}